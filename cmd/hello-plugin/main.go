@@ -0,0 +1,82 @@
+// Command hello-plugin is a sample out-of-process plugin. It greets players
+// when they join, adds the /hello command and prevents players from placing
+// TNT.
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+	"github.com/andreasgoulas/go-mcc/mcc/rpcplugin"
+)
+
+func main() {
+	log.SetFlags(0)
+	client := rpcplugin.NewClient(rpcplugin.Info{
+		Name:    "Hello",
+		Version: "1.0",
+	})
+
+	client.OnEnable = enable
+	client.OnDisable = func(client *rpcplugin.Client) {
+		log.Println("disabled")
+	}
+
+	if err := client.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func enable(client *rpcplugin.Client) error {
+	err := client.AddCommand(rpcplugin.Command{
+		Name:        "hello",
+		Description: "Say hello.",
+		Usage:       "/hello [player]",
+	}, func(sender, message string) []string {
+		if len(message) == 0 {
+			return []string{"Hello, " + sender + "!"}
+		}
+
+		target := strings.TrimSpace(message)
+		if err := client.SendMessage(target, sender+" says hello!"); err != nil {
+			return []string{err.Error()}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = client.Subscribe(rpcplugin.EventPlayerJoin, mcc.PriorityMonitor, func(event *rpcplugin.Event) {
+		players, err := client.Players()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		client.Broadcast("Say hello to " + event.Player + "! " + pluralize(len(players), "player") + " online.")
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return client.Subscribe(rpcplugin.EventBlockPlace, mcc.PriorityNormal, func(event *rpcplugin.Event) {
+		if event.Block == mcc.BlockTNT {
+			event.Cancel = true
+			client.SendMessage(event.Player, "TNT is not allowed here!")
+		}
+	})
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return strconv.Itoa(n) + " " + noun + "s"
+}
@@ -0,0 +1,339 @@
+// Command loadtest connects simulated players to a local server and reports
+// the latency, map download time and dropped connections.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/client"
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+const (
+	eyeHeight = 51.0 / 32
+	walkSpeed = 4.0
+)
+
+type options struct {
+	addr     string
+	clients  int
+	prefix   string
+	ramp     time.Duration
+	duration time.Duration
+	interval time.Duration
+	timeout  time.Duration
+	noCPE    bool
+
+	moveRate  float64
+	chatRate  float64
+	blockRate float64
+	pingRate  float64
+}
+
+func parseOptions() *options {
+	opts := &options{}
+	flag.StringVar(&opts.addr, "addr", "127.0.0.1:25565", "address of the local server")
+	flag.IntVar(&opts.clients, "n", 10, "number of simulated clients")
+	flag.StringVar(&opts.prefix, "prefix", "bot", "prefix of the client names")
+	flag.DurationVar(&opts.ramp, "ramp", 100*time.Millisecond, "delay between connections")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "duration of the test")
+	flag.DurationVar(&opts.interval, "report", 5*time.Second, "interval between reports")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "login timeout")
+	flag.BoolVar(&opts.noCPE, "no-cpe", false, "connect without the protocol extensions")
+	flag.Float64Var(&opts.moveRate, "move-rate", 10, "movement updates per second per client")
+	flag.Float64Var(&opts.chatRate, "chat-rate", 0.1, "chat messages per second per client")
+	flag.Float64Var(&opts.blockRate, "block-rate", 0.5, "block changes per second per client")
+	flag.Float64Var(&opts.pingRate, "ping-rate", 0.5, "latency probes per second per client")
+	flag.Parse()
+	return opts
+}
+
+// checkLocal reports an error if addr does not refer to the local machine.
+func checkLocal(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return fmt.Errorf("%s is not a local address", host)
+		}
+	}
+
+	return nil
+}
+
+// ticker returns a channel that fires rate times per second, or nil if rate
+// is not positive.
+func ticker(rate float64) (<-chan time.Time, func()) {
+	if rate <= 0 {
+		return nil, func() {}
+	}
+
+	t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	return t.C, t.Stop
+}
+
+// bot is a simulated player.
+type bot struct {
+	id    int
+	opts  *options
+	stats *stats
+	rng   *rand.Rand
+
+	lock      sync.Mutex
+	mapStart  time.Time
+	placed    bool
+	target    [3]int
+	heading   float64
+	closing   bool
+	lastLevel *client.Level
+}
+
+func (b *bot) handlePacket(c *client.Client, packet proto.Packet) {
+	if _, ok := packet.(*proto.LevelInitialize); ok {
+		b.lock.Lock()
+		b.mapStart = time.Now()
+		b.lock.Unlock()
+	}
+}
+
+func (b *bot) handleLevel(c *client.Client, level *client.Level) {
+	b.lock.Lock()
+	start := b.mapStart
+	b.lastLevel = level
+	b.lock.Unlock()
+
+	if !start.IsZero() {
+		b.stats.mapload.add(time.Since(start))
+	}
+}
+
+func (b *bot) handleDisconnect(c *client.Client, err error) {
+	b.lock.Lock()
+	closing := b.closing
+	b.lock.Unlock()
+
+	b.stats.update(func(s *stats) {
+		s.active--
+		if !closing {
+			s.dropped++
+			if err != nil {
+				s.errors["dropped: "+err.Error()]++
+			}
+		}
+	})
+}
+
+func (b *bot) run(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	start := time.Now()
+	c, err := client.Dial(b.opts.addr, &client.Config{
+		Name:             fmt.Sprintf("%s%d", b.opts.prefix, b.id),
+		AppName:          "go-mcc loadtest",
+		DisableCPE:       b.opts.noCPE,
+		Timeout:          b.opts.timeout,
+		HandlePacket:     b.handlePacket,
+		HandleLevel:      b.handleLevel,
+		HandleDisconnect: b.handleDisconnect,
+	})
+
+	if err != nil {
+		b.stats.update(func(s *stats) {
+			s.failed++
+			s.errors["connect: "+err.Error()]++
+		})
+		return
+	}
+
+	b.stats.login.add(time.Since(start))
+	b.stats.update(func(s *stats) {
+		s.connected++
+		s.active++
+	})
+
+	move, stopMove := ticker(b.opts.moveRate)
+	defer stopMove()
+	chat, stopChat := ticker(b.opts.chatRate)
+	defer stopChat()
+	block, stopBlock := ticker(b.opts.blockRate)
+	defer stopBlock()
+	ping, stopPing := ticker(b.opts.pingRate)
+	defer stopPing()
+
+	lastMove := time.Now()
+	messages := 0
+	for {
+		select {
+		case <-stop:
+			b.lock.Lock()
+			b.closing = true
+			b.lock.Unlock()
+			c.Close()
+			return
+
+		case <-c.Done():
+			return
+
+		case now := <-move:
+			if b.walk(c, now.Sub(lastMove).Seconds()) {
+				b.stats.update(func(s *stats) { s.moves++ })
+			}
+			lastMove = now
+
+		case <-chat:
+			messages++
+			message := fmt.Sprintf("Load test message %d from %s", messages, c.Name())
+			if c.SendMessage(message) == nil {
+				b.stats.update(func(s *stats) { s.messages++ })
+			}
+
+		case <-block:
+			if b.changeBlock(c) {
+				b.stats.update(func(s *stats) { s.blocks++ })
+			}
+
+		case <-ping:
+			if !c.HasExtension("TwoWayPing") {
+				continue
+			}
+
+			go func() {
+				d, err := c.Ping(b.opts.timeout)
+				if err == nil {
+					b.stats.ping.add(d)
+				}
+			}()
+		}
+	}
+}
+
+// walk moves the bot in a random direction, staying inside the level.
+func (b *bot) walk(c *client.Client, dt float64) bool {
+	b.lock.Lock()
+	level := b.lastLevel
+	b.heading += (b.rng.Float64() - 0.5) * math.Pi / 4
+	heading := b.heading
+	b.lock.Unlock()
+
+	if level == nil {
+		return false
+	}
+
+	location := c.Location()
+	dist := math.Min(dt, 1) * walkSpeed
+	x := location.X + math.Cos(heading)*dist
+	z := location.Z + math.Sin(heading)*dist
+	if x < 1 || z < 1 || x > float64(level.Width-1) || z > float64(level.Length-1) {
+		b.lock.Lock()
+		b.heading += math.Pi
+		b.lock.Unlock()
+		return false
+	}
+
+	location.X, location.Z = x, z
+	location.Yaw = math.Mod(heading*180/math.Pi+450, 360)
+	return c.Move(location) == nil
+}
+
+// changeBlock alternately places and breaks a block next to the bot.
+func (b *bot) changeBlock(c *client.Client) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.placed {
+		b.placed = false
+		return c.BreakBlock(b.target[0], b.target[1], b.target[2]) == nil
+	}
+
+	location := c.Location()
+	b.target = [3]int{
+		int(location.X) + b.rng.Intn(5) - 2,
+		int(location.Y - eyeHeight),
+		int(location.Z) + b.rng.Intn(5) - 2,
+	}
+
+	if b.lastLevel == nil || !b.lastLevel.InBounds(b.target[0], b.target[1], b.target[2]) {
+		return false
+	}
+
+	block := uint16(1 + b.rng.Intn(36))
+	b.placed = true
+	return c.PlaceBlock(b.target[0], b.target[1], b.target[2], block) == nil
+}
+
+func main() {
+	opts := parseOptions()
+	if err := checkLocal(opts.addr); err != nil {
+		log.Fatalf("loadtest: %s\n", err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	s := newStats()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < opts.clients; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			wg.Add(1)
+			b := &bot{
+				id:    i,
+				opts:  opts,
+				stats: s,
+				rng:   rand.New(rand.NewSource(start.UnixNano() + int64(i))),
+			}
+
+			go b.run(stop, &wg)
+			time.Sleep(opts.ramp)
+		}
+	}()
+
+	report := time.NewTicker(opts.interval)
+	defer report.Stop()
+
+	deadline := time.After(opts.duration)
+loop:
+	for {
+		select {
+		case <-report.C:
+			s.report(os.Stdout, time.Since(start))
+		case <-deadline:
+			break loop
+		case <-interrupt:
+			break loop
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	fmt.Println("Final results:")
+	s.report(os.Stdout, time.Since(start))
+}
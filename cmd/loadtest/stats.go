@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// samples collects durations and summarizes their distribution.
+type samples struct {
+	lock   sync.Mutex
+	values []time.Duration
+}
+
+func (s *samples) add(d time.Duration) {
+	s.lock.Lock()
+	s.values = append(s.values, d)
+	s.lock.Unlock()
+}
+
+func (s *samples) summary() string {
+	s.lock.Lock()
+	values := make([]time.Duration, len(s.values))
+	copy(values, s.values)
+	s.lock.Unlock()
+
+	if len(values) == 0 {
+		return "n=0"
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var sum time.Duration
+	for _, v := range values {
+		sum += v
+	}
+
+	percentile := func(p int) time.Duration {
+		return values[(len(values)-1)*p/100]
+	}
+
+	return fmt.Sprintf("n=%d min=%s avg=%s p50=%s p95=%s p99=%s max=%s",
+		len(values),
+		round(values[0]),
+		round(sum/time.Duration(len(values))),
+		round(percentile(50)),
+		round(percentile(95)),
+		round(percentile(99)),
+		round(values[len(values)-1]))
+}
+
+func round(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+
+	return d.Round(time.Microsecond)
+}
+
+// stats holds the results of a load test.
+type stats struct {
+	lock sync.Mutex
+
+	connected int
+	active    int
+	failed    int
+	dropped   int
+	errors    map[string]int
+
+	messages int
+	moves    int
+	blocks   int
+
+	ping    samples
+	login   samples
+	mapload samples
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) update(fn func(s *stats)) {
+	s.lock.Lock()
+	fn(s)
+	s.lock.Unlock()
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fmt.Fprintf(w, "[%s] active=%d connected=%d failed=%d dropped=%d\n",
+		elapsed.Round(time.Second), s.active, s.connected, s.failed, s.dropped)
+	fmt.Fprintf(w, "  sent:    messages=%d moves=%d blocks=%d\n",
+		s.messages, s.moves, s.blocks)
+	fmt.Fprintf(w, "  login:   %s\n", s.login.summary())
+	fmt.Fprintf(w, "  map:     %s\n", s.mapload.summary())
+	fmt.Fprintf(w, "  latency: %s\n", s.ping.summary())
+
+	if len(s.errors) > 0 {
+		reasons := make([]string, 0, len(s.errors))
+		for reason := range s.errors {
+			reasons = append(reasons, reason)
+		}
+
+		sort.Strings(reasons)
+		fmt.Fprintf(w, "  errors:\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "    %5d %s\n", s.errors[reason], reason)
+		}
+	}
+}
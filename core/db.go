@@ -73,6 +73,14 @@ INSERT INTO config(cfg_key, cfg_value)
 VALUES("default_rank", "");
 `
 
+const dbSchemaBlockedIPs = `
+CREATE TABLE IF NOT EXISTS blocked_ips(
+	ip TEXT PRIMARY KEY,
+	reason TEXT,
+	until DATETIME NOT NULL
+);
+`
+
 type dbLevel struct {
 	MOTD    string `db:"motd"`
 	Physics bool   `db:"physics"`
@@ -87,6 +95,11 @@ type dbPlayer struct {
 	Mute       bool           `db:"mute"`
 }
 
+type dbBlockedIP struct {
+	IP    string    `db:"ip"`
+	Until time.Time `db:"until"`
+}
+
 type dbRank struct {
 	Name        string         `db:"name"`
 	Tag         sql.NullString `db:"tag"`
@@ -122,6 +135,7 @@ func newDb(path string) *db {
 	if version == 0 {
 		pdb.MustExec(dbSchema)
 	}
+	pdb.MustExec(dbSchemaBlockedIPs)
 
 	return &db{DB: pdb}
 }
@@ -150,6 +164,18 @@ func (db *db) unbanIP(ip string) bool {
 	return rows > 0
 }
 
+func (db *db) blockIP(ip, reason string, until time.Time) {
+	db.MustExec(`
+REPLACE INTO blocked_ips(ip, reason, until)
+VALUES(?, ?, ?)`, ip, reason, until)
+}
+
+func (db *db) queryBlockedIPs() (ips []dbBlockedIP) {
+	db.MustExec("DELETE FROM blocked_ips WHERE until <= ?", time.Now())
+	db.Select(&ips, "SELECT ip, until FROM blocked_ips")
+	return
+}
+
 func (db *db) checkBan(addr, name string) (bool, string) {
 	var reason sql.NullString
 	err := db.Get(&reason, `
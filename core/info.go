@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 	"time"
@@ -103,3 +104,27 @@ func (plugin *plugin) handleSeen(sender mcc.CommandSender, command *mcc.Command,
 		sender.SendMessage("Player " + args[0] + " not found")
 	}
 }
+
+func (plugin *plugin) handleTps(sender mcc.CommandSender, command *mcc.Command, message string) {
+	if len(message) != 0 {
+		command.PrintUsage(sender)
+		return
+	}
+
+	stats := sender.Server().TickStats()
+	sender.SendMessage(fmt.Sprintf("TPS: %.1f (1s), %.1f (10s), %.1f (1m)",
+		stats.TPS1s, stats.TPS10s, stats.TPS1m))
+	sender.SendMessage(fmt.Sprintf("Tick: %s mean, %s max",
+		fmtTick(stats.MeanTick), fmtTick(stats.MaxTick)))
+	sender.SendMessage(fmt.Sprintf("Last minute: %d overruns, %d skipped ticks",
+		stats.Overruns, stats.Skipped))
+
+	for i, phase := range stats.Phases {
+		if i == 3 {
+			break
+		}
+
+		sender.SendMessage(fmt.Sprintf("  %s: %s mean, %s max",
+			phase.Name, fmtTick(phase.Mean), fmtTick(phase.Max)))
+	}
+}
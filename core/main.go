@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"strings"
 	"sync"
 	"time"
@@ -76,6 +77,10 @@ func (player *player) isIgnored(name string) bool {
 type plugin struct {
 	db *db
 
+	// commandsLock is held for reading by the running commands, so that
+	// Disable can wait for them before it closes the database.
+	commandsLock sync.RWMutex
+
 	defaultRank string
 	ranks       map[string]*mcc.Rank
 	ranksLock   sync.RWMutex
@@ -93,21 +98,34 @@ func Initialize() mcc.Plugin {
 		return nil
 	}
 
-	return &plugin{
-		db:      db,
-		levels:  make(map[string]*level),
-		players: make(map[string]*player),
-	}
+	return &plugin{db: db}
 }
 
 func (plugin *plugin) Name() string {
 	return "Core"
 }
 
-func (plugin *plugin) Enable(server *mcc.Server) {
+func (plugin *plugin) Info() mcc.PluginInfo {
+	return mcc.PluginInfo{
+		Name:    "Core",
+		Version: "1.0",
+		Authors: []string{"Andreas Goulas"},
+	}
+}
+
+func (plugin *plugin) Enable(server *mcc.Server) error {
+	if plugin.db == nil {
+		if plugin.db = newDb("core.db"); plugin.db == nil {
+			return errors.New("could not open the database")
+		}
+	}
+
+	plugin.levels = make(map[string]*level)
+	plugin.players = make(map[string]*player)
 	plugin.loadRanks()
 
-	server.AddCommand(&mcc.Command{
+	registrar := server.Registrar(plugin)
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "back",
 		Description: "Return to your location before your last teleportation.",
 		Usage:       "/back",
@@ -115,7 +133,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleBack,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "ban",
 		Description: "Ban a player from the server.",
 		Usage:       "/ban <player> [reason]",
@@ -123,7 +141,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleBan,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "banip",
 		Description: "Ban an IP address from the server.",
 		Usage:       "/banip <ip> [reason]",
@@ -131,14 +149,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleBanIp,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "commands",
 		Description: "List all commands.",
 		Usage:       "/commands",
 		Handler:     plugin.handleCommands,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "copylvl",
 		Description: "Copy a level.",
 		Usage:       "/copylvl <src> <dst>",
@@ -146,7 +164,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleCopyLvl,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "env",
 		Description: "Change the environment of the current level.",
 		Usage:       "/env <option> <value>\n/env reset",
@@ -154,28 +172,28 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleEnv,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "goto",
 		Description: "Move to another level.",
 		Usage:       "/goto <level>",
 		Handler:     plugin.handleGoto,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "help",
 		Description: "Describe a command.",
 		Usage:       "/help <command>",
 		Handler:     plugin.handleHelp,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "ignore",
 		Description: "Ignore chat from a player",
 		Usage:       "/ignore [player]",
 		Handler:     plugin.handleIgnore,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "kick",
 		Description: "Kick a player from the server.",
 		Usage:       "/kick <player> [reason]",
@@ -183,14 +201,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleKick,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "levels",
 		Description: "List all loaded levels.",
 		Usage:       "/levels",
 		Handler:     plugin.handleLevels,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "load",
 		Description: "Load a level.",
 		Usage:       "/load <level>",
@@ -198,7 +216,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleLoad,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "main",
 		Description: "Set the main level.",
 		Usage:       "/main [level]",
@@ -206,14 +224,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleMain,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "me",
 		Description: "Broadcast an action.",
 		Usage:       "/me <action>",
 		Handler:     plugin.handleMe,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "mute",
 		Description: "Mute a player.",
 		Usage:       "/mute <player>",
@@ -221,7 +239,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleMute,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "newlvl",
 		Description: "Create a new level.",
 		Usage:       "/newlvl <name> <width> <height> <length> <theme> [<args>...]",
@@ -229,7 +247,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleNewLvl,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "nick",
 		Description: "Set the nickname of a player",
 		Usage:       "/nick <player> [nick]",
@@ -237,14 +255,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleNick,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "players",
 		Description: "List all players.",
 		Usage:       "/players [level]",
 		Handler:     plugin.handlePlayers,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "physics",
 		Description: "Set the physics state of a level.",
 		Usage:       "/physics <level> <value>\n/physics <value>",
@@ -252,14 +270,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handlePhysics,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "r",
 		Description: "Reply to the last message.",
 		Usage:       "/r <message>",
 		Handler:     plugin.handleR,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "rank",
 		Description: "Set the rank of a player.",
 		Usage:       "/rank <player> [rank]",
@@ -267,7 +285,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleRank,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "save",
 		Description: "Save a level.",
 		Usage:       "/save <level>\n/save all",
@@ -275,7 +293,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleSave,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "say",
 		Description: "Broadcast a message.",
 		Usage:       "/say <message>",
@@ -283,14 +301,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleSay,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "seen",
 		Description: "Check when a player was last online.",
 		Usage:       "/seen <player>",
 		Handler:     plugin.handleSeen,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "setspawn",
 		Description: "Set the spawn location of the level to your location.",
 		Usage:       "/setspawn [player]",
@@ -298,7 +316,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleSetSpawn,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "skin",
 		Description: "Set the skin of a player.",
 		Usage:       "/skin <player> <skin>",
@@ -306,14 +324,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleSkin,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "spawn",
 		Description: "Teleport to the spawn location of the level.",
 		Usage:       "/spawn",
 		Handler:     plugin.handleSpawn,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "summon",
 		Description: "Summon a player to your location.",
 		Usage:       "/summon <player>\n/summon all",
@@ -321,7 +339,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleSummon,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "unload",
 		Description: "Unload a level.",
 		Usage:       "/unload <level>",
@@ -329,14 +347,14 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleUnload,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "tell",
 		Description: "Send a private message to a player.",
 		Usage:       "/tell <player> <message>",
 		Handler:     plugin.handleTell,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "tp",
 		Description: "Teleport to another player.",
 		Usage:       "/tp <player>\n/tp <x> <y> <z>",
@@ -344,7 +362,15 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleTp,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
+		Name:        "tps",
+		Description: "Show the recent tick statistics.",
+		Usage:       "/tps",
+		Permissions: PermOperator,
+		Handler:     plugin.handleTps,
+	})
+
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "unban",
 		Description: "Remove the ban for a player.",
 		Usage:       "/unban <player>",
@@ -352,7 +378,7 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleUnban,
 	})
 
-	server.AddCommand(&mcc.Command{
+	plugin.addCommand(registrar, &mcc.Command{
 		Name:        "unbanip",
 		Description: "Remove the ban for an IP address.",
 		Usage:       "/unbanip <ip>",
@@ -360,33 +386,37 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 		Handler:     plugin.handleUnbanIp,
 	})
 
-	server.AddHandler(mcc.EventTypePlayerLogin, plugin.handlePlayerLogin)
-	server.AddHandler(mcc.EventTypePlayerChat, plugin.handlePlayerChat)
+	registrar.Subscribe(mcc.PriorityHighest, plugin.handlePlayerLogin)
+	registrar.Subscribe(mcc.PriorityNormal, plugin.handlePlayerChat)
 
-	server.AddHandler(mcc.EventTypePlayerJoin, func(eventType int, event interface{}) {
-		e := event.(*mcc.EventPlayerJoin)
+	registrar.Subscribe(mcc.PriorityNormal, func(e *mcc.EventAddrBlock) {
+		plugin.db.blockIP(e.Addr, e.Reason, e.Until)
+	})
+
+	registrar.Subscribe(mcc.PriorityLowest, func(e *mcc.EventPlayerJoin) {
 		plugin.addPlayer(e.Player)
 	})
 
-	server.AddHandler(mcc.EventTypePlayerQuit, func(eventType int, event interface{}) {
-		e := event.(*mcc.EventPlayerQuit)
+	registrar.Subscribe(mcc.PriorityMonitor, func(e *mcc.EventPlayerQuit) {
 		player := plugin.findPlayer(e.Player.Name())
 		plugin.savePlayer(player)
 		plugin.removePlayer(e.Player)
 	})
 
-	server.AddHandler(mcc.EventTypeLevelLoad, func(eventType int, event interface{}) {
-		e := event.(*mcc.EventLevelLoad)
+	registrar.Subscribe(mcc.PriorityLowest, func(e *mcc.EventLevelLoad) {
 		plugin.addLevel(e.Level)
 	})
 
-	server.AddHandler(mcc.EventTypeLevelUnload, func(eventType int, event interface{}) {
-		e := event.(*mcc.EventLevelUnload)
+	registrar.Subscribe(mcc.PriorityMonitor, func(e *mcc.EventLevelUnload) {
 		level := plugin.findLevel(e.Level.Name)
 		plugin.saveLevel(level)
 		plugin.removeLevel(e.Level)
 	})
 
+	for _, ip := range plugin.db.queryBlockedIPs() {
+		server.BlockAddr(ip.IP, ip.Until)
+	}
+
 	server.ForEachPlayer(func(player *mcc.Player) {
 		plugin.addPlayer(player)
 	})
@@ -394,9 +424,32 @@ func (plugin *plugin) Enable(server *mcc.Server) {
 	server.ForEachLevel(func(level *mcc.Level) {
 		plugin.addLevel(level)
 	})
+
+	return nil
 }
 
+// addCommand registers command. Its handler is not called after the
+// database is closed.
+func (plugin *plugin) addCommand(registrar *mcc.Registrar, command *mcc.Command) {
+	handler := command.Handler
+	command.Handler = func(sender mcc.CommandSender, command *mcc.Command, message string) {
+		plugin.commandsLock.RLock()
+		defer plugin.commandsLock.RUnlock()
+		if plugin.db != nil {
+			handler(sender, command, message)
+		}
+	}
+
+	registrar.AddCommand(command)
+}
+
+// Disable saves the players and levels and closes the database. The commands
+// of the plugin have been removed by the server, but the running ones are
+// waited for.
 func (plugin *plugin) Disable(server *mcc.Server) {
+	plugin.commandsLock.Lock()
+	defer plugin.commandsLock.Unlock()
+
 	plugin.playersLock.Lock()
 	for _, player := range plugin.players {
 		plugin.savePlayer(player)
@@ -412,6 +465,7 @@ func (plugin *plugin) Disable(server *mcc.Server) {
 	plugin.levelsLock.Unlock()
 
 	plugin.db.Close()
+	plugin.db = nil
 }
 
 func (plugin *plugin) loadRanks() {
@@ -560,15 +614,15 @@ func (plugin *plugin) saveLevel(level *level) {
 	})
 }
 
-func (plugin *plugin) handlePlayerLogin(eventType int, event interface{}) {
-	e := event.(*mcc.EventPlayerLogin)
+func (plugin *plugin) handlePlayerLogin(e *mcc.EventPlayerLogin) {
 	addr := e.Player.RemoteAddr()
 	name := e.Player.Name()
-	e.Cancel, e.CancelReason = plugin.db.checkBan(addr, name)
+	if banned, reason := plugin.db.checkBan(addr, name); banned {
+		e.Cancel, e.CancelReason = true, reason
+	}
 }
 
-func (plugin *plugin) handlePlayerChat(eventType int, event interface{}) {
-	e := event.(*mcc.EventPlayerChat)
+func (plugin *plugin) handlePlayerChat(e *mcc.EventPlayerChat) {
 	name := e.Player.Name()
 	player := plugin.findPlayer(name)
 	if player.mute {
@@ -33,6 +33,10 @@ func fmtDuration(t time.Duration) string {
 	return fmt.Sprintf("%dd %dh %dm", d, h, m)
 }
 
+func fmtTick(t time.Duration) string {
+	return fmt.Sprintf("%.2fms", t.Seconds()*1000)
+}
+
 func parseCoord(arg string, curr float64) (float64, error) {
 	if strings.HasPrefix(arg, "~") {
 		value, err := strconv.Atoi(arg[1:])
@@ -66,13 +70,13 @@ func envOption(option string, arg string, config *mcc.EnvConfig) int32 {
 
 	case "sideblock":
 		if v, err := strconv.ParseUint(arg, 10, 64); err == nil && v <= mcc.BlockMax {
-			config.SideBlock = byte(v)
+			config.SideBlock = mcc.BlockID(v)
 			return mcc.EnvPropSideBlock
 		}
 
 	case "edgeblock":
 		if v, err := strconv.ParseUint(arg, 10, 64); err == nil && v <= mcc.BlockMax {
-			config.EdgeBlock = byte(v)
+			config.EdgeBlock = mcc.BlockID(v)
 			return mcc.EnvPropEdgeBlock
 		}
 
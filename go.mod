@@ -5,4 +5,5 @@ go 1.12
 require (
 	github.com/jmoiron/sqlx v1.3.1
 	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/yuin/gopher-lua v1.1.1
 )
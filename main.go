@@ -2,15 +2,23 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"plugin"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/andreasgoulas/go-mcc/mcc"
+	"github.com/andreasgoulas/go-mcc/mcc/rpcplugin"
+	"github.com/andreasgoulas/go-mcc/mcc/script"
 )
 
 var defaultConfig = &mcc.Config{
@@ -22,16 +30,28 @@ var defaultConfig = &mcc.Config{
 	MaxPlayers: 32,
 	Heartbeat:  "http://www.classicube.net/heartbeat.jsp",
 	MainLevel:  "main",
+
+	MaxConnectionsPerIP:     5,
+	MaxConnectionsPerMinute: 30,
+	ThrottleBlockTime:       300,
+	LoginTimeout:            30,
+	ReadTimeout:             60,
 }
 
 const (
 	PermOperator = 1 << 0
 )
 
+const (
+	configPath      = "server.json"
+	shutdownTimeout = 30 * time.Second
+)
+
 type console struct {
 	server    *mcc.Server
 	waitGroup *sync.WaitGroup
 	signal    chan os.Signal
+	reload    chan os.Signal
 }
 
 func newConsole(server *mcc.Server, waitGroup *sync.WaitGroup) *console {
@@ -39,6 +59,7 @@ func newConsole(server *mcc.Server, waitGroup *sync.WaitGroup) *console {
 		server,
 		waitGroup,
 		make(chan os.Signal),
+		make(chan os.Signal, 1),
 	}
 
 	server.AddCommand(&mcc.Command{
@@ -49,11 +70,44 @@ func newConsole(server *mcc.Server, waitGroup *sync.WaitGroup) *console {
 		Handler:     console.handleStop,
 	})
 
+	server.AddCommand(&mcc.Command{
+		Name:        "reload",
+		Description: "Reload the server configuration.",
+		Usage:       "/reload",
+		Permissions: PermOperator,
+		Handler:     console.handleReload,
+	})
+
+	server.AddCommand(&mcc.Command{
+		Name:        "plugins",
+		Description: "List all plugins.",
+		Usage:       "/plugins",
+		Permissions: PermOperator,
+		Handler:     console.handlePlugins,
+	})
+
+	server.AddCommand(&mcc.Command{
+		Name:        "plugin",
+		Description: "Enable or disable a plugin.",
+		Usage:       "/plugin enable <name>\n/plugin disable <name>",
+		Permissions: PermOperator,
+		Handler:     console.handlePlugin,
+	})
+
 	signal.Notify(console.signal, os.Interrupt)
 	go func() {
-		signal := <-console.signal
-		if signal == os.Interrupt {
-			console.stop()
+		<-console.signal
+		go console.stop()
+
+		<-console.signal
+		log.Println("Forcing shutdown.")
+		os.Exit(1)
+	}()
+
+	signal.Notify(console.reload, syscall.SIGHUP)
+	go func() {
+		for range console.reload {
+			console.reloadConfig(console)
 		}
 	}()
 
@@ -68,8 +122,20 @@ func (console *console) run() {
 }
 
 func (console *console) stop() {
-	console.server.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := console.server.Shutdown(ctx)
+	if err == mcc.ErrServerStopped {
+		return
+	}
+
 	console.waitGroup.Wait()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	os.Exit(0)
 }
 
@@ -93,34 +159,132 @@ func (console *console) CanExecute(command *mcc.Command) bool {
 	return true
 }
 
+// reloadConfig reads the configuration file and applies it to the server.
+func (console *console) reloadConfig(sender mcc.CommandSender) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		sender.SendMessage("Could not reload the configuration: " + err.Error())
+		return
+	}
+
+	restart, err := console.server.ReloadConfig(config)
+	if err != nil {
+		sender.SendMessage("Could not reload the configuration: " + err.Error())
+		return
+	}
+
+	sender.SendMessage("Configuration reloaded.")
+	if len(restart) > 0 {
+		sender.SendMessage("Restart the server to change " + strings.Join(restart, ", ") + ".")
+	}
+}
+
 func (console *console) handleStop(sender mcc.CommandSender, command *mcc.Command, message string) {
-	console.stop()
+	go console.stop()
 }
 
-func readConfig(path string) *mcc.Config {
-	file, err := ioutil.ReadFile(path)
-	if err != nil {
-		data, err := json.MarshalIndent(defaultConfig, "", "\t")
-		if err != nil {
-			log.Printf("readConfig: %s\n", err)
-			return defaultConfig
-		}
+func (console *console) handleReload(sender mcc.CommandSender, command *mcc.Command, message string) {
+	console.reloadConfig(sender)
+}
 
-		if err := ioutil.WriteFile(path, data, 0644); err != nil {
-			log.Printf("readConfig: %s\n", err)
+func (console *console) handlePlugins(sender mcc.CommandSender, command *mcc.Command, message string) {
+	if len(message) != 0 {
+		command.PrintUsage(sender)
+		return
+	}
+
+	plugins := console.server.Plugins()
+	if len(plugins) == 0 {
+		sender.SendMessage("No plugins loaded.")
+		return
+	}
+
+	for _, status := range plugins {
+		name := status.Info.Name
+		if len(status.Info.Version) > 0 {
+			name += " " + status.Info.Version
 		}
 
-		return defaultConfig
-	} else {
-		config := &mcc.Config{}
-		err = json.Unmarshal(file, config)
-		if err != nil {
-			log.Printf("readConfig: %s\n", err)
-			config = defaultConfig
+		switch status.State {
+		case mcc.PluginEnabled:
+			sender.SendMessage(name + ": enabled")
+		case mcc.PluginDisabled:
+			sender.SendMessage(name + ": disabled")
+		case mcc.PluginFailed:
+			sender.SendMessage(name + ": failed (" + status.Err.Error() + ")")
 		}
+	}
+}
+
+func (console *console) handlePlugin(sender mcc.CommandSender, command *mcc.Command, message string) {
+	args := strings.Fields(message)
+	if len(args) != 2 {
+		command.PrintUsage(sender)
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "enable":
+		err = console.server.EnablePlugin(args[1])
+	case "disable":
+		err = console.server.DisablePlugin(args[1])
+	default:
+		command.PrintUsage(sender)
+		return
+	}
+
+	if err != nil {
+		sender.SendMessage("Could not " + args[0] + " the plugin: " + err.Error())
+		return
+	}
+
+	sender.SendMessage("Plugin " + args[1] + " " + args[0] + "d.")
+}
+
+// readConfig reads the configuration file at path. If the file does not
+// exist, it is created with the default configuration. A configuration that
+// cannot be parsed or is invalid is reported as an error, so that the server
+// never starts with settings other than the ones that were configured.
+func readConfig(path string) (*mcc.Config, error) {
+	config, err := loadConfig(path)
+	if !os.IsNotExist(err) {
+		return config, err
+	}
+
+	data, err := json.MarshalIndent(defaultConfig, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		log.Printf("readConfig: %s\n", err)
+	}
+
+	return defaultConfig, nil
+}
+
+// loadConfig reads and validates the configuration file at path.
+func loadConfig(path string) (*mcc.Config, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(file)
+}
+
+func parseConfig(data []byte) (*mcc.Config, error) {
+	config := &mcc.Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
 
-		return config
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
+
+	return config, nil
 }
 
 func loadPlugins(path string, server *mcc.Server) {
@@ -129,35 +293,76 @@ func loadPlugins(path string, server *mcc.Server) {
 		return
 	}
 
+	var plugins []mcc.Plugin
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
 
-		lib, err := plugin.Open(path + file.Name())
-		if err != nil {
-			log.Printf("loadPlugins: %s\n", err)
+		var plug mcc.Plugin
+		switch filepath.Ext(file.Name()) {
+		case ".so":
+			plug, err = openPlugin(path + file.Name())
+		case ".plugin":
+			plug, err = openRPCPlugin(path + file.Name())
+		default:
 			continue
 		}
 
-		sym, err := lib.Lookup("Initialize")
 		if err != nil {
 			log.Printf("loadPlugins: %s\n", err)
 			continue
 		}
 
-		initFn, ok := sym.(func() mcc.Plugin)
-		if !ok {
-			continue
-		}
+		plugins = append(plugins, plug)
+	}
 
-		plug := initFn()
-		server.AddPlugin(plug)
+	for _, err := range server.LoadPlugins(plugins) {
+		log.Printf("loadPlugins: %s\n", err)
 	}
 }
 
+// openPlugin opens the Go plugin at path and initializes it.
+func openPlugin(path string) (mcc.Plugin, error) {
+	lib, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := lib.Lookup("Initialize")
+	if err != nil {
+		return nil, err
+	}
+
+	initFn, ok := sym.(func() mcc.Plugin)
+	if !ok {
+		return nil, errors.New(path + ": invalid Initialize function")
+	}
+
+	plug := initFn()
+	if plug == nil {
+		return nil, errors.New(path + ": initialization failed")
+	}
+
+	return plug, nil
+}
+
+// openRPCPlugin starts the plugin executable at path.
+func openRPCPlugin(path string) (mcc.Plugin, error) {
+	host, err := rpcplugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return host, nil
+}
+
 func main() {
-	config := readConfig("server.json")
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Fatalf("Could not read %s: %s\n", configPath, err)
+	}
+
 	cwstorage := mcc.NewCwStorage("levels/")
 	server := mcc.NewServer(config, cwstorage)
 	if server == nil {
@@ -165,6 +370,7 @@ func main() {
 	}
 
 	loadPlugins("plugins/", server)
+	script.NewEngine(server, "scripts/").Start()
 
 	var wg sync.WaitGroup
 	if err := server.Start(&wg); err != nil {
@@ -1,5 +1,8 @@
 package mcc
 
+// BlockID identifies a block type.
+type BlockID uint16
+
 const (
 	BlockAir         = 0
 	BlockStone       = 1
@@ -52,6 +55,9 @@ const (
 	BlockMoss        = 48
 	BlockObsidian    = 49
 
+	BlockMaxLegacy   = BlockGold
+	BlockCountLegacy = BlockMaxLegacy + 1
+
 	BlockMaxClassic   = BlockObsidian
 	BlockCountClassic = BlockMaxClassic + 1
 
@@ -75,7 +81,7 @@ const (
 	BlockMaxCPE   = BlockStoneBrick
 	BlockCountCPE = BlockMaxCPE + 1
 
-	BlockMax   = 255
+	BlockMax   = 767
 	BlockCount = BlockMax + 1
 )
 
@@ -93,14 +99,14 @@ var BlockName = [BlockCountCPE]string{
 }
 
 // FallbackBlock converts a CPE block to a similar vanilla-compatible one.
-func FallbackBlock(block byte) byte {
+func FallbackBlock(block BlockID) BlockID {
 	switch block {
 	case BlockCobblestoneSlab:
 		return BlockSlab
 	case BlockRope:
 		return BlockBrownShroom
 	case BlockSandstone:
-		return BlockSandstone
+		return BlockSand
 	case BlockSnow:
 		return BlockAir
 	case BlockFire:
@@ -132,6 +138,31 @@ func FallbackBlock(block byte) byte {
 	}
 }
 
+// FallbackBlockLegacy converts a vanilla block to a similar one that is
+// supported by clients older than Classic 0.28.
+func FallbackBlockLegacy(block BlockID) BlockID {
+	switch block {
+	case BlockIron:
+		return BlockGold
+	case BlockDoubleSlab:
+		return BlockStone
+	case BlockSlab:
+		return BlockStone
+	case BlockBrick:
+		return BlockRed
+	case BlockTNT:
+		return BlockRed
+	case BlockBookshelf:
+		return BlockWood
+	case BlockMoss:
+		return BlockCobblestone
+	case BlockObsidian:
+		return BlockBlack
+	default:
+		return block
+	}
+}
+
 const (
 	FacePosX = 0
 	FaceNegX = 1
@@ -142,9 +173,14 @@ const (
 )
 
 const (
-	CollideModeWalk  = 0
-	CollideModeSwim  = 1
-	CollideModeSolid = 2
+	CollideModeWalk        = 0
+	CollideModeSwim        = 1
+	CollideModeSolid       = 2
+	CollideModeIce         = 3
+	CollideModeSlipperyIce = 4
+	CollideModeWater       = 5
+	CollideModeLava        = 6
+	CollideModeClimb       = 7
 )
 
 const (
@@ -176,7 +212,7 @@ const (
 // BlockDefinition describes a custom block.
 type BlockDefinition struct {
 	Name     string
-	Fallback byte
+	Fallback BlockID
 
 	Speed       float64
 	CollideMode byte
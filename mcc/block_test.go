@@ -0,0 +1,25 @@
+package mcc
+
+import "testing"
+
+func TestFallbackBlockLegacy(t *testing.T) {
+	for block := BlockID(0); block < BlockCountLegacy; block++ {
+		if got := FallbackBlockLegacy(block); got != block {
+			t.Errorf("%s: got %d, want it unchanged", BlockName[block], got)
+		}
+	}
+
+	for block := BlockID(BlockCountLegacy); block < BlockCountClassic; block++ {
+		if got := FallbackBlockLegacy(block); got >= BlockCountLegacy {
+			t.Errorf("%s: got %d, which legacy clients do not support", BlockName[block], got)
+		}
+	}
+}
+
+func TestFallbackBlock(t *testing.T) {
+	for block := BlockID(0); block < BlockCountCPE; block++ {
+		if got := FallbackBlock(block); got >= BlockCountClassic {
+			t.Errorf("%s: got %d, which vanilla clients do not support", BlockName[block], got)
+		}
+	}
+}
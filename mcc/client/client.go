@@ -0,0 +1,696 @@
+// Package client implements a headless Minecraft Classic client that can be
+// used to write bots and integration tests.
+package client
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// ErrClosed is returned when an operation is performed on a closed client.
+var ErrClosed = errors.New("client: connection closed")
+
+// ErrTimeout is returned when the server does not respond in time.
+var ErrTimeout = errors.New("client: timed out")
+
+// KickError is returned when the client is kicked by the server.
+type KickError struct {
+	Reason string
+}
+
+func (err *KickError) Error() string {
+	return "client: kicked: " + err.Reason
+}
+
+// Extensions is the list of CPE extensions that the client supports by
+// default.
+var Extensions = []proto.ExtEntry{
+	{ExtName: "ClickDistance", Version: 1},
+	{ExtName: "CustomBlocks", Version: 1},
+	{ExtName: "HeldBlock", Version: 1},
+	{ExtName: "TextHotKey", Version: 1},
+	{ExtName: "ExtPlayerList", Version: 2},
+	{ExtName: "EnvColors", Version: 1},
+	{ExtName: "SelectionCuboid", Version: 1},
+	{ExtName: "BlockPermissions", Version: 1},
+	{ExtName: "ChangeModel", Version: 1},
+	{ExtName: "EnvWeatherType", Version: 1},
+	{ExtName: "HackControl", Version: 1},
+	{ExtName: "MessageTypes", Version: 1},
+	{ExtName: "PlayerClick", Version: 1},
+	{ExtName: "LongerMessages", Version: 1},
+	{ExtName: "BlockDefinitions", Version: 1},
+	{ExtName: "BlockDefinitionsExt", Version: 2},
+	{ExtName: "BulkBlockUpdate", Version: 1},
+	{ExtName: "TextColors", Version: 1},
+	{ExtName: "EnvMapAspect", Version: 1},
+	{ExtName: "EntityProperty", Version: 1},
+	{ExtName: "ExtEntityPositions", Version: 1},
+	{ExtName: "TwoWayPing", Version: 1},
+	{ExtName: "InventoryOrder", Version: 1},
+	{ExtName: "InstantMOTD", Version: 1},
+	{ExtName: "FastMap", Version: 1},
+	{ExtName: "ExtendedTextures", Version: 1},
+	{ExtName: "ExtendedBlocks", Version: 1},
+}
+
+// Config describes how a Client connects to a server.
+// The handlers are called from the goroutine that reads packets, and must not
+// block.
+type Config struct {
+	Name            string
+	VerificationKey string
+
+	// AppName is the client name reported to CPE servers.
+	AppName string
+
+	// Version is the protocol version. It defaults to proto.Version7.
+	Version byte
+
+	// Extensions are the supported CPE extensions. If nil, Extensions is used.
+	Extensions []proto.ExtEntry
+	DisableCPE bool
+
+	// Timeout limits the duration of the handshake. It defaults to 30
+	// seconds.
+	Timeout time.Duration
+
+	HandlePacket     func(client *Client, packet proto.Packet)
+	HandleMessage    func(client *Client, msgType byte, message string)
+	HandleLevel      func(client *Client, level *Level)
+	HandleDisconnect func(client *Client, err error)
+}
+
+// Entity represents an entity spawned by the server.
+type Entity struct {
+	ID       byte
+	Name     string
+	SkinName string
+	Model    string
+	Location proto.Location
+}
+
+// Client is a connection to a Minecraft Classic server.
+type Client struct {
+	config Config
+	conn   net.Conn
+	codec  proto.Codec
+	reader *proto.Reader
+
+	writeLock  sync.Mutex
+	extensions map[string]bool
+
+	lock       sync.RWMutex
+	software   string
+	serverName string
+	motd       string
+	userType   byte
+	location   proto.Location
+	heldBlock  uint16
+	entities   map[byte]*Entity
+	level      *Level
+	levelData  bytes.Buffer
+
+	pingLock sync.Mutex
+	pingData int16
+	pings    map[int16]chan struct{}
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// Dial connects to the server at address and logs in.
+func Dial(address string, config *Config) (*Client, error) {
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(conn, config)
+}
+
+// NewClient logs in over an existing connection. On success, the client
+// starts processing packets in a new goroutine.
+func NewClient(conn net.Conn, config *Config) (*Client, error) {
+	client := &Client{
+		config:     *config,
+		conn:       conn,
+		extensions: make(map[string]bool),
+		entities:   make(map[byte]*Entity),
+		pings:      make(map[int16]chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	if client.config.Version == 0 {
+		client.config.Version = proto.Version7
+	}
+	if client.config.Extensions == nil {
+		client.config.Extensions = Extensions
+	}
+	if client.config.Timeout == 0 {
+		client.config.Timeout = defaultTimeout
+	}
+
+	client.codec.Version = client.config.Version
+	client.reader = proto.NewReader(conn, &client.codec, proto.ClientBound)
+	if err := client.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go client.run()
+	return client, nil
+}
+
+func (client *Client) handshake() error {
+	client.conn.SetDeadline(time.Now().Add(client.config.Timeout))
+	defer client.conn.SetDeadline(time.Time{})
+
+	cpe := !client.config.DisableCPE && client.config.Version == proto.Version7
+	identification := &proto.IdentificationClient{
+		Version:         client.config.Version,
+		Name:            client.config.Name,
+		VerificationKey: client.config.VerificationKey,
+	}
+
+	if cpe {
+		identification.Type = 0x42
+	}
+
+	if err := client.SendPacket(identification); err != nil {
+		return err
+	}
+
+	remaining := 0
+	offered := make(map[string]int32)
+	for {
+		packet, err := client.reader.ReadPacket()
+		if err != nil {
+			return err
+		}
+
+		switch packet := packet.(type) {
+		case *proto.ExtInfo:
+			client.software = packet.AppName
+			remaining = int(packet.ExtensionCount)
+			if remaining == 0 {
+				if err := client.sendExtensions(offered); err != nil {
+					return err
+				}
+			}
+
+		case *proto.ExtEntry:
+			offered[packet.ExtName] = packet.Version
+			remaining--
+			if remaining == 0 {
+				if err := client.sendExtensions(offered); err != nil {
+					return err
+				}
+			}
+
+		case *proto.CustomBlockSupportLevel:
+			err := client.SendPacket(&proto.CustomBlockSupportLevel{SupportLevel: 1})
+			if err != nil {
+				return err
+			}
+
+		case *proto.Kick:
+			return &KickError{packet.Reason}
+
+		default:
+			client.handlePacket(packet)
+			return nil
+		}
+	}
+}
+
+// sendExtensions completes the CPE negotiation.
+func (client *Client) sendExtensions(offered map[string]int32) error {
+	appName := client.config.AppName
+	if len(appName) == 0 {
+		appName = "go-mcc client"
+	}
+
+	packets := []proto.Packet{&proto.ExtInfo{
+		AppName:        appName,
+		ExtensionCount: int16(len(client.config.Extensions)),
+	}}
+
+	for _, entry := range client.config.Extensions {
+		entry := entry
+		packets = append(packets, &entry)
+		if version, ok := offered[entry.ExtName]; ok && version == entry.Version {
+			client.extensions[entry.ExtName] = true
+		}
+	}
+
+	client.codec.ExtPositions = client.extensions["ExtEntityPositions"]
+	client.codec.ExtBlocks = client.extensions["ExtendedBlocks"]
+	client.codec.ExtTextures = client.extensions["ExtendedTextures"]
+	client.codec.FastMap = client.extensions["FastMap"]
+	return client.SendPacket(packets...)
+}
+
+func (client *Client) run() {
+	for {
+		packet, err := client.reader.ReadPacket()
+		if err != nil {
+			client.shutdown(err)
+			return
+		}
+
+		client.handlePacket(packet)
+	}
+}
+
+func (client *Client) shutdown(err error) {
+	client.closeOnce.Do(func() {
+		client.lock.Lock()
+		client.err = err
+		client.lock.Unlock()
+
+		client.conn.Close()
+		close(client.done)
+		if client.config.HandleDisconnect != nil {
+			client.config.HandleDisconnect(client, err)
+		}
+	})
+}
+
+// Close closes the connection.
+func (client *Client) Close() error {
+	client.shutdown(nil)
+	return nil
+}
+
+// Done returns a channel that is closed when the connection is closed.
+func (client *Client) Done() <-chan struct{} {
+	return client.done
+}
+
+// Err returns the error that caused the connection to be closed. It returns
+// nil if the connection is open or was closed by Close.
+func (client *Client) Err() error {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.err
+}
+
+// SendPacket sends packets to the server.
+func (client *Client) SendPacket(packets ...proto.Packet) error {
+	var buf []byte
+	for _, packet := range packets {
+		buf = client.codec.Encode(buf, packet)
+	}
+
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+
+	select {
+	case <-client.done:
+		return ErrClosed
+	default:
+	}
+
+	_, err := client.conn.Write(buf)
+	return err
+}
+
+// Name returns the name of the player.
+func (client *Client) Name() string {
+	return client.config.Name
+}
+
+// HasExtension reports whether the specified CPE extension was negotiated.
+func (client *Client) HasExtension(name string) bool {
+	return client.extensions[name]
+}
+
+// ServerSoftware returns the software name reported by a CPE server.
+func (client *Client) ServerSoftware() string {
+	return client.software
+}
+
+// ServerName returns the name of the server.
+func (client *Client) ServerName() string {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.serverName
+}
+
+// MOTD returns the message of the day of the server.
+func (client *Client) MOTD() string {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.motd
+}
+
+// Operator reports whether the player is an operator.
+func (client *Client) Operator() bool {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.userType == 0x64
+}
+
+// Location returns the location of the player.
+func (client *Client) Location() proto.Location {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.location
+}
+
+// Level returns a copy of the current level, or nil if no level has been
+// received yet.
+func (client *Client) Level() *Level {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	if client.level == nil {
+		return nil
+	}
+
+	return client.level.clone()
+}
+
+// GetBlock returns the block at the specified coordinates.
+func (client *Client) GetBlock(x, y, z int) uint16 {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	if client.level == nil {
+		return 0
+	}
+
+	return client.level.GetBlock(x, y, z)
+}
+
+// Entities returns the entities spawned by the server, except the player.
+func (client *Client) Entities() []Entity {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+
+	result := make([]Entity, 0, len(client.entities))
+	for _, entity := range client.entities {
+		result = append(result, *entity)
+	}
+
+	return result
+}
+
+// Entity returns the entity with the specified ID.
+func (client *Client) Entity(id byte) (entity Entity, ok bool) {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	if e := client.entities[id]; e != nil {
+		return *e, true
+	}
+
+	return
+}
+
+// SendMessage sends a chat message. Long messages are split into multiple
+// packets.
+func (client *Client) SendMessage(message string) error {
+	var packets []proto.Packet
+	longer := client.HasExtension("LongerMessages")
+	for len(message) > 0 {
+		size := len(message)
+		if size > 64 {
+			size = 64
+		}
+
+		packet := &proto.Message{Type: 0x00, Message: message[:size]}
+		message = message[size:]
+		if longer && len(message) > 0 {
+			packet.Type = 0x01
+		}
+
+		packets = append(packets, packet)
+	}
+
+	return client.SendPacket(packets...)
+}
+
+// SetHeldBlock changes the block that the player reports holding.
+func (client *Client) SetHeldBlock(block uint16) {
+	client.lock.Lock()
+	client.heldBlock = block
+	client.lock.Unlock()
+}
+
+// Move moves the player to location.
+func (client *Client) Move(location proto.Location) error {
+	client.lock.Lock()
+	client.location = location
+	held := uint16(proto.SelfID)
+	if client.HasExtension("HeldBlock") {
+		held = client.heldBlock
+	}
+	client.lock.Unlock()
+
+	return client.SendPacket(&proto.PlayerTeleportClient{
+		Held:     held,
+		Location: location,
+	})
+}
+
+// PlaceBlock places block at the specified coordinates.
+func (client *Client) PlaceBlock(x, y, z int, block uint16) error {
+	return client.SendPacket(&proto.SetBlockClient{
+		X: int16(x), Y: int16(y), Z: int16(z),
+		Mode:  0x01,
+		Block: block,
+	})
+}
+
+// BreakBlock breaks the block at the specified coordinates.
+func (client *Client) BreakBlock(x, y, z int) error {
+	client.lock.RLock()
+	held := client.heldBlock
+	client.lock.RUnlock()
+
+	return client.SendPacket(&proto.SetBlockClient{
+		X: int16(x), Y: int16(y), Z: int16(z),
+		Mode:  0x00,
+		Block: held,
+	})
+}
+
+// Ping measures the round-trip time to the server. It requires the
+// TwoWayPing extension.
+func (client *Client) Ping(timeout time.Duration) (time.Duration, error) {
+	if !client.HasExtension("TwoWayPing") {
+		return 0, errors.New("client: TwoWayPing is not supported")
+	}
+
+	reply := make(chan struct{})
+	client.pingLock.Lock()
+	client.pingData++
+	data := client.pingData
+	client.pings[data] = reply
+	client.pingLock.Unlock()
+
+	defer func() {
+		client.pingLock.Lock()
+		delete(client.pings, data)
+		client.pingLock.Unlock()
+	}()
+
+	start := time.Now()
+	if err := client.SendPacket(&proto.TwoWayPing{Direction: 0, Data: data}); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-reply:
+		return time.Since(start), nil
+	case <-timer.C:
+		return 0, ErrTimeout
+	case <-client.done:
+		return 0, ErrClosed
+	}
+}
+
+func (client *Client) handlePacket(packet proto.Packet) {
+	switch packet := packet.(type) {
+	case *proto.Identification:
+		client.lock.Lock()
+		client.serverName = packet.Name
+		client.motd = packet.MOTD
+		client.userType = packet.UserType
+		client.lock.Unlock()
+
+	case *proto.UpdateUserType:
+		client.lock.Lock()
+		client.userType = packet.UserType
+		client.lock.Unlock()
+
+	case *proto.LevelInitialize:
+		client.lock.Lock()
+		client.levelData.Reset()
+		client.lock.Unlock()
+
+	case *proto.LevelDataChunk:
+		client.lock.Lock()
+		client.levelData.Write(packet.Data)
+		client.lock.Unlock()
+
+	case *proto.LevelFinalize:
+		client.lock.Lock()
+		data := client.levelData.Bytes()
+		level, err := decodeLevel(data, int(packet.X), int(packet.Y), int(packet.Z),
+			client.codec.FastMap, client.codec.ExtBlocks)
+		if err == nil {
+			client.level = level
+			client.levelData = bytes.Buffer{}
+		}
+		client.lock.Unlock()
+
+		if err != nil {
+			client.shutdown(err)
+			return
+		}
+
+		if client.config.HandleLevel != nil {
+			client.config.HandleLevel(client, level.clone())
+		}
+
+	case *proto.SetBlock:
+		client.lock.Lock()
+		if level := client.level; level != nil {
+			x, y, z := int(packet.X), int(packet.Y), int(packet.Z)
+			if level.InBounds(x, y, z) {
+				level.Blocks[level.Index(x, y, z)] = packet.Block
+			}
+		}
+		client.lock.Unlock()
+
+	case *proto.BulkBlockUpdate:
+		client.lock.Lock()
+		if level := client.level; level != nil {
+			for i, index := range packet.Indices {
+				if index >= 0 && int(index) < len(level.Blocks) {
+					level.Blocks[index] = packet.Blocks[i]
+				}
+			}
+		}
+		client.lock.Unlock()
+
+	case *proto.AddEntity:
+		client.addEntity(packet.EntityID, packet.Name, packet.Name, packet.Location)
+
+	case *proto.ExtAddEntity2:
+		client.addEntity(packet.EntityID, packet.DisplayName, packet.SkinName, packet.Location)
+
+	case *proto.PlayerTeleport:
+		client.updateEntity(packet.EntityID, func(location *proto.Location) {
+			*location = packet.Location
+		})
+
+	case *proto.PositionOrientationUpdate:
+		client.updateEntity(packet.EntityID, func(location *proto.Location) {
+			location.X += packet.DX
+			location.Y += packet.DY
+			location.Z += packet.DZ
+			location.Yaw = packet.Yaw
+			location.Pitch = packet.Pitch
+		})
+
+	case *proto.PositionUpdate:
+		client.updateEntity(packet.EntityID, func(location *proto.Location) {
+			location.X += packet.DX
+			location.Y += packet.DY
+			location.Z += packet.DZ
+		})
+
+	case *proto.OrientationUpdate:
+		client.updateEntity(packet.EntityID, func(location *proto.Location) {
+			location.Yaw = packet.Yaw
+			location.Pitch = packet.Pitch
+		})
+
+	case *proto.RemoveEntity:
+		client.lock.Lock()
+		delete(client.entities, packet.EntityID)
+		client.lock.Unlock()
+
+	case *proto.ChangeModel:
+		client.lock.Lock()
+		if entity := client.entities[packet.EntityID]; entity != nil {
+			entity.Model = packet.Model
+		}
+		client.lock.Unlock()
+
+	case *proto.HoldThis:
+		client.SetHeldBlock(packet.Block)
+
+	case *proto.Message:
+		if client.config.HandleMessage != nil {
+			client.config.HandleMessage(client, packet.Type, packet.Message)
+		}
+
+	case *proto.Kick:
+		client.shutdown(&KickError{packet.Reason})
+
+	case *proto.TwoWayPing:
+		switch packet.Direction {
+		case 0:
+			client.pingLock.Lock()
+			if reply := client.pings[packet.Data]; reply != nil {
+				close(reply)
+				delete(client.pings, packet.Data)
+			}
+			client.pingLock.Unlock()
+
+		case 1:
+			client.SendPacket(&proto.TwoWayPing{Direction: 1, Data: packet.Data})
+		}
+	}
+
+	if client.config.HandlePacket != nil {
+		client.config.HandlePacket(client, packet)
+	}
+}
+
+func (client *Client) addEntity(id byte, name, skin string, location proto.Location) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if id == proto.SelfID {
+		client.location = location
+		return
+	}
+
+	client.entities[id] = &Entity{
+		ID:       id,
+		Name:     name,
+		SkinName: skin,
+		Model:    "humanoid",
+		Location: location,
+	}
+}
+
+func (client *Client) updateEntity(id byte, fn func(location *proto.Location)) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if id == proto.SelfID {
+		fn(&client.location)
+	} else if entity := client.entities[id]; entity != nil {
+		fn(&entity.Location)
+	}
+}
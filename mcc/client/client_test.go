@@ -0,0 +1,228 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/internal/mcctest"
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+// fakeServer is the server side of a connection to a Client.
+type fakeServer struct {
+	t      *testing.T
+	conn   net.Conn
+	codec  proto.Codec
+	reader *proto.Reader
+}
+
+func newFakeServer(t *testing.T, conn net.Conn) *fakeServer {
+	server := &fakeServer{t: t, conn: conn, codec: proto.Codec{Version: proto.Version7}}
+	server.reader = proto.NewReader(conn, &server.codec, proto.ServerBound)
+	conn.SetDeadline(time.Now().Add(mcctest.Timeout))
+	return server
+}
+
+func (server *fakeServer) read() proto.Packet {
+	packet, err := server.reader.ReadPacket()
+	if err != nil {
+		server.t.Error(err)
+		return nil
+	}
+
+	return packet
+}
+
+func (server *fakeServer) send(packets ...proto.Packet) {
+	var buf []byte
+	for _, packet := range packets {
+		buf = server.codec.Encode(buf, packet)
+	}
+
+	if _, err := server.conn.Write(buf); err != nil {
+		server.t.Error(err)
+	}
+}
+
+// sendLevel sends blocks as a level of the specified dimensions.
+func (server *fakeServer) sendLevel(blocks []uint16, width, height, length int) {
+	var buf bytes.Buffer
+	if server.codec.FastMap {
+		writer, _ := flate.NewWriter(&buf, -1)
+		for _, block := range blocks {
+			writer.Write([]byte{byte(block)})
+		}
+		if server.codec.ExtBlocks {
+			for _, block := range blocks {
+				writer.Write([]byte{byte(block >> 8)})
+			}
+		}
+		writer.Close()
+	} else {
+		writer := gzip.NewWriter(&buf)
+		binary.Write(writer, binary.BigEndian, int32(len(blocks)))
+		for _, block := range blocks {
+			writer.Write([]byte{byte(block)})
+		}
+		writer.Close()
+	}
+
+	server.send(&proto.LevelInitialize{Size: int32(len(blocks))})
+	data := buf.Bytes()
+	for offset := 0; offset < len(data); offset += proto.LevelChunkSize {
+		end := offset + proto.LevelChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		server.send(&proto.LevelDataChunk{Data: data[offset:end]})
+	}
+
+	server.send(&proto.LevelFinalize{X: int16(width), Y: int16(height), Z: int16(length)})
+}
+
+func TestHandshakeCPE(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server := newFakeServer(t, serverConn)
+		ident, ok := server.read().(*proto.IdentificationClient)
+		if !ok || ident.Name != "Bot" || ident.Type != 0x42 {
+			t.Errorf("got identification %+v", ident)
+			return
+		}
+
+		server.send(
+			&proto.ExtInfo{AppName: "Fake", ExtensionCount: 3},
+			&proto.ExtEntry{ExtName: "FastMap", Version: 1},
+			&proto.ExtEntry{ExtName: "ExtendedBlocks", Version: 1},
+			&proto.ExtEntry{ExtName: "EnvColors", Version: 2},
+		)
+
+		info, ok := server.read().(*proto.ExtInfo)
+		if !ok {
+			t.Errorf("got %+v, want ExtInfo", info)
+			return
+		}
+
+		for i := 0; i < int(info.ExtensionCount); i++ {
+			server.read()
+		}
+
+		server.codec.FastMap = true
+		server.codec.ExtBlocks = true
+		server.send(&proto.Identification{Version: proto.Version7, Name: "Fake Server", MOTD: "Fake MOTD"})
+
+		blocks := make([]uint16, 4*4*4)
+		blocks[1] = 0x12a
+		server.sendLevel(blocks, 4, 4, 4)
+		server.send(&proto.SetBlock{X: 2, Y: 0, Z: 0, Block: 0x101})
+	}()
+
+	config := &Config{Name: "Bot", Timeout: mcctest.Timeout}
+	c, err := NewClient(clientConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if c.ServerSoftware() != "Fake" || c.ServerName() != "Fake Server" || c.MOTD() != "Fake MOTD" {
+		t.Errorf("got software %q, server %q, MOTD %q", c.ServerSoftware(), c.ServerName(), c.MOTD())
+	}
+
+	if !c.HasExtension("FastMap") || !c.HasExtension("ExtendedBlocks") {
+		t.Error("extensions were not negotiated")
+	}
+
+	if c.HasExtension("EnvColors") {
+		t.Error("extension with a different version was negotiated")
+	}
+
+	mcctest.WaitFor(t, "the block change", func() bool { return c.GetBlock(2, 0, 0) == 0x101 })
+	if block := c.GetBlock(1, 0, 0); block != 0x12a {
+		t.Errorf("got block %#x, want %#x", block, 0x12a)
+	}
+
+	<-done
+}
+
+func TestHandshakeVanilla(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		server := newFakeServer(t, serverConn)
+		server.codec.Version = proto.Version6
+		ident, ok := server.read().(*proto.IdentificationClient)
+		if !ok || ident.Version != proto.Version6 || ident.Type != 0 {
+			t.Errorf("got identification %+v", ident)
+			return
+		}
+
+		server.send(&proto.Identification{Version: proto.Version6, Name: "Fake Server"})
+		blocks := make([]uint16, 2*2*2)
+		blocks[7] = 41
+		server.sendLevel(blocks, 2, 2, 2)
+	}()
+
+	config := &Config{Name: "Bot", Version: proto.Version6, Timeout: mcctest.Timeout}
+	c, err := NewClient(clientConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	mcctest.WaitFor(t, "the level", func() bool { return c.Level() != nil })
+	if block := c.GetBlock(1, 1, 1); block != 41 {
+		t.Errorf("got block %d, want 41", block)
+	}
+}
+
+func TestHandshakeKick(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		server := newFakeServer(t, serverConn)
+		server.read()
+		server.send(&proto.Kick{Reason: "Server full"})
+	}()
+
+	_, err := NewClient(clientConn, &Config{Name: "Bot", Timeout: mcctest.Timeout})
+	if kick, ok := err.(*KickError); !ok || kick.Reason != "Server full" {
+		t.Fatalf("got error %v, want a KickError", err)
+	}
+}
+
+func TestDecodeLevel(t *testing.T) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	binary.Write(writer, binary.BigEndian, int32(8))
+	writer.Write([]byte{0, 1, 2, 3, 4, 5, 6, 7})
+	writer.Close()
+
+	level, err := decodeLevel(buf.Bytes(), 2, 2, 2, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block := level.GetBlock(1, 1, 1); block != 7 {
+		t.Errorf("got block %d, want 7", block)
+	}
+
+	if _, err := decodeLevel(buf.Bytes(), 4, 4, 4, false, false); err == nil {
+		t.Error("level with a wrong size prefix accepted")
+	}
+
+	if _, err := decodeLevel(buf.Bytes(), 2, 2, 2, false, true); err == nil {
+		t.Error("truncated extended blocks accepted")
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Level is a level received from the server.
+type Level struct {
+	Width, Height, Length int
+	Blocks                []uint16
+}
+
+// Size returns the number of blocks in the level.
+func (level *Level) Size() int {
+	return level.Width * level.Height * level.Length
+}
+
+// Index converts the specified coordinates to an array index.
+func (level *Level) Index(x, y, z int) int {
+	return x + level.Width*(z+level.Length*y)
+}
+
+// Position converts the specified array index to block coordinates.
+func (level *Level) Position(index int) (x, y, z int) {
+	x = index % level.Width
+	y = (index / level.Width) / level.Length
+	z = (index / level.Width) % level.Length
+	return
+}
+
+// InBounds reports whether the specified coordinates are within the bounds of
+// the level.
+func (level *Level) InBounds(x, y, z int) bool {
+	return x >= 0 && y >= 0 && z >= 0 &&
+		x < level.Width && y < level.Height && z < level.Length
+}
+
+// GetBlock returns the block at the specified coordinates.
+func (level *Level) GetBlock(x, y, z int) uint16 {
+	if !level.InBounds(x, y, z) {
+		return 0
+	}
+
+	return level.Blocks[level.Index(x, y, z)]
+}
+
+func (level *Level) clone() *Level {
+	result := *level
+	result.Blocks = make([]uint16, len(level.Blocks))
+	copy(result.Blocks, level.Blocks)
+	return &result
+}
+
+// decodeLevel decompresses the level data sent by the server. If fastMap is
+// set, the data is a raw DEFLATE stream without a size prefix. If extBlocks
+// is set, the upper bytes of the blocks follow the lower bytes.
+func decodeLevel(data []byte, width, height, length int, fastMap, extBlocks bool) (*Level, error) {
+	var reader io.Reader
+	if fastMap {
+		reader = flate.NewReader(bytes.NewReader(data))
+	} else {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		reader = gzipReader
+	}
+
+	level := &Level{Width: width, Height: height, Length: length}
+	size := level.Size()
+	if !fastMap {
+		var prefix int32
+		if err := binary.Read(reader, binary.BigEndian, &prefix); err != nil {
+			return nil, err
+		}
+
+		if int(prefix) != size {
+			return nil, errors.New("client: invalid level size")
+		}
+	}
+
+	blocks, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count := size
+	if extBlocks {
+		count *= 2
+	}
+
+	if len(blocks) < count {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	level.Blocks = make([]uint16, size)
+	for i := range level.Blocks {
+		level.Blocks[i] = uint16(blocks[i])
+		if extBlocks {
+			level.Blocks[i] |= uint16(blocks[size+i]) << 8
+		}
+	}
+
+	return level, nil
+}
@@ -73,6 +73,10 @@ type Command struct {
 	Usage       string
 	Permissions uint32
 	Handler     CommandHandler
+
+	// Owner is the plugin that registered the command. The commands of a
+	// plugin are removed when it is disabled.
+	Owner Plugin
 }
 
 // PrintUsage sends the command usage message to sender.
@@ -107,7 +111,7 @@ var DefaultRank = func() (rank Rank) {
 		rank.CanBreak[i] = true
 	}
 
-	banned := []byte{BlockBedrock, BlockActiveWater, BlockWater, BlockActiveLava, BlockLava}
+	banned := []BlockID{BlockBedrock, BlockActiveWater, BlockWater, BlockActiveLava, BlockLava}
 	for _, block := range banned {
 		rank.CanPlace[block] = false
 	}
@@ -0,0 +1,185 @@
+package mcc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// Validate reports an error if config contains invalid values. It also
+// parses the trusted proxy networks, so that they are not parsed again for
+// every connection.
+func (config *Config) Validate() error {
+	if len(config.Listeners) == 0 && (config.Port < 1 || config.Port > 65535) {
+		return fmt.Errorf("config: invalid server-port %d", config.Port)
+	}
+
+	if len(config.Name) == 0 {
+		return errors.New("config: server-name is empty")
+	}
+
+	if len(config.MainLevel) == 0 {
+		return errors.New("config: main-level is empty")
+	}
+
+	if config.MaxPlayers < 1 {
+		return fmt.Errorf("config: invalid max-players %d", config.MaxPlayers)
+	}
+
+	for _, heartbeat := range config.heartbeatURLs() {
+		if _, err := url.ParseRequestURI(heartbeat); err != nil {
+			return fmt.Errorf("config: invalid heartbeat: %s", err)
+		}
+	}
+
+	networks, err := parseNetworks(config.ProxyTrusted)
+	if err != nil {
+		return fmt.Errorf("config: invalid proxy-trusted: %s", err)
+	}
+
+	config.trustedProxies = networks
+
+	if config.ViewDistance < 0 {
+		return fmt.Errorf("config: invalid view-distance %g", config.ViewDistance)
+	}
+
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"max-connections-per-ip", config.MaxConnectionsPerIP},
+		{"max-connections-per-minute", config.MaxConnectionsPerMinute},
+		{"throttle-block-time", config.ThrottleBlockTime},
+		{"login-timeout", config.LoginTimeout},
+		{"read-timeout", config.ReadTimeout},
+	}
+
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return fmt.Errorf("config: invalid %s %d", limit.name, limit.value)
+		}
+	}
+
+	if len(config.MetricsAddr) > 0 {
+		if _, _, err := net.SplitHostPort(config.MetricsAddr); err != nil {
+			return fmt.Errorf("config: invalid metrics-addr: %s", err)
+		}
+	}
+
+	for _, listener := range config.Listeners {
+		_, port, err := net.SplitHostPort(listener.Addr)
+		if err != nil {
+			return fmt.Errorf("config: invalid listener address: %s", err)
+		}
+
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("config: invalid listener port %q", port)
+		}
+	}
+
+	return nil
+}
+
+// listenerConfigs returns the listeners of config. If none are configured,
+// the server listens on server-port on all interfaces.
+func (config *Config) listenerConfigs() []ListenerConfig {
+	if len(config.Listeners) == 0 {
+		return []ListenerConfig{{Addr: ":" + strconv.Itoa(config.Port)}}
+	}
+
+	configs := make([]ListenerConfig, len(config.Listeners))
+	copy(configs, config.Listeners)
+	return configs
+}
+
+// publicPort returns the port that is advertised to the server lists, which
+// is the port of the first listener.
+func (config *Config) publicPort() int {
+	_, port, err := net.SplitHostPort(config.listenerConfigs()[0].Addr)
+	if err != nil {
+		return config.Port
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return config.Port
+	}
+
+	return n
+}
+
+// verifyNames reports whether the names of players connecting through the
+// listener are verified.
+func (listener *ListenerConfig) verifyNames(config *Config) bool {
+	if listener != nil && listener.Verify != nil {
+		return *listener.Verify
+	}
+
+	return config.Verify
+}
+
+// proxyProtocol reports whether connections through the listener may start
+// with a PROXY protocol header.
+func (listener *ListenerConfig) proxyProtocol(config *Config) bool {
+	if listener != nil && listener.ProxyProtocol != nil {
+		return *listener.ProxyProtocol
+	}
+
+	return config.ProxyProtocol
+}
+
+// Config returns the current configuration of the server. The returned
+// Config must not be modified.
+func (server *Server) Config() *Config {
+	return server.config.Load().(*Config)
+}
+
+// ReloadConfig validates config and replaces the current configuration with
+// it. Fields that cannot be changed while the server is running keep their
+// current value, and their names are returned.
+func (server *Server) ReloadConfig(config *Config) ([]string, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	server.configLock.Lock()
+	defer server.configLock.Unlock()
+
+	oldConfig := server.Config()
+	newConfig := *config
+
+	var restart []string
+	if newConfig.Port != oldConfig.Port {
+		newConfig.Port = oldConfig.Port
+		restart = append(restart, "server-port")
+	}
+
+	if newConfig.MetricsAddr != oldConfig.MetricsAddr {
+		newConfig.MetricsAddr = oldConfig.MetricsAddr
+		restart = append(restart, "metrics-addr")
+	}
+
+	if newConfig.SaltFile != oldConfig.SaltFile {
+		newConfig.SaltFile = oldConfig.SaltFile
+		restart = append(restart, "salt-file")
+	}
+
+	if newConfig.MainLevel != oldConfig.MainLevel {
+		newConfig.MainLevel = oldConfig.MainLevel
+		restart = append(restart, "main-level")
+	}
+
+	if !reflect.DeepEqual(newConfig.Listeners, oldConfig.Listeners) {
+		newConfig.Listeners = oldConfig.Listeners
+		restart = append(restart, "listeners")
+	}
+
+	server.config.Store(&newConfig)
+
+	event := EventConfigReload{oldConfig, &newConfig}
+	server.FireEvent(EventTypeConfigReload, &event)
+	return restart, nil
+}
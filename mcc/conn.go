@@ -0,0 +1,33 @@
+package mcc
+
+import (
+	"bufio"
+	"net"
+)
+
+// bufferedConn is a net.Conn that reads through a bufio.Reader, so that data
+// which has been peeked at is not lost. If remoteAddr is set, it overrides the
+// address of the remote peer.
+type bufferedConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Read implements net.Conn.
+func (conn *bufferedConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+// RemoteAddr implements net.Conn.
+func (conn *bufferedConn) RemoteAddr() net.Addr {
+	if conn.remoteAddr != nil {
+		return conn.remoteAddr
+	}
+
+	return conn.Conn.RemoteAddr()
+}
@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"time"
 )
@@ -58,6 +59,7 @@ type cwEnvWeatherType struct {
 
 type cwBlockDefinition struct {
 	ID             byte
+	ID2            int16
 	Name           string
 	Speed          float32
 	CollideType    byte
@@ -77,6 +79,14 @@ var cwFaceIndices = []int{
 	FaceNegZ, FacePosZ,
 }
 
+func (def *cwBlockDefinition) blockID() BlockID {
+	if def.ID2 != 0 {
+		return BlockID(uint16(def.ID2))
+	}
+
+	return BlockID(def.ID)
+}
+
 type CwBlockDefinitionMap map[string]cwBlockDefinition
 
 type cwBlockDefinitions struct {
@@ -108,6 +118,7 @@ type cwLevel struct {
 	TimeCreated   int64
 	Spawn         cwSpawn
 	BlockArray    []byte
+	BlockArray2   []byte `nbt:",omitempty"`
 	Metadata      cwMetadata
 }
 
@@ -180,6 +191,14 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 
 	if len(cw.BlockArray) == level.Size() {
 		level.Blocks = cw.BlockArray
+		if len(cw.BlockArray2) == level.Size() {
+			level.Blocks2 = cw.BlockArray2
+			for i := range level.Blocks {
+				if level.block(i) > BlockMax {
+					level.setBlock(i, BlockAir)
+				}
+			}
+		}
 	}
 
 	if cw.TimeCreated > 0 {
@@ -203,8 +222,8 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 
 	if cpe.EnvMapAppearance.ExtensionVersion == 1 {
 		level.EnvConfig.TexturePack = cpe.EnvMapAppearance.TextureURL
-		level.EnvConfig.SideBlock = cpe.EnvMapAppearance.SideBlock
-		level.EnvConfig.EdgeBlock = cpe.EnvMapAppearance.EdgeBlock
+		level.EnvConfig.SideBlock = BlockID(cpe.EnvMapAppearance.SideBlock)
+		level.EnvConfig.EdgeBlock = BlockID(cpe.EnvMapAppearance.EdgeBlock)
 		level.EnvConfig.EdgeHeight = int(cpe.EnvMapAppearance.SideLevel)
 	}
 
@@ -215,8 +234,8 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 	if cpe.BlockDefinitions.ExtensionVersion == 1 {
 		count := 0
 		for _, v := range cpe.BlockDefinitions.CwBlockDefinitionMap {
-			if int(v.ID) >= count {
-				count = int(v.ID) + 1
+			if id := int(v.blockID()); id >= count && id <= BlockMax {
+				count = id + 1
 			}
 		}
 
@@ -225,6 +244,11 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 		}
 
 		for _, v := range cpe.BlockDefinitions.CwBlockDefinitionMap {
+			id := v.blockID()
+			if id > BlockMax {
+				continue
+			}
+
 			def := &BlockDefinition{
 				Name:        v.Name,
 				Speed:       float64(v.Speed),
@@ -258,7 +282,7 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 				}
 			}
 
-			level.BlockDefs[v.ID] = def
+			level.BlockDefs[id] = def
 		}
 	}
 
@@ -268,16 +292,13 @@ func (storage *CwStorage) Load(name string) (level *Level, err error) {
 }
 
 // Save implements LevelStorage.
-func (storage *CwStorage) Save(level *Level) (err error) {
-	file, err := os.Create(storage.getPath(level.Name))
-	if err != nil {
-		return
-	}
-
-	writer := gzip.NewWriter(file)
-	defer file.Close()
-	defer writer.Close()
+func (storage *CwStorage) Save(level *Level) error {
+	return writeFileGzip(storage.getPath(level.Name), func(writer io.Writer) error {
+		return storage.write(writer, level)
+	})
+}
 
+func (storage *CwStorage) write(writer io.Writer, level *Level) (err error) {
 	var defs CwBlockDefinitionMap
 	if level.BlockDefs != nil {
 		defs = make(CwBlockDefinitionMap)
@@ -287,6 +308,7 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 		if v != nil {
 			def := cwBlockDefinition{
 				ID:             byte(i),
+				ID2:            int16(i),
 				Name:           v.Name,
 				Speed:          float32(v.Speed),
 				CollideType:    v.CollideMode,
@@ -321,6 +343,9 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 		}
 	}
 
+	// EnvMapAppearance stores the side and edge blocks in a byte, so
+	// extended blocks are saved as their fallbacks.
+	envProfile := blockProfile{customBlocks: true, blockDefs: true}
 	cpe := cwCPE{
 		level.MetadataCPE,
 		cwClickDistance{1, int16(level.HackConfig.ReachDistance * 32)},
@@ -335,14 +360,15 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 		cwEnvMapAppearance{
 			1,
 			level.EnvConfig.TexturePack,
-			level.EnvConfig.SideBlock,
-			level.EnvConfig.EdgeBlock,
+			byte(level.convertBlock(level.EnvConfig.SideBlock, envProfile)),
+			byte(level.convertBlock(level.EnvConfig.EdgeBlock, envProfile)),
 			int16(level.EnvConfig.EdgeHeight),
 		},
 		cwEnvWeatherType{1, level.EnvConfig.Weather},
 		cwBlockDefinitions{1, defs},
 	}
 
+	blocks, blocks2 := level.copyBlocks()
 	return NbtMarshal(writer, "ClassicWorld", cwLevel{
 		1,
 		level.Name,
@@ -358,7 +384,8 @@ func (storage *CwStorage) Save(level *Level) (err error) {
 			byte(level.Spawn.Yaw * 256 / 360),
 			byte(level.Spawn.Pitch * 256 / 360),
 		},
-		level.Blocks,
+		blocks,
+		blocks2,
 		cwMetadata{
 			level.Metadata,
 			cpe,
@@ -2,6 +2,9 @@ package mcc
 
 import (
 	"math"
+	"sync"
+
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
 )
 
 const (
@@ -42,7 +45,7 @@ type Entity struct {
 	server *Server
 	player *Player
 
-	id   byte
+	id   int
 	name string
 
 	Model string
@@ -55,6 +58,7 @@ type Entity struct {
 	GroupName string
 	GroupRank byte
 
+	locationLock sync.RWMutex
 	level        *Level
 	location     Location
 	lastLocation Location
@@ -64,7 +68,6 @@ type Entity struct {
 func NewEntity(name string, server *Server) *Entity {
 	return &Entity{
 		server:      server,
-		id:          0xff,
 		name:        name,
 		Model:       ModelHumanoid,
 		Props:       EntityProps{ScaleX: 1.0, ScaleY: 1.0, ScaleZ: 1.0},
@@ -78,7 +81,9 @@ func (entity *Entity) Server() *Server {
 	return entity.server
 }
 
-func (entity *Entity) ID() byte {
+// ID returns the server-wide ID of the entity. Clients refer to the entity by
+// a separate ID that is assigned by each Player.
+func (entity *Entity) ID() int {
 	return entity.id
 }
 
@@ -88,8 +93,8 @@ func (entity *Entity) Name() string {
 
 // SendModel sends the model of the entity to all relevant players.
 func (entity *Entity) SendModel() {
-	if entity.level != nil {
-		entity.level.ForEachPlayer(func(player *Player) {
+	if level := entity.Level(); level != nil {
+		level.ForEachPlayer(func(player *Player) {
 			player.sendChangeModel(entity)
 		})
 	}
@@ -98,8 +103,8 @@ func (entity *Entity) SendModel() {
 // SendProps sends the EntityProps of the entity to all relevant players.
 // mask controls which properties are sent.
 func (entity *Entity) SendProps(mask uint32) {
-	if entity.level != nil {
-		entity.level.ForEachPlayer(func(player *Player) {
+	if level := entity.Level(); level != nil {
+		level.ForEachPlayer(func(player *Player) {
 			player.sendEntityProps(entity, mask)
 		})
 	}
@@ -113,40 +118,69 @@ func (entity *Entity) SendListName() {
 }
 
 func (entity *Entity) Location() Location {
+	entity.locationLock.RLock()
+	defer entity.locationLock.RUnlock()
 	return entity.location
 }
 
+// setLocation moves the entity to location. The other players are notified
+// by the next update.
+func (entity *Entity) setLocation(location Location) {
+	entity.locationLock.Lock()
+	entity.location = location
+	entity.locationLock.Unlock()
+}
+
+// resetLocation moves the entity to location without notifying the other
+// players.
+func (entity *Entity) resetLocation(location Location) {
+	entity.locationLock.Lock()
+	entity.location = location
+	entity.lastLocation = location
+	entity.locationLock.Unlock()
+}
+
 // Teleport teleports the entity to location.
 func (entity *Entity) Teleport(location Location) {
-	if location == entity.location {
+	from := entity.Location()
+	if location == from {
 		return
 	}
 
-	event := &EventEntityMove{entity, entity.location, location, false}
+	event := EventEntityMove{entity, from, location, false}
 	entity.server.FireEvent(EventTypeEntityMove, &event)
 	if event.Cancel {
 		return
 	}
 
-	entity.location = location
+	entity.setLocation(location)
 	if entity.player != nil {
+		entity.player.movement.reset(location)
 		entity.player.sendTeleport(entity)
 	}
 }
 
 func (entity *Entity) Level() *Level {
+	entity.locationLock.RLock()
+	defer entity.locationLock.RUnlock()
 	return entity.level
 }
 
+func (entity *Entity) setLevel(level *Level) {
+	entity.locationLock.Lock()
+	entity.level = level
+	entity.locationLock.Unlock()
+}
+
 // TeleportLevel teleports the entity to the spawn location of level.
 func (entity *Entity) TeleportLevel(level *Level) {
-	if entity.level == level {
+	lastLevel := entity.Level()
+	if lastLevel == level {
 		return
 	}
 
-	lastLevel := entity.level
 	if lastLevel != nil {
-		entity.level = nil
+		entity.setLevel(nil)
 		entity.despawn(lastLevel)
 		if entity.player != nil {
 			entity.player.despawnLevel(lastLevel)
@@ -154,8 +188,7 @@ func (entity *Entity) TeleportLevel(level *Level) {
 	}
 
 	if level != nil {
-		entity.location = level.Spawn
-		entity.lastLocation = entity.location
+		entity.resetLocation(level.Spawn)
 		if entity.player != nil {
 			entity.player.spawnLevel(level)
 		}
@@ -163,78 +196,106 @@ func (entity *Entity) TeleportLevel(level *Level) {
 		entity.spawn(level)
 	}
 
-	entity.level = level
+	entity.setLevel(level)
 
 	event := EventEntityLevelChange{entity, lastLevel, level}
 	entity.server.FireEvent(EventTypeEntityLevelChange, &event)
 }
 
 func (entity *Entity) update() {
-	if entity.level == nil {
+	entity.locationLock.Lock()
+	level, location, lastLocation := entity.level, entity.location, entity.lastLocation
+	entity.lastLocation = location
+	entity.locationLock.Unlock()
+	if level == nil {
 		return
 	}
 
 	positionDirty := false
-	if entity.location.X != entity.lastLocation.X ||
-		entity.location.Y != entity.lastLocation.Y ||
-		entity.location.Z != entity.lastLocation.Z {
+	if location.X != lastLocation.X ||
+		location.Y != lastLocation.Y ||
+		location.Z != lastLocation.Z {
 		positionDirty = true
 	}
 
 	rotationDirty := false
-	if entity.location.Yaw != entity.lastLocation.Yaw ||
-		entity.location.Pitch != entity.lastLocation.Pitch {
+	if location.Yaw != lastLocation.Yaw ||
+		location.Pitch != lastLocation.Pitch {
 		rotationDirty = true
 	}
 
 	teleport := false
-	if math.Abs(entity.location.X-entity.lastLocation.X) > 1.0 ||
-		math.Abs(entity.location.Y-entity.lastLocation.Y) > 1.0 ||
-		math.Abs(entity.location.Z-entity.lastLocation.Z) > 1.0 {
+	if math.Abs(location.X-lastLocation.X) > 1.0 ||
+		math.Abs(location.Y-lastLocation.Y) > 1.0 ||
+		math.Abs(location.Z-lastLocation.Z) > 1.0 {
 		teleport = true
 	}
 
-	var packet, packetExt packet
+	var packet func(id byte) proto.Packet
 	if teleport {
-		packet.teleport(entity, false, false)
-		packetExt.teleport(entity, false, true)
+		packet = func(id byte) proto.Packet {
+			return teleportPacket(entity, id)
+		}
 	} else if positionDirty && rotationDirty {
-		packet.positionOrientationUpdate(entity)
+		packet = func(id byte) proto.Packet {
+			return &proto.PositionOrientationUpdate{
+				EntityID: id,
+				DX:       location.X - lastLocation.X,
+				DY:       location.Y - lastLocation.Y,
+				DZ:       location.Z - lastLocation.Z,
+				Yaw:      location.Yaw,
+				Pitch:    location.Pitch,
+			}
+		}
 	} else if positionDirty {
-		packet.positionUpdate(entity)
+		packet = func(id byte) proto.Packet {
+			return &proto.PositionUpdate{
+				EntityID: id,
+				DX:       location.X - lastLocation.X,
+				DY:       location.Y - lastLocation.Y,
+				DZ:       location.Z - lastLocation.Z,
+			}
+		}
 	} else if rotationDirty {
-		packet.orientationUpdate(entity)
+		packet = func(id byte) proto.Packet {
+			return &proto.OrientationUpdate{
+				EntityID: id,
+				Yaw:      location.Yaw,
+				Pitch:    location.Pitch,
+			}
+		}
 	} else {
 		return
 	}
 
-	entity.lastLocation = entity.location
-	entity.level.ForEachPlayer(func(player *Player) {
+	level.ForEachPlayer(func(player *Player) {
 		if player.Entity != entity {
-			if teleport && player.cpe[CpeExtEntityPositions] {
-				player.sendPacket(packetExt)
-			} else {
-				player.sendPacket(packet)
-			}
+			player.sendEntityUpdate(entity, packet)
 		}
 	})
 }
 
 // Respawn respawns the entity to all relevant players.
 func (entity *Entity) Respawn() {
-	if entity.level == nil {
+	level := entity.Level()
+	if level == nil {
 		return
 	}
 
-	entity.despawn(entity.level)
-	entity.location = entity.level.Spawn
-	entity.lastLocation = entity.location
-	entity.spawn(entity.level)
+	entity.despawn(level)
+	entity.resetLocation(level.Spawn)
+	if entity.player != nil {
+		entity.player.movement.reset(level.Spawn)
+	}
+
+	entity.spawn(level)
 }
 
 func (entity *Entity) spawn(level *Level) {
 	level.ForEachPlayer(func(player *Player) {
-		player.sendSpawn(entity)
+		if player.canSee(entity) {
+			player.sendSpawn(entity)
+		}
 	})
 }
 
@@ -243,3 +304,59 @@ func (entity *Entity) despawn(level *Level) {
 		player.sendDespawn(entity)
 	})
 }
+
+// entityIDs maps entities to the IDs that a client uses to refer to them.
+// The IDs are assigned when the entities are first sent to the client, so each
+// client can see up to 255 entities, regardless of the number of entities on
+// the server.
+type entityIDs struct {
+	ids      map[*Entity]byte
+	entities [proto.SelfID]*Entity
+}
+
+// get returns the ID assigned to entity.
+func (m *entityIDs) get(entity *Entity) (byte, bool) {
+	id, ok := m.ids[entity]
+	return id, ok
+}
+
+// add assigns an ID to entity. It reports false if all IDs are in use.
+func (m *entityIDs) add(entity *Entity) (byte, bool) {
+	if id, ok := m.ids[entity]; ok {
+		return id, true
+	}
+
+	for id, e := range m.entities {
+		if e == nil {
+			if m.ids == nil {
+				m.ids = make(map[*Entity]byte)
+			}
+
+			m.ids[entity] = byte(id)
+			m.entities[id] = entity
+			return byte(id), true
+		}
+	}
+
+	return 0, false
+}
+
+// remove releases the ID assigned to entity.
+func (m *entityIDs) remove(entity *Entity) (byte, bool) {
+	id, ok := m.ids[entity]
+	if ok {
+		delete(m.ids, entity)
+		m.entities[id] = nil
+	}
+
+	return id, ok
+}
+
+// find returns the entity with the specified ID.
+func (m *entityIDs) find(id byte) *Entity {
+	if id == proto.SelfID {
+		return nil
+	}
+
+	return m.entities[id]
+}
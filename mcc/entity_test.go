@@ -0,0 +1,50 @@
+package mcc
+
+import (
+	"testing"
+
+	"github.com/andreasgoulas/go-mcc/mcc/client"
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+func TestClientEntities(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	alice := connect(t, server, &client.Config{Name: "Alice"})
+	defer alice.Close()
+	bob := connect(t, server, &client.Config{Name: "Bob"})
+	defer bob.Close()
+
+	find := func(name string) (entity client.Entity, ok bool) {
+		for _, entity := range alice.Entities() {
+			if entity.Name == name {
+				return entity, true
+			}
+		}
+
+		return
+	}
+
+	waitFor(t, "Bob to spawn", func() bool {
+		_, ok := find("Bob")
+		return ok
+	})
+
+	spawn := server.MainLevel.Spawn
+	location := proto.Location{X: spawn.X + 2, Y: spawn.Y, Z: spawn.Z + 1}
+	if err := bob.Move(location); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "Bob to move", func() bool {
+		entity, _ := find("Bob")
+		return entity.Location.X == location.X && entity.Location.Z == location.Z
+	})
+
+	bob.Close()
+	waitFor(t, "Bob to despawn", func() bool {
+		_, ok := find("Bob")
+		return !ok
+	})
+}
@@ -1,5 +1,7 @@
 package mcc
 
+import "time"
+
 const (
 	ButtonLeft   = 0
 	ButtonRight  = 1
@@ -25,11 +27,20 @@ const (
 	EventTypeLevelUnload
 	EventTypeLevelSave
 	EventTypeCommand
+	EventTypePlayerMoveViolation
+	EventTypeAddrBlock
+	EventTypeConfigReload
+	EventTypeEntitySpawn
+	EventTypeEntityDespawn
+	EventTypePlayerHeldBlock
+	EventTypePlayerKick
+	EventTypeMessageSend
+	EventTypeServerStart
+	EventTypeServerStop
+	EventTypePluginEnable
+	EventTypePluginDisable
 )
 
-// EventHandler is the type of the function called to handle an event.
-type EventHandler func(eventType int, event interface{})
-
 // EventPlayerLogin is dispatched when a player attempts to log in.
 // If the event is cancelled, the player will be kicked.
 type EventPlayerLogin struct {
@@ -85,13 +96,23 @@ type EventEntityMove struct {
 	Cancel   bool
 }
 
+// EventPlayerMoveViolation is dispatched when the movement validator rejects a
+// movement of a player. Violation is one of the MoveViolation constants.
+// If the event is cancelled, the movement will be allowed.
+type EventPlayerMoveViolation struct {
+	Player    *Player
+	From, To  Location
+	Violation int
+	Cancel    bool
+}
+
 // EventBlockPlace is dispatched when a player places a block.
 // If the event is cancelled, the block will not be placed.
 type EventBlockPlace struct {
 	Player   *Player
 	Level    *Level
-	Block    byte
-	OldBlock byte
+	Block    BlockID
+	OldBlock BlockID
 	X, Y, Z  int
 	Cancel   bool
 }
@@ -101,7 +122,7 @@ type EventBlockPlace struct {
 type EventBlockBreak struct {
 	Player  *Player
 	Level   *Level
-	Block   byte
+	Block   BlockID
 	X, Y, Z int
 	Cancel  bool
 }
@@ -121,6 +142,77 @@ type EventLevelSave struct {
 	Level *Level
 }
 
+// EventAddrBlock is dispatched when an IP address is temporarily blocked for
+// exceeding the connection limits.
+type EventAddrBlock struct {
+	Addr   string
+	Until  time.Time
+	Reason string
+}
+
+// EventConfigReload is dispatched after the server configuration is reloaded.
+type EventConfigReload struct {
+	OldConfig, NewConfig *Config
+}
+
+// EventEntitySpawn is dispatched when an entity is added to the server.
+type EventEntitySpawn struct {
+	Entity *Entity
+}
+
+// EventEntityDespawn is dispatched when an entity is removed from the server.
+type EventEntityDespawn struct {
+	Entity *Entity
+}
+
+// EventPlayerHeldBlock is dispatched when a player changes the held block.
+type EventPlayerHeldBlock struct {
+	Player   *Player
+	From, To BlockID
+}
+
+// EventPlayerKick is dispatched before a player is kicked. Handlers can
+// change Reason. If the event is cancelled, the player will not be kicked,
+// unless Forced is set. Kicks caused by protocol errors and the server
+// shutting down are forced.
+type EventPlayerKick struct {
+	Player *Player
+	Reason string
+	Forced bool
+	Cancel bool
+}
+
+// EventMessageSend is dispatched before a message is sent to a player.
+// Handlers can change Message. If the event is cancelled, the message will
+// not be sent.
+type EventMessageSend struct {
+	Player  *Player
+	Type    int
+	Message string
+	Cancel  bool
+}
+
+// EventServerStart is dispatched when the server has started listening for
+// connections.
+type EventServerStart struct {
+	Server *Server
+}
+
+// EventServerStop is dispatched when the server starts shutting down.
+type EventServerStop struct {
+	Server *Server
+}
+
+// EventPluginEnable is dispatched after a plugin is enabled.
+type EventPluginEnable struct {
+	Plugin Plugin
+}
+
+// EventPluginDisable is dispatched before a plugin is disabled.
+type EventPluginDisable struct {
+	Plugin Plugin
+}
+
 // EventCommand is dispatched before a command is executed.
 type EventCommand struct {
 	Sender  CommandSender
@@ -128,3 +220,12 @@ type EventCommand struct {
 	Message string
 	Allow   bool
 }
+
+func (event *EventPlayerLogin) Cancelled() bool         { return event.Cancel }
+func (event *EventPlayerChat) Cancelled() bool          { return event.Cancel }
+func (event *EventEntityMove) Cancelled() bool          { return event.Cancel }
+func (event *EventPlayerMoveViolation) Cancelled() bool { return event.Cancel }
+func (event *EventBlockPlace) Cancelled() bool          { return event.Cancel }
+func (event *EventBlockBreak) Cancelled() bool          { return event.Cancel }
+func (event *EventPlayerKick) Cancelled() bool          { return event.Cancel }
+func (event *EventMessageSend) Cancelled() bool         { return event.Cancel }
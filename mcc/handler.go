@@ -0,0 +1,192 @@
+package mcc
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	PriorityLowest = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+	PriorityHighest
+	PriorityMonitor
+)
+
+// EventHandler is the type of the function called to handle an event.
+type EventHandler func(eventType int, event interface{})
+
+// Handler is a registered event handler. Handlers are called in order of
+// increasing priority, so the handlers with the highest priority have the
+// final say on the outcome of an event. Monitor handlers are called last and
+// must not modify the event.
+//
+// If IgnoreCancelled is set, the handler is not called for events that have
+// been cancelled by a previous handler.
+//
+// Owner is the plugin that registered the handler. The handlers of a plugin
+// are removed when it is disabled. Handlers without an owner are never
+// removed automatically. Plugins usually register their handlers through a
+// Registrar, which sets the owner.
+type Handler struct {
+	EventType       int
+	Priority        int
+	IgnoreCancelled bool
+	Owner           Plugin
+	Func            EventHandler
+}
+
+// Cancellable is implemented by the events that can be cancelled.
+type Cancellable interface {
+	Cancelled() bool
+}
+
+// AddHandler registers a handler for the specified event type with normal
+// priority.
+func (server *Server) AddHandler(eventType int, handler EventHandler) *Handler {
+	return server.RegisterHandler(&Handler{
+		EventType: eventType,
+		Priority:  PriorityNormal,
+		Func:      handler,
+	})
+}
+
+// Subscribe registers fn with the specified priority. fn must be a function
+// that takes a pointer to one of the event types, such as
+// func(*EventBlockPlace). The event type is derived from its argument.
+func (server *Server) Subscribe(priority int, fn interface{}) *Handler {
+	eventType, handler := HandlerFunc(fn)
+	return server.RegisterHandler(&Handler{
+		EventType: eventType,
+		Priority:  priority,
+		Func:      handler,
+	})
+}
+
+// RegisterHandler registers handler and returns it.
+func (server *Server) RegisterHandler(handler *Handler) *Handler {
+	server.handlersLock.Lock()
+	defer server.handlersLock.Unlock()
+
+	old := server.handlers[handler.EventType]
+	handlers := make([]*Handler, len(old)+1)
+	copy(handlers, old)
+	handlers[len(old)] = handler
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return handlers[i].Priority < handlers[j].Priority
+	})
+
+	server.handlers[handler.EventType] = handlers
+	return handler
+}
+
+// RemoveHandler unregisters handler. It may still be called by events that
+// are being dispatched.
+func (server *Server) RemoveHandler(handler *Handler) {
+	server.handlersLock.Lock()
+	defer server.handlersLock.Unlock()
+	server.removeHandlers(func(h *Handler) bool {
+		return h == handler
+	})
+}
+
+// removePluginHandlers unregisters all handlers owned by plugin.
+func (server *Server) removePluginHandlers(plugin Plugin) {
+	server.handlersLock.Lock()
+	defer server.handlersLock.Unlock()
+	server.removeHandlers(func(h *Handler) bool {
+		return h.Owner == plugin
+	})
+}
+
+func (server *Server) removeHandlers(match func(*Handler) bool) {
+	for eventType, old := range server.handlers {
+		var handlers []*Handler
+		for _, handler := range old {
+			if !match(handler) {
+				handlers = append(handlers, handler)
+			}
+		}
+
+		if len(handlers) != len(old) {
+			server.handlers[eventType] = handlers
+		}
+	}
+}
+
+// FireEvent dispatches event to the server.
+func (server *Server) FireEvent(eventType int, event interface{}) {
+	server.handlersLock.RLock()
+	handlers := server.handlers[eventType]
+	server.handlersLock.RUnlock()
+
+	cancellable, _ := event.(Cancellable)
+	for _, handler := range handlers {
+		if handler.IgnoreCancelled && cancellable != nil && cancellable.Cancelled() {
+			continue
+		}
+
+		handler.Func(eventType, event)
+	}
+}
+
+// HandlerFunc converts fn, which must be a function that takes a pointer to
+// one of the event types, to an EventHandler and returns the event type it
+// handles. It panics if the type of fn is not supported.
+func HandlerFunc(fn interface{}) (int, EventHandler) {
+	switch fn := fn.(type) {
+	case func(*EventPlayerLogin):
+		return EventTypePlayerLogin, func(_ int, e interface{}) { fn(e.(*EventPlayerLogin)) }
+	case func(*EventPlayerJoin):
+		return EventTypePlayerJoin, func(_ int, e interface{}) { fn(e.(*EventPlayerJoin)) }
+	case func(*EventPlayerQuit):
+		return EventTypePlayerQuit, func(_ int, e interface{}) { fn(e.(*EventPlayerQuit)) }
+	case func(*EventPlayerChat):
+		return EventTypePlayerChat, func(_ int, e interface{}) { fn(e.(*EventPlayerChat)) }
+	case func(*EventPlayerClick):
+		return EventTypePlayerClick, func(_ int, e interface{}) { fn(e.(*EventPlayerClick)) }
+	case func(*EventEntityLevelChange):
+		return EventTypeEntityLevelChange, func(_ int, e interface{}) { fn(e.(*EventEntityLevelChange)) }
+	case func(*EventEntityMove):
+		return EventTypeEntityMove, func(_ int, e interface{}) { fn(e.(*EventEntityMove)) }
+	case func(*EventBlockPlace):
+		return EventTypeBlockPlace, func(_ int, e interface{}) { fn(e.(*EventBlockPlace)) }
+	case func(*EventBlockBreak):
+		return EventTypeBlockBreak, func(_ int, e interface{}) { fn(e.(*EventBlockBreak)) }
+	case func(*EventLevelLoad):
+		return EventTypeLevelLoad, func(_ int, e interface{}) { fn(e.(*EventLevelLoad)) }
+	case func(*EventLevelUnload):
+		return EventTypeLevelUnload, func(_ int, e interface{}) { fn(e.(*EventLevelUnload)) }
+	case func(*EventLevelSave):
+		return EventTypeLevelSave, func(_ int, e interface{}) { fn(e.(*EventLevelSave)) }
+	case func(*EventCommand):
+		return EventTypeCommand, func(_ int, e interface{}) { fn(e.(*EventCommand)) }
+	case func(*EventPlayerMoveViolation):
+		return EventTypePlayerMoveViolation, func(_ int, e interface{}) { fn(e.(*EventPlayerMoveViolation)) }
+	case func(*EventAddrBlock):
+		return EventTypeAddrBlock, func(_ int, e interface{}) { fn(e.(*EventAddrBlock)) }
+	case func(*EventConfigReload):
+		return EventTypeConfigReload, func(_ int, e interface{}) { fn(e.(*EventConfigReload)) }
+	case func(*EventEntitySpawn):
+		return EventTypeEntitySpawn, func(_ int, e interface{}) { fn(e.(*EventEntitySpawn)) }
+	case func(*EventEntityDespawn):
+		return EventTypeEntityDespawn, func(_ int, e interface{}) { fn(e.(*EventEntityDespawn)) }
+	case func(*EventPlayerHeldBlock):
+		return EventTypePlayerHeldBlock, func(_ int, e interface{}) { fn(e.(*EventPlayerHeldBlock)) }
+	case func(*EventPlayerKick):
+		return EventTypePlayerKick, func(_ int, e interface{}) { fn(e.(*EventPlayerKick)) }
+	case func(*EventMessageSend):
+		return EventTypeMessageSend, func(_ int, e interface{}) { fn(e.(*EventMessageSend)) }
+	case func(*EventServerStart):
+		return EventTypeServerStart, func(_ int, e interface{}) { fn(e.(*EventServerStart)) }
+	case func(*EventServerStop):
+		return EventTypeServerStop, func(_ int, e interface{}) { fn(e.(*EventServerStop)) }
+	case func(*EventPluginEnable):
+		return EventTypePluginEnable, func(_ int, e interface{}) { fn(e.(*EventPluginEnable)) }
+	case func(*EventPluginDisable):
+		return EventTypePluginDisable, func(_ int, e interface{}) { fn(e.(*EventPluginDisable)) }
+	}
+
+	panic(fmt.Sprintf("mcc: unsupported event handler type %T", fn))
+}
@@ -0,0 +1,102 @@
+package mcc
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// eventTypeNames returns the names of the EventType constants declared in
+// event.go, in the order of their values.
+func eventTypeNames(t *testing.T) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "event.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.CONST {
+			continue
+		}
+
+		for _, spec := range decl.Specs {
+			for _, name := range spec.(*ast.ValueSpec).Names {
+				if strings.HasPrefix(name.Name, "EventType") {
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+func TestHandlerFunc(t *testing.T) {
+	handlers := []interface{}{
+		func(*EventPlayerLogin) {},
+		func(*EventPlayerJoin) {},
+		func(*EventPlayerQuit) {},
+		func(*EventPlayerChat) {},
+		func(*EventPlayerClick) {},
+		func(*EventEntityLevelChange) {},
+		func(*EventEntityMove) {},
+		func(*EventBlockPlace) {},
+		func(*EventBlockBreak) {},
+		func(*EventLevelLoad) {},
+		func(*EventLevelUnload) {},
+		func(*EventLevelSave) {},
+		func(*EventCommand) {},
+		func(*EventPlayerMoveViolation) {},
+		func(*EventAddrBlock) {},
+		func(*EventConfigReload) {},
+		func(*EventEntitySpawn) {},
+		func(*EventEntityDespawn) {},
+		func(*EventPlayerHeldBlock) {},
+		func(*EventPlayerKick) {},
+		func(*EventMessageSend) {},
+		func(*EventServerStart) {},
+		func(*EventServerStop) {},
+		func(*EventPluginEnable) {},
+		func(*EventPluginDisable) {},
+	}
+
+	names := eventTypeNames(t)
+	covered := make(map[int]bool)
+	for _, fn := range handlers {
+		event := reflect.TypeOf(fn).In(0).Elem()
+		eventType, handler := HandlerFunc(fn)
+		if eventType < 0 || eventType >= len(names) {
+			t.Fatalf("%s: got event type %d", event.Name(), eventType)
+		}
+
+		want := "EventType" + strings.TrimPrefix(event.Name(), "Event")
+		if names[eventType] != want {
+			t.Errorf("%s: got %s, want %s", event.Name(), names[eventType], want)
+		}
+
+		// The handler must accept the event that it is registered for.
+		handler(eventType, reflect.New(event).Interface())
+		covered[eventType] = true
+	}
+
+	for eventType, name := range names {
+		if !covered[eventType] {
+			t.Errorf("%s has no case in HandlerFunc", name)
+		}
+	}
+}
+
+func TestHandlerFuncUnsupported(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("HandlerFunc accepted an unsupported function")
+		}
+	}()
+
+	HandlerFunc(func(*Player) {})
+}
@@ -0,0 +1,305 @@
+package mcc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultSaltFile = "salts.json"
+
+	heartbeatRetryInterval = 5 * time.Second
+	heartbeatTimeout       = 10 * time.Second
+)
+
+var heartbeatClient = &http.Client{Timeout: heartbeatTimeout}
+
+// heartbeat holds the state of a server list.
+type heartbeat struct {
+	url      string
+	salt     string
+	playURL  string
+	failures int
+	next     time.Time
+}
+
+// heartbeats holds the state of all server lists and their salts.
+type heartbeats struct {
+	lock    sync.Mutex
+	path    string
+	salts   map[string]string
+	targets map[string]*heartbeat
+}
+
+// heartbeatURLs returns the heartbeat URLs of config, without duplicates.
+func (config *Config) heartbeatURLs() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, u := range append([]string{config.Heartbeat}, config.Heartbeats...) {
+		if len(u) > 0 && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// saltFile returns the path of the file that stores the salts.
+func (config *Config) saltFile() string {
+	if len(config.SaltFile) > 0 {
+		return config.SaltFile
+	}
+
+	return DefaultSaltFile
+}
+
+func generateSalt() string {
+	const charset = "abcdefghijklmnopqrstuvwxyz" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"0123456789"
+
+	var salt [16]byte
+	rand.Read(salt[:])
+	for i := range salt {
+		salt[i] = charset[int(salt[i])%len(charset)]
+	}
+
+	return string(salt[:])
+}
+
+// load reads the salts from the file at path.
+func (hb *heartbeats) load(path string) {
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	hb.path = path
+	hb.salts = make(map[string]string)
+	hb.targets = make(map[string]*heartbeat)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("loadSalts: %s\n", err)
+		}
+
+		return
+	}
+
+	if err := json.Unmarshal(data, &hb.salts); err != nil {
+		log.Printf("loadSalts: %s\n", err)
+	}
+}
+
+// update synchronizes the server lists with urls. New lists reuse the saved
+// salt, or a new one is generated and saved.
+func (hb *heartbeats) update(urls []string) {
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	active := make(map[string]bool)
+	dirty := false
+	for _, u := range urls {
+		active[u] = true
+		if hb.targets[u] != nil {
+			continue
+		}
+
+		salt, ok := hb.salts[u]
+		if !ok {
+			salt = generateSalt()
+			hb.salts[u] = salt
+			dirty = true
+		}
+
+		hb.targets[u] = &heartbeat{url: u, salt: salt}
+	}
+
+	for u := range hb.targets {
+		if !active[u] {
+			delete(hb.targets, u)
+		}
+	}
+
+	if dirty {
+		data, err := json.MarshalIndent(hb.salts, "", "\t")
+		if err == nil {
+			err = writeFile(hb.path, 0600, func(writer io.Writer) error {
+				_, err := writer.Write(data)
+				return err
+			})
+		}
+
+		if err != nil {
+			log.Printf("saveSalts: %s\n", err)
+		}
+	}
+}
+
+// Salts returns the salts of the server lists, which are used to verify the
+// names of the players.
+func (server *Server) Salts() []string {
+	hb := &server.heartbeats
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	salts := make([]string, 0, len(hb.targets))
+	for _, target := range hb.targets {
+		salts = append(salts, target.salt)
+	}
+
+	return salts
+}
+
+// PlayURLs returns the play URL returned by each server list, indexed by the
+// heartbeat URL. Lists that have not responded yet are omitted.
+func (server *Server) PlayURLs() map[string]string {
+	hb := &server.heartbeats
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	urls := make(map[string]string)
+	for u, target := range hb.targets {
+		if len(target.playURL) > 0 {
+			urls[u] = target.playURL
+		}
+	}
+
+	return urls
+}
+
+// sendHeartbeats sends a heartbeat to each server list that is due. The
+// lists are sent to concurrently, so that a slow list does not delay the
+// others. Failed heartbeats are retried with exponential backoff.
+func (server *Server) sendHeartbeats() {
+	config := server.Config()
+	hb := &server.heartbeats
+	hb.update(config.heartbeatURLs())
+
+	now := time.Now()
+	hb.lock.Lock()
+	var due []heartbeat
+	for _, target := range hb.targets {
+		if !now.Before(target.next) {
+			due = append(due, *target)
+		}
+	}
+	hb.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, target := range due {
+		wg.Add(1)
+		go func(target heartbeat) {
+			defer wg.Done()
+			playURL, err := server.sendHeartbeat(config, target.url, target.salt)
+
+			hb.lock.Lock()
+			defer hb.lock.Unlock()
+			current := hb.targets[target.url]
+			if current == nil {
+				return
+			}
+
+			if err != nil {
+				server.stats.heartbeats.WithLabels(target.url, "failure").Inc()
+				current.failures++
+				current.next = time.Now().Add(heartbeatBackoff(current.failures))
+				log.Printf("sendHeartbeat: %s: %s\n", target.url, err)
+			} else {
+				server.stats.heartbeats.WithLabels(target.url, "success").Inc()
+				current.failures = 0
+				current.next = time.Now().Add(HeartbeatInterval)
+				current.playURL = playURL
+			}
+		}(target)
+	}
+
+	wg.Wait()
+}
+
+// heartbeatBackoff returns the delay before retrying a heartbeat that failed
+// the specified number of times in a row.
+func heartbeatBackoff(failures int) time.Duration {
+	delay := heartbeatRetryInterval
+	for i := 1; i < failures && delay < HeartbeatInterval; i++ {
+		delay *= 2
+	}
+
+	if delay > HeartbeatInterval {
+		delay = HeartbeatInterval
+	}
+
+	return delay
+}
+
+// sendHeartbeat sends a heartbeat to the server list at heartbeatURL and
+// returns the play URL of the server.
+func (server *Server) sendHeartbeat(config *Config, heartbeatURL, salt string) (string, error) {
+	form := url.Values{}
+	form.Add("name", config.Name)
+	form.Add("port", strconv.Itoa(config.publicPort()))
+	form.Add("max", strconv.Itoa(config.MaxPlayers))
+	form.Add("users", strconv.Itoa(int(atomic.LoadInt32(&server.playerCount))))
+	form.Add("salt", salt)
+	form.Add("version", "7")
+	form.Add("software", ServerSoftware)
+	if config.Public {
+		form.Add("public", "True")
+	} else {
+		form.Add("public", "False")
+	}
+
+	response, err := heartbeatClient.PostForm(heartbeatURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New(response.Status)
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		data := struct {
+			Status   string     `json:"status"`
+			Response string     `json:"response"`
+			Errors   [][]string `json:"errors"`
+		}{}
+
+		if err := json.Unmarshal(body, &data); err != nil {
+			return "", err
+		}
+
+		if len(data.Errors) > 0 && len(data.Errors[0]) > 0 {
+			return "", errors.New(data.Errors[0][0])
+		}
+
+		if data.Status == "fail" {
+			return "", fmt.Errorf("heartbeat failed: %s", data.Response)
+		}
+
+		return strings.TrimSpace(data.Response), nil
+	}
+
+	return string(body), nil
+}
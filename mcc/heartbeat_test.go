@@ -0,0 +1,234 @@
+package mcc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testList is a fake server list that records the salts it receives.
+type testList struct {
+	*httptest.Server
+	lock  sync.Mutex
+	salts []string
+}
+
+func newTestList(status int, response string) *testList {
+	list := &testList{}
+	list.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list.lock.Lock()
+		list.salts = append(list.salts, r.PostFormValue("salt"))
+		list.lock.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+
+	return list
+}
+
+func (list *testList) received() []string {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	return append([]string(nil), list.salts...)
+}
+
+func TestHeartbeat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	classic := newTestList(http.StatusOK, "http://classic/play\n")
+	defer classic.Close()
+	modern := newTestList(http.StatusOK, `{"status": "ok", "response": " http://modern/play "}`)
+	defer modern.Close()
+	broken := newTestList(http.StatusInternalServerError, "")
+	defer broken.Close()
+
+	config := &Config{
+		Name:       "Test Server",
+		MaxPlayers: 8,
+		MainLevel:  "main",
+		Heartbeat:  classic.URL,
+		Heartbeats: []string{modern.URL, broken.URL, classic.URL},
+		SaltFile:   filepath.Join(dir, "salts.json"),
+	}
+
+	server := NewServer(config, NewCwStorage(filepath.Join(dir, "levels")))
+	if server == nil {
+		t.Fatal("NewServer failed")
+	}
+
+	server.sendHeartbeats()
+
+	// Each list receives its own salt, which is saved.
+	var saved map[string]string
+	data, err := ioutil.ReadFile(config.SaltFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, list := range []*testList{classic, modern, broken} {
+		salts := list.received()
+		if len(salts) != 1 {
+			t.Fatalf("%s: got %d heartbeats, want 1", list.URL, len(salts))
+		}
+
+		if salts[0] != saved[list.URL] || len(salts[0]) != 16 || seen[salts[0]] {
+			t.Errorf("%s: got salt %q, saved %q", list.URL, salts[0], saved[list.URL])
+		}
+
+		seen[salts[0]] = true
+	}
+
+	if salts := server.Salts(); len(salts) != 3 {
+		t.Errorf("got %d salts, want 3", len(salts))
+	}
+
+	playURLs := server.PlayURLs()
+	if len(playURLs) != 2 ||
+		playURLs[classic.URL] != "http://classic/play" ||
+		playURLs[modern.URL] != "http://modern/play" {
+		t.Errorf("got play URLs %v", playURLs)
+	}
+
+	// The failed list is retried with backoff, and the rest wait for the
+	// heartbeat interval.
+	state := func(u string) (time.Time, int) {
+		server.heartbeats.lock.Lock()
+		defer server.heartbeats.lock.Unlock()
+		target := server.heartbeats.targets[u]
+		return target.next, target.failures
+	}
+
+	for i := 1; i <= 2; i++ {
+		start := time.Now()
+		next, failures := state(broken.URL)
+		if failures != i {
+			t.Fatalf("got %d failures, want %d", failures, i)
+		}
+
+		if delay := next.Sub(start); delay > heartbeatBackoff(i) || delay < heartbeatBackoff(i)-time.Second {
+			t.Errorf("after %d failures: retry in %s, want %s", i, delay, heartbeatBackoff(i))
+		}
+
+		server.heartbeats.lock.Lock()
+		server.heartbeats.targets[broken.URL].next = time.Time{}
+		server.heartbeats.lock.Unlock()
+		server.sendHeartbeats()
+	}
+
+	if n := len(classic.received()); n != 1 {
+		t.Errorf("got %d heartbeats before the interval, want 1", n)
+	}
+
+	if _, failures := state(classic.URL); failures != 0 {
+		t.Errorf("got %d failures for a working list", failures)
+	}
+
+	// The salts are reused after a restart.
+	server = NewServer(config, NewCwStorage(filepath.Join(dir, "levels")))
+	if server == nil {
+		t.Fatal("NewServer failed")
+	}
+
+	server.sendHeartbeats()
+	for _, list := range []*testList{classic, modern, broken} {
+		salts := list.received()
+		if last := salts[len(salts)-1]; last != saved[list.URL] {
+			t.Errorf("%s: got salt %q after restart, want %q", list.URL, last, saved[list.URL])
+		}
+	}
+}
+
+func TestHeartbeatBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, heartbeatRetryInterval},
+		{2, 2 * heartbeatRetryInterval},
+		{3, 4 * heartbeatRetryInterval},
+		{4, 8 * heartbeatRetryInterval},
+		{5, HeartbeatInterval},
+		{100, HeartbeatInterval},
+	}
+
+	for _, test := range tests {
+		if got := heartbeatBackoff(test.failures); got != test.want {
+			t.Errorf("heartbeatBackoff(%d) = %s, want %s", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestHeartbeatConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const delay = 500 * time.Millisecond
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("http://slow/play"))
+	}
+
+	var urls []string
+	for i := 0; i < 3; i++ {
+		list := httptest.NewServer(http.HandlerFunc(slow))
+		defer list.Close()
+		urls = append(urls, list.URL)
+	}
+
+	config := &Config{
+		Name:       "Test Server",
+		MaxPlayers: 8,
+		MainLevel:  "main",
+		Heartbeats: urls,
+		SaltFile:   filepath.Join(dir, "salts.json"),
+	}
+
+	server := NewServer(config, NewCwStorage(filepath.Join(dir, "levels")))
+	if server == nil {
+		t.Fatal("NewServer failed")
+	}
+
+	start := time.Now()
+	server.sendHeartbeats()
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Errorf("heartbeats took %s, want them sent concurrently", elapsed)
+	}
+
+	if n := len(server.PlayURLs()); n != len(urls) {
+		t.Errorf("got %d play URLs, want %d", n, len(urls))
+	}
+
+	// The salts are written to a temporary file that replaces the salt file.
+	info, err := os.Stat(config.SaltFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("salt file has mode %o, want 600", mode)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) > 0 {
+		t.Errorf("temporary files were left behind: %v", matches)
+	}
+}
@@ -0,0 +1,95 @@
+// Package mcctest provides helpers for the tests of the packages that run an
+// mcc.Server.
+package mcctest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+)
+
+// Timeout is the time that the tests wait for the server.
+const Timeout = 5 * time.Second
+
+// NewServer starts a server that stores its files in a temporary directory.
+// It returns the server and a function that stops it and removes the
+// directory.
+func NewServer(t *testing.T) (*mcc.Server, func()) {
+	dir, err := ioutil.TempDir("", "mcctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &mcc.Config{
+		Name:       "Test Server",
+		MOTD:       "Test MOTD",
+		MaxPlayers: 8,
+		MainLevel:  "main",
+		Listeners:  []mcc.ListenerConfig{{Addr: "127.0.0.1:0"}},
+		SaltFile:   filepath.Join(dir, "salt"),
+	}
+
+	server := mcc.NewServer(config, mcc.NewCwStorage(filepath.Join(dir, "levels")))
+	if server == nil {
+		os.RemoveAll(dir)
+		t.Fatal("NewServer failed")
+	}
+
+	var wg sync.WaitGroup
+	if err := server.Start(&wg); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return server, func() {
+		server.Stop()
+		wg.Wait()
+		os.RemoveAll(dir)
+	}
+}
+
+// WaitFor polls cond until it returns true or Timeout expires.
+func WaitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Sender is a CommandSender that can execute all commands and queues the
+// messages it receives.
+type Sender struct {
+	server   *mcc.Server
+	Messages chan string
+}
+
+// NewSender returns a new Sender for server.
+func NewSender(server *mcc.Server) *Sender {
+	return &Sender{server, make(chan string, 16)}
+}
+
+func (sender *Sender) Server() *mcc.Server                  { return sender.server }
+func (sender *Sender) Name() string                         { return "Tester" }
+func (sender *Sender) SendMessage(message string)           { sender.Messages <- message }
+func (sender *Sender) CanExecute(command *mcc.Command) bool { return true }
+
+// Message returns the next message that is sent to sender, or fails the test
+// after Timeout.
+func (sender *Sender) Message(t *testing.T) string {
+	select {
+	case message := <-sender.Messages:
+		return message
+	case <-time.After(Timeout):
+		t.Fatal("timed out waiting for a message")
+		return ""
+	}
+}
@@ -15,7 +15,7 @@ type LevelStorage interface {
 // Simulator is the interface that must be implemented by block-based physics
 // simulators.
 type Simulator interface {
-	Update(block, old byte, index int)
+	Update(block, old BlockID, index int)
 	Tick()
 }
 
@@ -30,8 +30,8 @@ type EnvConfig struct {
 	Weather     byte
 	TexturePack string
 
-	SideBlock       byte
-	EdgeBlock       byte
+	SideBlock       BlockID
+	EdgeBlock       BlockID
 	EdgeHeight      int
 	CloudHeight     int
 	MaxViewDistance int
@@ -90,8 +90,13 @@ type Level struct {
 	Width  int
 	Height int
 	Length int
-	Blocks []byte
-	Dirty  bool
+
+	// Blocks contains the low bytes of the blocks and Blocks2 the high
+	// bytes. Blocks2 is nil until a block above 255 is set, so that levels
+	// without extended blocks take a byte per block.
+	Blocks  []byte
+	Blocks2 []byte
+	Dirty   bool
 
 	Name        string
 	UUID        [16]byte
@@ -101,12 +106,23 @@ type Level struct {
 	EnvConfig   EnvConfig
 	HackConfig  HackConfig
 	BlockDefs   []*BlockDefinition
-	Inventory   []byte
+	Inventory   []BlockID
+
+	// ViewDistance is the distance up to which players can see other
+	// entities. If it is zero, the view-distance option of the server is
+	// used.
+	ViewDistance float64
 
 	Metadata, MetadataCPE map[string]interface{}
 
+	// blocksLock is held while Blocks is changed, so that the copies made
+	// for snapshots are consistent.
+	blocksLock sync.RWMutex
+
 	simulators     []Simulator
 	simulatorsLock sync.RWMutex
+
+	snapshots snapshotCache
 }
 
 // NewLevel creates a new empty Level with the specified name and dimensions.
@@ -168,12 +184,17 @@ func (level *Level) Clone(name string) *Level {
 	}
 
 	copy(newLevel.Blocks, level.Blocks)
+	if level.Blocks2 != nil {
+		newLevel.Blocks2 = make([]byte, len(level.Blocks2))
+		copy(newLevel.Blocks2, level.Blocks2)
+	}
+
 	if level.BlockDefs != nil {
 		newLevel.BlockDefs = make([]*BlockDefinition, len(level.BlockDefs))
 		copy(newLevel.BlockDefs, level.BlockDefs)
 	}
 	if level.Inventory != nil {
-		newLevel.Inventory = make([]byte, len(level.Inventory))
+		newLevel.Inventory = make([]BlockID, len(level.Inventory))
 		copy(newLevel.Inventory, level.Inventory)
 	}
 
@@ -222,13 +243,65 @@ func (level *Level) Position(index int) (x, y, z int) {
 // InBounds reports whether the specified coordinates are within the bounds of
 // the level.
 func (level *Level) InBounds(x, y, z int) bool {
-	return x < level.Width && y < level.Height && z < level.Length
+	return x >= 0 && y >= 0 && z >= 0 &&
+		x < level.Width && y < level.Height && z < level.Length
+}
+
+// blockDef returns the definition of block, or nil if block is not a custom
+// block.
+func (level *Level) blockDef(block BlockID) *BlockDefinition {
+	if int(block) < len(level.BlockDefs) {
+		return level.BlockDefs[block]
+	}
+
+	return nil
+}
+
+// block returns the block at index.
+func (level *Level) block(index int) BlockID {
+	block := BlockID(level.Blocks[index])
+	if level.Blocks2 != nil {
+		block |= BlockID(level.Blocks2[index]) << 8
+	}
+
+	return block
+}
+
+// setBlock sets the block at index. Blocks2 is allocated when the first
+// extended block is set.
+func (level *Level) setBlock(index int, block BlockID) {
+	level.Blocks[index] = byte(block)
+	if block > 0xff && level.Blocks2 == nil {
+		level.Blocks2 = make([]byte, len(level.Blocks))
+	}
+
+	if level.Blocks2 != nil {
+		level.Blocks2[index] = byte(block >> 8)
+	}
+}
+
+// copyBlocks returns a copy of Blocks and Blocks2 that is not torn by
+// concurrent block changes.
+func (level *Level) copyBlocks() (blocks, blocks2 []byte) {
+	level.blocksLock.RLock()
+	defer level.blocksLock.RUnlock()
+
+	blocks = make([]byte, len(level.Blocks))
+	copy(blocks, level.Blocks)
+	if level.Blocks2 != nil {
+		blocks2 = make([]byte, len(level.Blocks2))
+		copy(blocks2, level.Blocks2)
+	}
+
+	return
 }
 
 // GetBlock returns the block at the specified coordinates.
-func (level *Level) GetBlock(x, y, z int) byte {
-	if x < level.Width && y < level.Height && z < level.Length {
-		return level.Blocks[level.Index(x, y, z)]
+func (level *Level) GetBlock(x, y, z int) BlockID {
+	if level.InBounds(x, y, z) {
+		level.blocksLock.RLock()
+		defer level.blocksLock.RUnlock()
+		return level.block(level.Index(x, y, z))
 	}
 
 	return BlockAir
@@ -236,10 +309,14 @@ func (level *Level) GetBlock(x, y, z int) byte {
 
 // SetBlockFast sets the block at the specified coordinates without notifying
 // the physics simulators.
-func (level *Level) SetBlockFast(x, y, z int, block byte) {
+func (level *Level) SetBlockFast(x, y, z int, block BlockID) {
 	if level.InBounds(x, y, z) {
+		level.blocksLock.Lock()
 		level.Dirty = true
-		level.Blocks[level.Index(x, y, z)] = block
+		level.setBlock(level.Index(x, y, z), block)
+		level.Invalidate()
+		level.blocksLock.Unlock()
+
 		level.ForEachPlayer(func(player *Player) {
 			player.sendBlockChange(x, y, z, block)
 		})
@@ -247,13 +324,16 @@ func (level *Level) SetBlockFast(x, y, z int, block byte) {
 }
 
 // SetBlock sets the block at the specified coordinates.
-func (level *Level) SetBlock(x, y, z int, block byte) {
+func (level *Level) SetBlock(x, y, z int, block BlockID) {
 	if level.InBounds(x, y, z) {
 		index := level.Index(x, y, z)
-		old := level.Blocks[index]
-
+		level.blocksLock.Lock()
+		old := level.block(index)
 		level.Dirty = true
-		level.Blocks[index] = block
+		level.setBlock(index, block)
+		level.Invalidate()
+		level.blocksLock.Unlock()
+
 		level.ForEachPlayer(func(player *Player) {
 			player.sendBlockChange(x, y, z, block)
 		})
@@ -286,19 +366,23 @@ func (level *Level) SetBlock(x, y, z int, block byte) {
 }
 
 // FillLayers fills the specified range of layers with block.
-func (level *Level) FillLayers(yStart, yEnd int, block byte) {
+func (level *Level) FillLayers(yStart, yEnd int, block BlockID) {
 	start := yStart * level.Width * level.Length
 	end := (yEnd + 1) * level.Width * level.Length
+	level.blocksLock.Lock()
 	for i := start; i < end; i++ {
-		level.Blocks[i] = block
+		level.setBlock(i, block)
 	}
+
+	level.Invalidate()
+	level.blocksLock.Unlock()
 }
 
 // ForEachEntity calls fn for each entity in the level.
 func (level *Level) ForEachEntity(fn func(*Entity)) {
 	if level.server != nil {
 		level.server.ForEachEntity(func(entity *Entity) {
-			if entity.level == level {
+			if entity.Level() == level {
 				fn(entity)
 			}
 		})
@@ -309,7 +393,7 @@ func (level *Level) ForEachEntity(fn func(*Entity)) {
 func (level *Level) ForEachPlayer(fn func(*Player)) {
 	if level.server != nil {
 		level.server.ForEachPlayer(func(player *Player) {
-			if player.level == level {
+			if player.Level() == level {
 				fn(player)
 			}
 		})
@@ -337,10 +421,10 @@ func (level *Level) SendMOTD() {
 		if player.cpe[CpeInstantMOTD] {
 			player.sendMOTD(level)
 		} else {
-			player.level = nil
+			player.setLevel(nil)
 			player.despawnLevel(level)
 			player.spawnLevel(level)
-			player.level = level
+			player.setLevel(level)
 		}
 	})
 }
@@ -351,7 +435,8 @@ func (level *Level) AddSimulator(simulator Simulator) {
 	level.simulators = append(level.simulators, simulator)
 	level.simulatorsLock.Unlock()
 
-	for index, block := range level.Blocks {
+	for index := range level.Blocks {
+		block := level.block(index)
 		simulator.Update(block, block, index)
 	}
 }
@@ -381,7 +466,10 @@ func (level *Level) RemoveSimulator(simulator Simulator) {
 // UpdateBlock updates the block at the specified coordinates.
 func (level *Level) UpdateBlock(x, y, z int) {
 	index := level.Index(x, y, z)
-	block := level.Blocks[index]
+	level.blocksLock.RLock()
+	block := level.block(index)
+	level.blocksLock.RUnlock()
+
 	level.simulatorsLock.RLock()
 	for _, simulator := range level.simulators {
 		simulator.Update(block, block, index)
@@ -389,10 +477,11 @@ func (level *Level) UpdateBlock(x, y, z int) {
 	level.simulatorsLock.RUnlock()
 }
 
-func (level *Level) update() {
+func (level *Level) update(timer *tickTimer) {
 	level.simulatorsLock.RLock()
 	for _, simulator := range level.simulators {
 		simulator.Tick()
+		timer.phase(level.Name + "/" + simulatorName(simulator))
 	}
 	level.simulatorsLock.RUnlock()
 }
@@ -402,7 +491,7 @@ type BlockBuffer struct {
 	level   *Level
 	count   int
 	indices [256]int32
-	blocks  [256]byte
+	blocks  [256]BlockID
 }
 
 // NewBlockBuffer returns a new BlockBuffer to queue changes to level.
@@ -411,7 +500,7 @@ func NewBlockBuffer(level *Level) *BlockBuffer {
 }
 
 // Set sets the block at the specified coordinates.
-func (buffer *BlockBuffer) Set(x, y, z int, block byte) {
+func (buffer *BlockBuffer) Set(x, y, z int, block BlockID) {
 	buffer.indices[buffer.count] = int32(buffer.level.Index(x, y, z))
 	buffer.blocks[buffer.count] = block
 	buffer.count++
@@ -422,29 +511,18 @@ func (buffer *BlockBuffer) Set(x, y, z int, block byte) {
 
 // Flush flushes any pending changes to the underlying level.
 func (buffer *BlockBuffer) Flush() {
+	buffer.level.blocksLock.Lock()
 	for i := 0; i < buffer.count; i++ {
 		index := buffer.indices[i]
-		buffer.level.Blocks[index] = buffer.blocks[i]
+		buffer.level.setBlock(int(index), buffer.blocks[i])
 	}
 
 	buffer.level.Dirty = true
-	buffer.level.ForEachPlayer(func(player *Player) {
-		var blocks [256]byte
-		for i := 0; i < buffer.count; i++ {
-			blocks[i] = player.convertBlock(buffer.blocks[i], buffer.level)
-		}
+	buffer.level.Invalidate()
+	buffer.level.blocksLock.Unlock()
 
-		var packet packet
-		if player.cpe[CpeBulkBlockUpdate] {
-			packet.bulkBlockUpdate(buffer.indices[:], blocks[:buffer.count])
-		} else {
-			for i := 0; i < buffer.count; i++ {
-				x, y, z := buffer.level.Position(int(buffer.indices[i]))
-				packet.setBlock(x, y, z, blocks[i])
-			}
-		}
-
-		player.sendPacket(packet)
+	buffer.level.ForEachPlayer(func(player *Player) {
+		player.sendBlockChanges(buffer.level, buffer.indices[:buffer.count], buffer.blocks[:buffer.count])
 	})
 
 	buffer.count = 0
@@ -0,0 +1,126 @@
+package mcc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/client"
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+func TestClientLogin(t *testing.T) {
+	withoutFastMap := make([]proto.ExtEntry, 0, len(client.Extensions))
+	for _, ext := range client.Extensions {
+		if ext.ExtName != "FastMap" {
+			withoutFastMap = append(withoutFastMap, ext)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		config  client.Config
+		fastMap bool
+		legacy  bool
+	}{
+		{"FastMap", client.Config{}, true, false},
+		{"Gzip", client.Config{Extensions: withoutFastMap}, false, false},
+		{"Vanilla", client.Config{DisableCPE: true}, false, false},
+		{"Version6", client.Config{Version: proto.Version6}, false, true},
+		{"Version5", client.Config{Version: proto.Version5}, false, true},
+	}
+
+	server, stop := newTestServer(t)
+	defer stop()
+
+	level := server.MainLevel
+	level.SetBlock(1, 2, 3, BlockStone)
+	level.SetBlock(2, 2, 3, BlockObsidian)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Name = test.name
+			c := connect(t, server, &config)
+			defer c.Close()
+
+			if c.HasExtension("FastMap") != test.fastMap {
+				t.Errorf("FastMap negotiated: %v", !test.fastMap)
+			}
+
+			// The server identifies itself before it sends the level.
+			waitFor(t, "level", func() bool { return c.Level() != nil })
+			if c.ServerName() != "Test Server" || c.MOTD() != "Test MOTD" {
+				t.Errorf("got server %q, MOTD %q", c.ServerName(), c.MOTD())
+			}
+
+			received := c.Level()
+			if received.Width != level.Width || received.Height != level.Height || received.Length != level.Length {
+				t.Fatalf("got level %dx%dx%d, want %dx%dx%d",
+					received.Width, received.Height, received.Length,
+					level.Width, level.Height, level.Length)
+			}
+
+			for i := range level.Blocks {
+				x, y, z := level.Position(i)
+				block := level.block(i)
+				if test.legacy {
+					block = FallbackBlockLegacy(block)
+				}
+
+				if got := received.GetBlock(x, y, z); got != uint16(block) {
+					t.Fatalf("block at %d,%d,%d: got %d, want %d", x, y, z, got, block)
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyIdentification(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go NewPlayer(serverConn, server).handle()
+
+	// Clients older than protocol version 7 send and receive the
+	// identification without the trailing byte.
+	ident := []byte{proto.TypeIdentification, proto.Version6}
+	ident = append(ident, bytes.Repeat([]byte{' '}, 128)...)
+	copy(ident[2:], "Legacy")
+	clientConn.SetDeadline(time.Now().Add(testTimeout))
+	if _, err := clientConn.Write(ident); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{proto.TypeIdentification, proto.Version6}
+	want = append(want, bytes.Repeat([]byte{' '}, 128)...)
+	copy(want[2:], "Test Server")
+	copy(want[66:], "Test MOTD")
+
+	reader := proto.NewReader(clientConn, &proto.Codec{Version: proto.Version6}, proto.ClientBound)
+	for {
+		data, err := reader.ReadRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data[0] == proto.TypeIdentification {
+			if !bytes.Equal(data, want) {
+				t.Fatalf("got identification % x, want % x", data, want)
+			}
+
+			break
+		}
+	}
+
+	data, err := reader.ReadRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0] != proto.TypeLevelInitialize {
+		t.Fatalf("got packet %#x after the identification, want LevelInitialize", data[0])
+	}
+}
@@ -74,16 +74,13 @@ func (storage *LvlStorage) Load(name string) (level *Level, err error) {
 }
 
 // Save implements LevelStorage.
-func (storage *LvlStorage) Save(level *Level) (err error) {
-	file, err := os.Create(storage.getPath(level.Name))
-	if err != nil {
-		return
-	}
-
-	writer := gzip.NewWriter(file)
-	defer file.Close()
-	defer writer.Close()
+func (storage *LvlStorage) Save(level *Level) error {
+	return writeFileGzip(storage.getPath(level.Name), func(writer io.Writer) error {
+		return storage.write(writer, level)
+	})
+}
 
+func (storage *LvlStorage) write(writer io.Writer, level *Level) (err error) {
 	if err = binary.Write(writer, binary.BigEndian, lvlHeader{
 		1874,
 		int16(level.Width),
@@ -99,6 +96,19 @@ func (storage *LvlStorage) Save(level *Level) (err error) {
 		return
 	}
 
-	_, err = writer.Write(level.Blocks)
+	blocks, blocks2 := level.copyBlocks()
+	for i := range blocks2 {
+		block := BlockID(blocks[i]) | BlockID(blocks2[i])<<8
+		if block > 0xff {
+			if def := level.blockDef(block); def != nil && def.Fallback <= 0xff {
+				block = def.Fallback
+			} else {
+				block = BlockAir
+			}
+		}
+		blocks[i] = byte(block)
+	}
+
+	_, err = writer.Write(blocks)
 	return
 }
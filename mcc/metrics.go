@@ -0,0 +1,484 @@
+package mcc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is a registry of metrics that can be exported in the Prometheus
+// text format.
+//
+// Registering a metric with the name, definition and owner of an existing one
+// returns the existing metric. Registering a different metric with the same
+// name panics, and so does registering a gauge function twice, since the
+// second function could never report its values. The metrics registered
+// through the registry returned by Registrar.Metrics are owned by a plugin,
+// and are removed when the plugin is disabled.
+type Metrics struct {
+	*registry
+	owner Plugin
+}
+
+type registry struct {
+	lock    sync.RWMutex
+	metrics map[string]*metricDesc
+}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+// NewMetrics returns a new, empty registry.
+func NewMetrics() *Metrics {
+	return &Metrics{registry: &registry{metrics: make(map[string]*metricDesc)}}
+}
+
+// register registers metric and returns it, or returns the existing metric
+// with the same name, definition and owner.
+func (m *Metrics) register(name, help, kind string, metric metric) metric {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if desc, ok := m.metrics[name]; ok {
+		if desc.help != help || desc.kind != kind || desc.owner != m.owner ||
+			!sameMetric(desc.metric, metric) {
+			panic("metrics: duplicate metric " + name)
+		}
+
+		return desc.metric
+	}
+
+	m.metrics[name] = &metricDesc{help, kind, metric, m.owner}
+	return metric
+}
+
+// sameMetric reports whether a and b have the same type and labels or
+// buckets. Gauge functions are never the same, since functions cannot be
+// compared.
+func sameMetric(a, b metric) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *CounterVec:
+		return reflect.DeepEqual(a.labels, b.(*CounterVec).labels)
+	case gaugeFunc, *gaugeVecFunc:
+		return false
+	case *Histogram:
+		return reflect.DeepEqual(a.buckets, b.(*Histogram).buckets)
+	}
+
+	return true
+}
+
+// Unregister removes the metric with the specified name. It reports whether
+// the metric was registered.
+func (m *Metrics) Unregister(name string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, ok := m.metrics[name]
+	delete(m.metrics, name)
+	return ok
+}
+
+// removeOwner removes all metrics owned by plugin.
+func (m *Metrics) removeOwner(plugin Plugin) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for name, desc := range m.metrics {
+		if desc.owner == plugin {
+			delete(m.metrics, name)
+		}
+	}
+}
+
+// NewCounter registers a counter with the specified name.
+func (m *Metrics) NewCounter(name, help string) *Counter {
+	return m.register(name, help, "counter", &Counter{}).(*Counter)
+}
+
+// NewCounterVec registers a counter that is partitioned by the specified
+// labels.
+func (m *Metrics) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	vec := &CounterVec{labels: labels, counters: make(map[string]*labeledCounter)}
+	return m.register(name, help, "counter", vec).(*CounterVec)
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn. It panics if
+// the name is already registered.
+func (m *Metrics) NewGaugeFunc(name, help string, fn func() float64) {
+	m.register(name, help, "gauge", gaugeFunc(fn))
+}
+
+// NewGaugeVecFunc registers a gauge that is partitioned by the specified
+// labels. When the metrics are collected, fn is called and must call report
+// for each value. It panics if the name is already registered.
+func (m *Metrics) NewGaugeVecFunc(name, help string, labels []string,
+	fn func(report func(value float64, values ...string))) {
+	m.register(name, help, "gauge", &gaugeVecFunc{labels, fn})
+}
+
+// NewHistogram registers a histogram with the specified upper bounds.
+func (m *Metrics) NewHistogram(name, help string, buckets []float64) *Histogram {
+	histogram := &Histogram{
+		buckets: append([]float64(nil), buckets...),
+		counts:  make([]uint64, len(buckets)),
+	}
+
+	sort.Float64s(histogram.buckets)
+	return m.register(name, help, "histogram", histogram).(*Histogram)
+}
+
+// WriteTo writes all metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.RLock()
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = m.metrics[name]
+	}
+	m.lock.RUnlock()
+
+	buf := bufio.NewWriter(w)
+	writer := &countingWriter{w: buf}
+	for i, metric := range metrics {
+		metric.write(writer, names[i])
+	}
+
+	err := buf.Flush()
+	return writer.n, err
+}
+
+// ServeHTTP implements http.Handler.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.n += int64(n)
+	return n, err
+}
+
+type metricDesc struct {
+	help   string
+	kind   string
+	metric metric
+	owner  Plugin
+}
+
+func (desc *metricDesc) write(w io.Writer, name string) {
+	if len(desc.help) > 0 {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(desc.help))
+	}
+
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, desc.kind)
+	desc.metric.write(w, name)
+}
+
+// Counter is a metric that can only increase.
+type Counter struct {
+	value uint64
+}
+
+// Inc increments the counter by 1.
+func (counter *Counter) Inc() {
+	atomic.AddUint64(&counter.value, 1)
+}
+
+// Add increments the counter by n.
+func (counter *Counter) Add(n uint64) {
+	atomic.AddUint64(&counter.value, n)
+}
+
+// Value returns the current value of the counter.
+func (counter *Counter) Value() uint64 {
+	return atomic.LoadUint64(&counter.value)
+}
+
+func (counter *Counter) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, counter.Value())
+}
+
+// CounterVec is a set of counters that are partitioned by labels.
+type CounterVec struct {
+	labels   []string
+	lock     sync.RWMutex
+	counters map[string]*labeledCounter
+}
+
+type labeledCounter struct {
+	Counter
+	values []string
+}
+
+// WithLabels returns the counter for the specified label values, which must
+// be given in the order of the labels of vec.
+func (vec *CounterVec) WithLabels(values ...string) *Counter {
+	if len(values) != len(vec.labels) {
+		panic("metrics: wrong number of label values")
+	}
+
+	key := strings.Join(values, "\xff")
+	vec.lock.RLock()
+	counter := vec.counters[key]
+	vec.lock.RUnlock()
+	if counter != nil {
+		return &counter.Counter
+	}
+
+	vec.lock.Lock()
+	defer vec.lock.Unlock()
+	if counter = vec.counters[key]; counter == nil {
+		counter = &labeledCounter{values: append([]string(nil), values...)}
+		vec.counters[key] = counter
+	}
+
+	return &counter.Counter
+}
+
+func (vec *CounterVec) write(w io.Writer, name string) {
+	vec.lock.RLock()
+	keys := make([]string, 0, len(vec.counters))
+	for key := range vec.counters {
+		keys = append(keys, key)
+	}
+	vec.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		vec.lock.RLock()
+		counter := vec.counters[key]
+		vec.lock.RUnlock()
+
+		fmt.Fprintf(w, "%s%s %d\n", name, formatLabels(vec.labels, counter.values), counter.Value())
+	}
+}
+
+type gaugeFunc func() float64
+
+func (fn gaugeFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(fn()))
+}
+
+type gaugeVecFunc struct {
+	labels []string
+	fn     func(report func(value float64, values ...string))
+}
+
+func (gauge *gaugeVecFunc) write(w io.Writer, name string) {
+	gauge.fn(func(value float64, values ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(gauge.labels, values), formatFloat(value))
+	})
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe adds an observation to the histogram.
+func (histogram *Histogram) Observe(value float64) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			histogram.counts[i]++
+		}
+	}
+
+	histogram.count++
+	histogram.sum += value
+}
+
+func (histogram *Histogram) write(w io.Writer, name string) {
+	histogram.lock.Lock()
+	counts := append([]uint64(nil), histogram.counts...)
+	count, sum := histogram.count, histogram.sum
+	histogram.lock.Unlock()
+
+	for i, bound := range histogram.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), counts[i])
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func formatLabels(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+
+		pairs[i] = label + "=\"" + escapeLabel(value) + "\""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpReplacer  = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	labelReplacer = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelReplacer.Replace(value)
+}
+
+// serverMetrics holds the metrics collected by the server.
+type serverMetrics struct {
+	tickDuration *Histogram
+	saveDuration *Histogram
+	packets      [2][256]packetCounters
+	mapDownloads *Counter
+	heartbeats   *CounterVec
+}
+
+func newServerMetrics(server *Server) serverMetrics {
+	m := server.metrics
+	m.NewGaugeFunc("mcc_players", "Number of connected players.", func() float64 {
+		return float64(atomic.LoadInt32(&server.playerCount))
+	})
+
+	m.NewGaugeFunc("mcc_levels", "Number of loaded levels.", func() float64 {
+		server.levelsLock.RLock()
+		defer server.levelsLock.RUnlock()
+		return float64(len(server.levels))
+	})
+
+	m.NewGaugeFunc("mcc_entities", "Number of entities.", func() float64 {
+		server.entitiesLock.RLock()
+		defer server.entitiesLock.RUnlock()
+		return float64(len(server.entities))
+	})
+
+	m.NewGaugeVecFunc("mcc_physics_queue_length", "Number of pending block updates.",
+		[]string{"level", "simulator"}, func(report func(float64, ...string)) {
+			server.ForEachLevel(func(level *Level) {
+				level.simulatorsLock.RLock()
+				for _, simulator := range level.simulators {
+					if queue, ok := simulator.(interface{ queueLength() int }); ok {
+						report(float64(queue.queueLength()), level.Name, simulatorName(simulator))
+					}
+				}
+				level.simulatorsLock.RUnlock()
+			})
+		})
+
+	stats := serverMetrics{
+		tickDuration: m.NewHistogram("mcc_tick_duration_seconds",
+			"Duration of the server ticks.", DefaultBuckets),
+		saveDuration: m.NewHistogram("mcc_level_save_duration_seconds",
+			"Duration of the level saves.", DefaultBuckets),
+		mapDownloads: m.NewCounter("mcc_map_downloads_total",
+			"Number of levels sent to players."),
+		heartbeats: m.NewCounterVec("mcc_heartbeats_total",
+			"Number of heartbeats sent to the server lists.", "url", "result"),
+	}
+
+	packets := m.NewCounterVec("mcc_packets_total",
+		"Number of packets sent and received.", "direction", "type")
+	packetBytes := m.NewCounterVec("mcc_packet_bytes_total",
+		"Number of packet bytes sent and received.", "direction", "type")
+	directions := []string{proto.ServerBound: "in", proto.ClientBound: "out"}
+	for direction, label := range directions {
+		for id := range stats.packets[direction] {
+			packet := proto.NewPacket(byte(id), proto.Direction(direction))
+			if packet == nil {
+				continue
+			}
+
+			name := reflect.Indirect(reflect.ValueOf(packet)).Type().Name()
+			stats.packets[direction][id] = packetCounters{
+				packets.WithLabels(label, name),
+				packetBytes.WithLabels(label, name),
+			}
+		}
+	}
+
+	return stats
+}
+
+// packetCounters are the counters of a packet type.
+type packetCounters struct {
+	count *Counter
+	bytes *Counter
+}
+
+// Metrics returns the metrics registry of the server. Plugins can register
+// their own metrics in it.
+func (server *Server) Metrics() *Metrics {
+	return server.metrics
+}
+
+// Metrics returns the metrics registry of the server. The metrics that are
+// registered through it are owned by the plugin of the registrar.
+func (registrar *Registrar) Metrics() *Metrics {
+	return &Metrics{registrar.server.metrics.registry, registrar.plugin}
+}
+
+// countPacket records a packet of the specified size.
+func (m *serverMetrics) countPacket(direction proto.Direction, id byte, size int) {
+	if counters := m.packets[direction][id]; counters.count != nil {
+		counters.count.Inc()
+		counters.bytes.Add(uint64(size))
+	}
+}
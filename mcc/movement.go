@@ -0,0 +1,329 @@
+package mcc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	MoveViolationSpeed   = 0
+	MoveViolationNoClip  = 1
+	MoveViolationAirTime = 2
+)
+
+const (
+	playerEyeOffset = 51.0 / 32
+	playerHalfWidth = 0.3
+	playerHeight    = 1.8
+
+	moveMargin        = 0.1
+	moveSpeedLimit    = 8.0
+	moveSpeedWindow   = 0.5
+	moveSpeedSlack    = 1.0
+	moveGravity       = 32.0
+	moveJumpHeight    = 1.25
+	moveJumpSlack     = 0.5
+	moveAirTimeSlack  = time.Second
+	moveRollbackLimit = time.Second
+)
+
+// box is an axis-aligned bounding box with real coordinates.
+type box struct {
+	minX, minY, minZ float64
+	maxX, maxY, maxZ float64
+}
+
+func (b box) intersects(other box) bool {
+	return b.minX < other.maxX && b.maxX > other.minX &&
+		b.minY < other.maxY && b.maxY > other.minY &&
+		b.minZ < other.maxZ && b.maxZ > other.minZ
+}
+
+// playerBox returns the bounding box of a player standing at location, shrunk
+// by a small margin to tolerate rounding errors.
+func playerBox(location Location) box {
+	feet := location.Y - playerEyeOffset
+	return box{
+		location.X - playerHalfWidth + moveMargin,
+		feet + moveMargin,
+		location.Z - playerHalfWidth + moveMargin,
+		location.X + playerHalfWidth - moveMargin,
+		feet + playerHeight - moveMargin,
+		location.Z + playerHalfWidth - moveMargin,
+	}
+}
+
+// blockAt returns the block at the specified coordinates. Unlike GetBlock,
+// it reports the side and edge blocks around the level, which clients treat
+// as solid ground and liquid respectively.
+func (level *Level) blockAt(x, y, z int) BlockID {
+	if level.InBounds(x, y, z) {
+		return level.block(level.Index(x, y, z))
+	}
+
+	env := &level.EnvConfig
+	if y < env.EdgeHeight+env.SideOffset {
+		return env.SideBlock
+	} else if y < env.EdgeHeight {
+		return env.EdgeBlock
+	}
+
+	return BlockAir
+}
+
+// collideMode returns the collision behaviour of block.
+func (level *Level) collideMode(block BlockID) byte {
+	if def := level.blockDef(block); def != nil {
+		return def.CollideMode
+	}
+
+	switch block {
+	case BlockAir, BlockSapling, BlockDandelion, BlockRose,
+		BlockBrownShroom, BlockRedShroom, BlockSnow, BlockFire:
+		return CollideModeWalk
+	case BlockActiveWater, BlockWater:
+		return CollideModeWater
+	case BlockActiveLava, BlockLava:
+		return CollideModeLava
+	case BlockRope:
+		return CollideModeClimb
+	case BlockIce:
+		return CollideModeIce
+	default:
+		return CollideModeSolid
+	}
+}
+
+// blockBox returns the bounding box of block at the specified coordinates.
+func (level *Level) blockBox(block BlockID, x, y, z int) box {
+	fx, fy, fz := float64(x), float64(y), float64(z)
+	result := box{fx, fy, fz, fx + 1, fy + 1, fz + 1}
+	if def := level.blockDef(block); def != nil {
+		aabb := def.AABB
+		if aabb.Max != (Vector3{}) {
+			result = box{
+				fx + float64(aabb.Min.X)/16,
+				fy + float64(aabb.Min.Y)/16,
+				fz + float64(aabb.Min.Z)/16,
+				fx + float64(aabb.Max.X)/16,
+				fy + float64(aabb.Max.Y)/16,
+				fz + float64(aabb.Max.Z)/16,
+			}
+		} else if def.Shape != BlockShapeSprite {
+			result.maxY = fy + float64(def.Shape)/16
+		}
+
+		return result
+	}
+
+	switch block {
+	case BlockSlab, BlockCobblestoneSlab:
+		result.maxY = fy + 0.5
+	case BlockSnow:
+		result.maxY = fy + 0.125
+	}
+
+	return result
+}
+
+// touches reports whether b intersects any block whose collide mode is
+// accepted by fn.
+func (level *Level) touches(b box, fn func(mode byte) bool) bool {
+	x0, x1 := int(math.Floor(b.minX)), int(math.Floor(b.maxX))
+	y0, y1 := int(math.Floor(b.minY)), int(math.Floor(b.maxY))
+	z0, z1 := int(math.Floor(b.minZ)), int(math.Floor(b.maxZ))
+	for y := y0; y <= y1; y++ {
+		for z := z0; z <= z1; z++ {
+			for x := x0; x <= x1; x++ {
+				block := level.blockAt(x, y, z)
+				if fn(level.collideMode(block)) &&
+					level.blockBox(block, x, y, z).intersects(b) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func isSolid(mode byte) bool {
+	return mode == CollideModeSolid || mode == CollideModeIce ||
+		mode == CollideModeSlipperyIce
+}
+
+func isSupporting(mode byte) bool {
+	return mode != CollideModeWalk
+}
+
+// movementValidator checks the movement reported by a client against the
+// HackConfig of its level.
+type movementValidator struct {
+	lock sync.Mutex
+
+	lastUpdate time.Time
+	budget     float64
+
+	grounded bool
+	groundY  float64
+	airStart time.Time
+
+	pending      bool
+	pendingSince time.Time
+	target       Location
+}
+
+// reset discards the movement history and waits for the client to confirm
+// that it has moved to location.
+func (validator *movementValidator) reset(location Location) {
+	validator.lock.Lock()
+	defer validator.lock.Unlock()
+
+	now := time.Now()
+	validator.budget = moveSpeedLimit * moveSpeedWindow
+	validator.grounded = true
+	validator.groundY = location.Y - playerEyeOffset
+	validator.airStart = now
+	validator.setPending(location, now)
+}
+
+// rollback waits for the client to confirm that it has moved back to
+// location, keeping the movement history.
+func (validator *movementValidator) rollback(location Location) {
+	validator.lock.Lock()
+	defer validator.lock.Unlock()
+
+	validator.setPending(location, time.Now())
+}
+
+func (validator *movementValidator) setPending(location Location, now time.Time) {
+	validator.lastUpdate = now
+	validator.pending = true
+	validator.pendingSince = now
+	validator.target = location
+}
+
+// confirm reports whether the client is not waiting for a teleport anymore.
+// If resend is set, the teleport should be sent again.
+func (validator *movementValidator) confirm(to Location) (confirmed, resend bool) {
+	validator.lock.Lock()
+	defer validator.lock.Unlock()
+
+	if !validator.pending {
+		return true, false
+	}
+
+	now := time.Now()
+	dx, dy, dz := to.X-validator.target.X, to.Y-validator.target.Y, to.Z-validator.target.Z
+	if dx*dx+dy*dy+dz*dz > 1 {
+		if now.Sub(validator.pendingSince) > moveRollbackLimit {
+			validator.pendingSince = now
+			return false, true
+		}
+
+		return false, false
+	}
+
+	validator.pending = false
+	validator.lastUpdate = now
+	return true, false
+}
+
+// check validates a movement from from to to on level, and returns the
+// violation detected, or -1.
+func (validator *movementValidator) check(level *Level, from, to Location) int {
+	validator.lock.Lock()
+	defer validator.lock.Unlock()
+
+	violation := -1
+	now := time.Now()
+	hacks := &level.HackConfig
+	if !hacks.Speeding {
+		speed := moveSpeedLimit
+		under := level.blockAt(int(math.Floor(from.X)),
+			int(math.Floor(from.Y-playerEyeOffset-moveMargin)), int(math.Floor(from.Z)))
+		if def := level.blockDef(under); def != nil && def.Speed > 1 {
+			speed *= def.Speed
+		}
+
+		elapsed := now.Sub(validator.lastUpdate).Seconds()
+		validator.budget = math.Min(validator.budget+speed*elapsed, speed*moveSpeedWindow)
+
+		dx, dz := to.X-from.X, to.Z-from.Z
+		validator.budget -= math.Sqrt(dx*dx + dz*dz)
+		if validator.budget < -moveSpeedSlack {
+			violation = MoveViolationSpeed
+		}
+	}
+	validator.lastUpdate = now
+
+	body := playerBox(to)
+	if violation < 0 && !hacks.NoClip && level.touches(body, isSolid) {
+		violation = MoveViolationNoClip
+	}
+
+	feet := to.Y - playerEyeOffset
+	feetBox := body
+	feetBox.minY, feetBox.maxY = feet-moveMargin, feet+moveMargin
+	if level.touches(feetBox, isSupporting) || level.touches(body, isSupporting) {
+		validator.grounded = true
+		validator.groundY = feet
+	} else if validator.grounded {
+		validator.grounded = false
+		validator.airStart = now
+	}
+
+	if violation < 0 && !hacks.Flying && !validator.grounded {
+		jumpHeight := moveJumpHeight
+		if hacks.JumpHeight >= 0 {
+			jumpHeight = hacks.JumpHeight
+		}
+
+		airTime := time.Duration(2*math.Sqrt(2*jumpHeight/moveGravity)*float64(time.Second)) + moveAirTimeSlack
+		if feet > validator.groundY+jumpHeight+moveJumpSlack ||
+			(now.Sub(validator.airStart) > airTime && feet >= validator.groundY) {
+			violation = MoveViolationAirTime
+		}
+	}
+
+	if violation >= 0 {
+		validator.budget = 0
+	}
+
+	return violation
+}
+
+// validateMove checks a movement reported by the client and reports whether
+// it should be applied. Rejected movements are rolled back.
+func (player *Player) validateMove(location Location) bool {
+	level := player.Level()
+	if level == nil || !player.server.Config().CheckMovement {
+		return true
+	}
+
+	confirmed, resend := player.movement.confirm(location)
+	if !confirmed {
+		if resend {
+			player.sendTeleport(player.Entity)
+		}
+
+		return false
+	}
+
+	from := player.Location()
+	violation := player.movement.check(level, from, location)
+	if violation < 0 {
+		return true
+	}
+
+	event := EventPlayerMoveViolation{player, from, location, violation, false}
+	player.server.FireEvent(EventTypePlayerMoveViolation, &event)
+	if event.Cancel {
+		return true
+	}
+
+	player.movement.rollback(from)
+	player.sendTeleport(player.Entity)
+	return false
+}
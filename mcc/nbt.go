@@ -41,6 +41,24 @@ func NbtUnmarshal(r io.Reader, v interface{}) error {
 	return err
 }
 
+// parseNbtTag splits a struct field tag into a name and an option.
+func parseNbtTag(tag string) (name string, opts string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+
+	return tag, ""
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+
+	return false
+}
+
 type nbtEncoder struct {
 	w io.Writer
 }
@@ -182,11 +200,15 @@ func (nbt *nbtEncoder) writeCompound(v reflect.Value) error {
 				continue
 			}
 
-			fname := tag
+			fname, opts := parseNbtTag(tag)
 			if fname == "" {
 				fname = field.Name
 			}
 
+			if opts == "omitempty" && isEmptyValue(v.Field(i)) {
+				continue
+			}
+
 			if err := nbt.writeTag(fname, v.Field(i)); err != nil {
 				return err
 			}
@@ -471,7 +493,7 @@ func (nbt *nbtDecoder) readTag(v reflect.Value) (tagType byte, err error) {
 		for i := 0; i < v.NumField(); i++ {
 			field := v.Type().Field(i)
 			fname := strings.ToLower(field.Name)
-			tag := strings.ToLower(field.Tag.Get("nbt"))
+			tag, _ := parseNbtTag(strings.ToLower(field.Tag.Get("nbt")))
 			if tag == key || (tag == "" && fname == key) {
 				target = v.Field(i)
 				break
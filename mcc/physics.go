@@ -29,6 +29,12 @@ func (queue *blockUpdateQueue) add(index int, delay int) {
 	}
 }
 
+func (queue *blockUpdateQueue) length() int {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return len(queue.updates)
+}
+
 func (queue *blockUpdateQueue) tick() (updates []int) {
 	i := 0
 	queue.lock.Lock()
@@ -54,7 +60,7 @@ type WaterSimulator struct {
 }
 
 // Update implements Simulator.
-func (simulator *WaterSimulator) Update(block, old byte, index int) {
+func (simulator *WaterSimulator) Update(block, old BlockID, index int) {
 	if block == BlockActiveWater || (block == BlockWater && block == old) {
 		simulator.queue.add(index, 5)
 	} else {
@@ -74,11 +80,15 @@ func (simulator *WaterSimulator) Update(block, old byte, index int) {
 	}
 }
 
+func (simulator *WaterSimulator) queueLength() int {
+	return simulator.queue.length()
+}
+
 // Tick implements Simulator.
 func (simulator *WaterSimulator) Tick() {
 	level := simulator.Level
 	for _, index := range simulator.queue.tick() {
-		block := level.Blocks[index]
+		block := level.block(index)
 		if block != BlockActiveWater && block != BlockWater {
 			return
 		}
@@ -158,7 +168,7 @@ func (simulator *WaterSimulator) breakSponge(x, y, z int) {
 		for zz := max(z-3, 0); zz <= min(z+3, level.Length-1); zz++ {
 			for xx := max(x-3, 0); xx <= min(x+3, level.Width-1); xx++ {
 				index := level.Index(xx, yy, zz)
-				block := level.Blocks[index]
+				block := level.block(index)
 				simulator.Update(block, block, index)
 			}
 		}
@@ -173,17 +183,21 @@ type LavaSimulator struct {
 }
 
 // Update implements Simulator.
-func (simulator *LavaSimulator) Update(block, old byte, index int) {
+func (simulator *LavaSimulator) Update(block, old BlockID, index int) {
 	if block == BlockActiveLava || (block == BlockLava && block == old) {
 		simulator.queue.add(index, 30)
 	}
 }
 
+func (simulator *LavaSimulator) queueLength() int {
+	return simulator.queue.length()
+}
+
 // Tick implements Simulator.
 func (simulator *LavaSimulator) Tick() {
 	level := simulator.Level
 	for _, index := range simulator.queue.tick() {
-		block := level.Blocks[index]
+		block := level.block(index)
 		if block != BlockActiveLava && block != BlockLava {
 			return
 		}
@@ -225,7 +239,7 @@ type SandSimulator struct {
 }
 
 // Update implements Simulator.
-func (simulator *SandSimulator) Update(block, old byte, index int) {
+func (simulator *SandSimulator) Update(block, old BlockID, index int) {
 	if block != BlockSand && block != BlockGravel {
 		return
 	}
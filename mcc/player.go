@@ -2,17 +2,17 @@ package mcc
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
 	"crypto/md5"
-	"encoding/binary"
+	"encoding/hex"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
 )
 
 const (
@@ -21,6 +21,14 @@ const (
 	stateGame   = 2
 )
 
+const (
+	sendQueueSize    = 4096
+	maxWriteSize     = 64 * 1024
+	flushTimeout     = 5 * time.Second
+	pingInterval     = 2 * time.Second
+	maxPendingBlocks = 4096
+)
+
 // Player represents a game client.
 type Player struct {
 	*Entity
@@ -28,27 +36,58 @@ type Player struct {
 	Nickname string
 	Rank     *Rank
 
-	conn  net.Conn
-	state uint32
+	// ViewDistance is the distance up to which the player can see other
+	// entities. If it is zero, the view distance of the level is used.
+	ViewDistance float64
 
+	conn     net.Conn
+	listener *ListenerConfig
+	state    uint32
+
+	sendQueue  chan []byte
+	sendDone   chan struct{}
+	overflowed uint32
+
+	codec         proto.Codec
 	cpe           [CpeCount]bool
 	remExtensions int
 	message       string
-	maxBlockID    byte
+	maxBlockID    BlockID
 	cpeBlockLevel byte
-	heldBlock     byte
+	heldBlock     BlockID
 
-	pingTicker *time.Ticker
+	pingTask   *Task
 	pingBuffer pingBuffer
+
+	viewLock   sync.Mutex
+	entityIDs  entityIDs
+	nameIDs    entityIDs
+	visibility map[*Entity]int
+	distant    map[*Entity]bool
+	viewTicks  int
+
+	levelLock    sync.Mutex
+	levelGen     uint32
+	pendingLevel *Level
+	loading      *Level
+	levelReady   chan struct{}
+
+	pendingIndices []int32
+	pendingBlocks  []BlockID
+
+	movement movementValidator
 }
 
 // NewPlayer returns a new Player.
 func NewPlayer(conn net.Conn, server *Server) *Player {
 	return &Player{
-		Entity:    NewEntity("", server),
-		conn:      conn,
-		state:     stateClosed,
-		heldBlock: BlockAir,
+		Entity:     NewEntity("", server),
+		conn:       conn,
+		state:      stateClosed,
+		sendQueue:  make(chan []byte, sendQueueSize),
+		sendDone:   make(chan struct{}),
+		levelReady: make(chan struct{}, 1),
+		heldBlock:  BlockAir,
 	}
 }
 
@@ -62,11 +101,21 @@ func (player *Player) CanExecute(command *Command) bool {
 	return rank.CanExecute(command)
 }
 
+// ProtocolVersion returns the protocol version of the client.
+func (player *Player) ProtocolVersion() byte {
+	return player.codec.Version
+}
+
 // HasExtension reports whether the player has the specified CPE extension.
 func (player *Player) HasExtension(extension int) bool {
 	return player.cpe[extension]
 }
 
+// loadState returns the connection state of the player.
+func (player *Player) loadState() uint32 {
+	return atomic.LoadUint32(&player.state)
+}
+
 // RemoteAddr returns the remote network address as a string.
 func (player *Player) RemoteAddr() string {
 	addr := player.conn.RemoteAddr()
@@ -75,18 +124,20 @@ func (player *Player) RemoteAddr() string {
 }
 
 // Disconnect closes the remote connection.
+// Packets that have already been queued are flushed before the connection is
+// closed.
 func (player *Player) Disconnect() {
-	if player.state == stateClosed {
+	state := atomic.SwapUint32(&player.state, stateClosed)
+	if state == stateClosed {
 		return
 	}
 
-	if player.pingTicker != nil {
-		player.pingTicker.Stop()
+	if player.pingTask != nil {
+		player.pingTask.Cancel()
 	}
 
-	loggedIn := player.state == stateGame
-	atomic.StoreUint32(&player.state, stateClosed)
-	player.conn.Close()
+	loggedIn := state == stateGame
+	close(player.sendDone)
 
 	if loggedIn {
 		event := EventPlayerQuit{player}
@@ -100,11 +151,22 @@ func (player *Player) Disconnect() {
 	}
 }
 
-// Kick kicks and disconnects the player.
+// Kick kicks and disconnects the player, unless the kick is cancelled by an
+// EventPlayerKick handler.
 func (player *Player) Kick(reason string) {
-	var packet packet
-	packet.kick(reason)
-	player.sendPacket(packet)
+	player.kick(reason, false)
+}
+
+// kick fires EventPlayerKick and kicks the player. If force is set, the kick
+// cannot be cancelled.
+func (player *Player) kick(reason string, force bool) {
+	event := EventPlayerKick{player, reason, force, false}
+	player.server.FireEvent(EventTypePlayerKick, &event)
+	if event.Cancel && !force {
+		return
+	}
+
+	player.sendPacket(&proto.Kick{Reason: event.Reason})
 
 	player.Disconnect()
 }
@@ -112,47 +174,44 @@ func (player *Player) Kick(reason string) {
 // CanReach reports whether the player can reach the block at the specified
 // coordinates.
 func (player *Player) CanReach(x, y, z int) bool {
-	loc := player.location
+	loc := player.Location()
 	dx := math.Min(math.Abs(loc.X-float64(x)), math.Abs(loc.X-float64(x+1)))
 	dy := math.Min(math.Abs(loc.Y-float64(y)), math.Abs(loc.Y-float64(y+1)))
 	dz := math.Min(math.Abs(loc.Z-float64(z)), math.Abs(loc.Z-float64(z+1)))
-	dist := player.level.HackConfig.ReachDistance
+	dist := player.Level().HackConfig.ReachDistance
 	return dx*dx+dy*dy+dz*dz <= dist*dist
 }
 
 // HeldBlock returns the block that the player is holding.
 // If the player does not support the HeldBlock extension, the function returns
 // BlockAir.
-func (player *Player) HeldBlock() byte {
+func (player *Player) HeldBlock() BlockID {
 	return player.heldBlock
 }
 
 // SetHeldBlock changes the block that the player is holding.
 // lock controls whether the player can change the held block.
-func (player *Player) SetHeldBlock(block byte, lock bool) {
-	level := player.level
-	if player.state == stateGame && player.cpe[CpeHeldBlock] && level != nil {
-		var packet packet
-		packet.holdThis(player.convertBlock(block, level), lock)
-		player.sendPacket(packet)
+func (player *Player) SetHeldBlock(block BlockID, lock bool) {
+	level := player.Level()
+	if player.loadState() == stateGame && player.cpe[CpeHeldBlock] && level != nil {
+		player.sendPacket(&proto.HoldThis{
+			Block:         uint16(player.convertBlock(block, level)),
+			PreventChange: lock,
+		})
 	}
 }
 
 // SetSelection marks a cuboid selection.
 func (player *Player) SetSelection(id byte, label string, box AABB, color RGBA) {
-	if player.state == stateGame && player.cpe[CpeSelectionCuboid] {
-		var packet packet
-		packet.makeSelection(id, label, box, color)
-		player.sendPacket(packet)
+	if player.loadState() == stateGame && player.cpe[CpeSelectionCuboid] {
+		player.sendPacket(makeSelectionPacket(id, label, box, color))
 	}
 }
 
 // ResetSelection resets the selection with the specified ID.
 func (player *Player) ResetSelection(id byte) {
-	if player.state == stateGame && player.cpe[CpeSelectionCuboid] {
-		var packet packet
-		packet.removeSelection(id)
-		player.sendPacket(packet)
+	if player.loadState() == stateGame && player.cpe[CpeSelectionCuboid] {
+		player.sendPacket(&proto.RemoveSelection{SelectionID: id})
 	}
 }
 
@@ -202,6 +261,13 @@ func (player *Player) SendMessage(message string) {
 
 // SendMessageExt sends a message with the specified type to the player.
 func (player *Player) SendMessageExt(msgType int, message string) {
+	event := EventMessageSend{player, msgType, message, false}
+	player.server.FireEvent(EventTypeMessageSend, &event)
+	if event.Cancel {
+		return
+	}
+
+	msgType, message = event.Type, event.Message
 	if msgType != MessageChat && !player.cpe[CpeMessageTypes] {
 		if msgType == MessageAnnouncement {
 			msgType = MessageChat
@@ -210,13 +276,13 @@ func (player *Player) SendMessageExt(msgType int, message string) {
 		}
 	}
 
-	var packet packet
+	var packets []proto.Packet
 	message = player.convertMessage(message)
 	for _, line := range WordWrap(message, 64) {
-		packet.message(msgType, line)
+		packets = append(packets, &proto.Message{Type: byte(msgType), Message: line})
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 // SetSpawn sets the spawn location of the player to the current player
@@ -225,35 +291,124 @@ func (player *Player) SetSpawn() {
 	player.sendSpawn(player.Entity)
 }
 
-func (player *Player) sendPacket(packet packet) {
-	if player.state == stateClosed {
+// encode encodes packets according to the extensions supported by the player.
+func (player *Player) encode(packets []proto.Packet) []byte {
+	var buf []byte
+	stats := &player.server.stats
+	for _, packet := range packets {
+		size := len(buf)
+		buf = player.codec.Encode(buf, packet)
+		stats.countPacket(proto.ClientBound, packet.ID(), len(buf)-size)
+	}
+
+	return buf
+}
+
+// sendPacket queues packets to be sent to the player. If the send queue is
+// full, the player is kicked.
+func (player *Player) sendPacket(packets ...proto.Packet) {
+	if player.loadState() == stateClosed || len(packets) == 0 {
 		return
 	}
 
-	_, err := packet.WriteTo(player.conn)
-	if err == io.EOF {
-		player.Disconnect()
+	select {
+	case player.sendQueue <- player.encode(packets):
+	default:
+		if atomic.CompareAndSwapUint32(&player.overflowed, 0, 1) {
+			log.Printf("Player %s: send queue overflow\n", player.name)
+			// The caller may hold locks that the kick needs.
+			go player.overflow()
+		}
 	}
 }
 
-func (player *Player) convertBlock(block byte, level *Level) byte {
-	if !player.cpe[CpeBlockDefinitions] {
-		if level.BlockDefs != nil {
-			if def := level.BlockDefs[block]; def != nil {
-				block = def.Fallback
-			}
+// overflow kicks the player after the send queue has overflowed. The queued
+// packets are dropped to make room for the kick packet, and the write
+// deadline closes the connection if the client has stopped reading.
+func (player *Player) overflow() {
+	player.conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+	for len(player.sendQueue) > 0 {
+		select {
+		case <-player.sendQueue:
+		default:
+		}
+	}
+
+	player.kick("Send queue overflow", true)
+}
+
+// sendPacketWait is like sendPacket, but it waits for space in the send queue
+// instead of closing the connection.
+func (player *Player) sendPacketWait(packets ...proto.Packet) {
+	if player.loadState() == stateClosed || len(packets) == 0 {
+		return
+	}
+
+	select {
+	case player.sendQueue <- player.encode(packets):
+	case <-player.sendDone:
+	}
+}
+
+// drainQueue appends queued packets to buf until the queue is empty or buf
+// reaches maxWriteSize.
+func (player *Player) drainQueue(buf *bytes.Buffer) {
+	for buf.Len() < maxWriteSize {
+		select {
+		case data := <-player.sendQueue:
+			buf.Write(data)
+		default:
+			return
 		}
+	}
+}
+
+func (player *Player) writeLoop() {
+	var buf bytes.Buffer
+	for {
+		select {
+		case data := <-player.sendQueue:
+			buf.Write(data)
+			player.drainQueue(&buf)
+			if _, err := player.conn.Write(buf.Bytes()); err != nil {
+				player.conn.Close()
+				return
+			}
+
+			buf.Reset()
+
+		case <-player.sendDone:
+			player.conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+			for {
+				player.drainQueue(&buf)
+				if buf.Len() == 0 {
+					break
+				}
+
+				if _, err := player.conn.Write(buf.Bytes()); err != nil {
+					break
+				}
+
+				buf.Reset()
+			}
 
-		if block > BlockMaxCPE {
-			return BlockAir
+			player.conn.Close()
+			return
 		}
 	}
+}
 
-	if player.cpeBlockLevel < 1 {
-		return FallbackBlock(block)
+func (player *Player) blockProfile() blockProfile {
+	return blockProfile{
+		legacy:       player.codec.Version < ProtocolVersion7,
+		customBlocks: player.cpeBlockLevel >= 1,
+		blockDefs:    player.cpe[CpeBlockDefinitions],
+		extBlocks:    player.cpe[CpeExtendedBlocks],
 	}
+}
 
-	return block
+func (player *Player) convertBlock(block BlockID, level *Level) BlockID {
+	return level.convertBlock(block, player.blockProfile())
 }
 
 func (player *Player) sendMOTD(level *Level) {
@@ -266,53 +421,47 @@ func (player *Player) sendMOTD(level *Level) {
 
 	motd := level.MOTD
 	if len(motd) == 0 {
-		motd = player.server.Config.MOTD
+		motd = player.server.Config().MOTD
 	}
 
-	var packet packet
-	packet.motd(player, motd, op)
-	player.sendPacket(packet)
+	player.sendPacket(&proto.Identification{
+		Version:  player.codec.Version,
+		Name:     player.server.Config().Name,
+		MOTD:     motd,
+		UserType: userType(op),
+	})
 }
 
-func (player *Player) sendLevel(level *Level) {
-	if player.state != stateGame {
-		return
+// sendLevel sends level to the player. It returns false if the player
+// disconnects or changes level again before the level is sent.
+func (player *Player) sendLevel(level *Level, gen uint32) bool {
+	if player.loadState() != stateGame {
+		return false
 	}
 
 	player.sendMOTD(level)
 
-	var conv [BlockMax]byte
-	for i := byte(0); i < BlockMax; i++ {
-		conv[i] = player.convertBlock(i, level)
+	snapshot := level.snapshot(snapshotKey{player.blockProfile(), player.cpe[CpeFastMap]})
+	select {
+	case <-snapshot.done:
+	case <-player.sendDone:
+		return false
 	}
 
-	stream := levelStream{player: player}
-	stream.reset()
-	if player.cpe[CpeFastMap] {
-		var packet packet
-		packet.levelInitializeExt(level.Size())
-		player.sendPacket(packet)
-
-		writer, _ := flate.NewWriter(&stream, -1)
-		for i, block := range level.Blocks {
-			stream.percent = byte(i * 100 / len(level.Blocks))
-			writer.Write([]byte{conv[block]})
-		}
-		writer.Close()
-	} else {
-		var packet packet
-		packet.levelInitialize()
-		player.sendPacket(packet)
-
-		writer := gzip.NewWriter(&stream)
-		binary.Write(writer, binary.BigEndian, int32(level.Size()))
-		for i, block := range level.Blocks {
-			stream.percent = byte(i * 100 / len(level.Blocks))
-			writer.Write([]byte{conv[block]})
+	player.server.stats.mapDownloads.Inc()
+	player.sendPacket(&proto.LevelInitialize{Size: int32(level.Size())})
+	data := snapshot.data
+	for offset := 0; offset < len(data); offset += proto.LevelChunkSize {
+		if player.levelChanged(gen) {
+			return false
 		}
-		writer.Close()
+
+		end := min(offset+proto.LevelChunkSize, len(data))
+		player.sendPacketWait(&proto.LevelDataChunk{
+			Data:    data[offset:end],
+			Percent: byte(offset * 100 / len(data)),
+		})
 	}
-	stream.Close()
 
 	player.sendBlockDefinitions(level)
 	player.sendInventory(level)
@@ -321,26 +470,69 @@ func (player *Player) sendLevel(level *Level) {
 
 	player.SendPermissions()
 
-	var packet packet
-	packet.levelFinalize(level.Width, level.Height, level.Length)
-	player.sendPacket(packet)
+	player.sendPacket(&proto.LevelFinalize{
+		X: int16(level.Width),
+		Y: int16(level.Height),
+		Z: int16(level.Length),
+	})
+
+	return player.loadState() == stateGame
+}
+
+// FindEntityByID returns the entity with the specified client-side ID.
+func (player *Player) FindEntityByID(id byte) *Entity {
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+	return player.entityIDs.find(id)
+}
+
+// entityID returns the ID that the client uses to refer to entity.
+// It reports false if entity has not been spawned for the client.
+func (player *Player) entityID(entity *Entity) (byte, bool) {
+	if entity == player.Entity {
+		return proto.SelfID, true
+	}
+
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+	return player.entityIDs.get(entity)
+}
+
+// sendEntityPacket sends the packet returned by fn if entity has been spawned
+// for the client.
+func (player *Player) sendEntityPacket(entity *Entity, fn func(id byte) proto.Packet) {
+	if player.loadState() != stateGame {
+		return
+	}
+
+	if id, ok := player.entityID(entity); ok {
+		player.sendPacket(fn(id))
+	}
 }
 
 func (player *Player) sendSpawn(entity *Entity) {
-	if player.state != stateGame {
+	if player.loadState() != stateGame || player.isLoading() {
 		return
 	}
 
-	var packet packet
-	self := entity.id == player.id
-	extPos := player.cpe[CpeExtEntityPositions]
-	if player.cpe[CpeExtPlayerList] {
-		packet.extAddEntity2(entity, self, extPos)
-	} else {
-		packet.addEntity(entity, self, extPos)
+	player.viewLock.Lock()
+	id, ok := byte(proto.SelfID), true
+	if entity != player.Entity {
+		id, ok = player.entityIDs.add(entity)
 	}
 
-	player.sendPacket(packet)
+	if ok {
+		if player.cpe[CpeExtPlayerList] {
+			player.sendPacket(extAddEntity2Packet(entity, id))
+		} else {
+			player.sendPacket(addEntityPacket(entity, id))
+		}
+	}
+	player.viewLock.Unlock()
+
+	if !ok {
+		return
+	}
 
 	if entity.Model != ModelHumanoid {
 		player.sendChangeModel(entity)
@@ -350,22 +542,117 @@ func (player *Player) sendSpawn(entity *Entity) {
 }
 
 func (player *Player) sendDespawn(entity *Entity) {
-	if player.state == stateGame {
-		var packet packet
-		packet.removeEntity(entity, entity.id == player.id)
-		player.sendPacket(packet)
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+
+	id, ok := byte(proto.SelfID), true
+	if entity != player.Entity {
+		id, ok = player.entityIDs.remove(entity)
+		delete(player.distant, entity)
+	}
+
+	if ok && player.loadState() == stateGame {
+		player.sendPacket(&proto.RemoveEntity{EntityID: id})
 	}
 }
 
+// spawnLevel sends level to the player and spawns the entities in it. The
+// level is sent by levelLoop, so that the caller is not blocked by a slow
+// client. Until it has been sent, no entities are spawned for the player.
 func (player *Player) spawnLevel(level *Level) {
-	player.sendLevel(level)
+	player.movement.reset(player.Location())
+
+	player.levelLock.Lock()
+	player.requestLevel(level)
+	player.levelLock.Unlock()
+}
+
+// requestLevel starts a new transfer of level and discards the block changes
+// that were buffered for the previous one. levelLock must be held.
+func (player *Player) requestLevel(level *Level) {
+	player.levelGen++
+	player.pendingLevel = level
+	player.loading = level
+	player.pendingIndices = player.pendingIndices[:0]
+	player.pendingBlocks = player.pendingBlocks[:0]
+
+	select {
+	case player.levelReady <- struct{}{}:
+	default:
+	}
+}
+
+// levelLoop sends the levels requested by spawnLevel until the player
+// disconnects. If the player changes level during a transfer, only the last
+// level is sent.
+func (player *Player) levelLoop() {
+	for {
+		select {
+		case <-player.levelReady:
+			player.levelLock.Lock()
+			level, gen := player.pendingLevel, player.levelGen
+			player.pendingLevel = nil
+			player.levelLock.Unlock()
+
+			if level != nil {
+				player.transferLevel(level, gen)
+			}
+
+		case <-player.sendDone:
+			return
+		}
+	}
+}
+
+// transferLevel sends level and spawns the entities that the player can see.
+func (player *Player) transferLevel(level *Level, gen uint32) {
+	if !player.sendLevel(level, gen) {
+		return
+	}
+
+	player.levelLock.Lock()
+	current := player.levelGen == gen
+	if current {
+		player.loading = nil
+		player.writeBlockChanges(level, player.pendingIndices, player.pendingBlocks)
+		player.pendingIndices = player.pendingIndices[:0]
+		player.pendingBlocks = player.pendingBlocks[:0]
+	}
+	player.levelLock.Unlock()
+
+	if !current {
+		return
+	}
+
 	player.sendSpawn(player.Entity)
 	level.ForEachEntity(func(other *Entity) {
-		player.sendSpawn(other)
+		if player.canSee(other) {
+			player.sendSpawn(other)
+		}
 	})
 }
 
+// levelChanged reports whether the player has changed level since the
+// transfer with the specified generation was requested.
+func (player *Player) levelChanged(gen uint32) bool {
+	player.levelLock.Lock()
+	defer player.levelLock.Unlock()
+	return player.levelGen != gen
+}
+
+// isLoading reports whether a level is being sent to the player.
+func (player *Player) isLoading() bool {
+	player.levelLock.Lock()
+	defer player.levelLock.Unlock()
+	return player.loading != nil
+}
+
 func (player *Player) despawnLevel(level *Level) {
+	player.levelLock.Lock()
+	player.levelGen++
+	player.pendingLevel = nil
+	player.levelLock.Unlock()
+
 	player.resetBlockDefinitions(level)
 	player.resetInventory(level)
 	player.sendDespawn(player.Entity)
@@ -375,262 +662,376 @@ func (player *Player) despawnLevel(level *Level) {
 }
 
 func (player *Player) sendTeleport(entity *Entity) {
-	if player.state == stateGame {
-		var packet packet
-		extPos := player.cpe[CpeExtEntityPositions]
-		packet.teleport(entity, entity.id == player.id, extPos)
-		player.sendPacket(packet)
+	player.sendEntityPacket(entity, func(id byte) proto.Packet {
+		return teleportPacket(entity, id)
+	})
+}
+
+func (player *Player) sendBlockChange(x, y, z int, block BlockID) {
+	if level := player.Level(); level != nil {
+		index := int32(level.Index(x, y, z))
+		player.sendBlockChanges(level, []int32{index}, []BlockID{block})
 	}
 }
 
-func (player *Player) sendBlockChange(x, y, z int, block byte) {
-	level := player.level
-	if player.state == stateGame && level != nil {
-		var packet packet
-		packet.setBlock(x, y, z, player.convertBlock(block, level))
-		player.sendPacket(packet)
+// sendBlockChanges sends the changes of the blocks at indices of level. The
+// client discards the changes that it receives before the level, so they are
+// buffered while the level is being sent and replayed afterwards. If too many
+// changes are buffered, the level is sent again instead.
+func (player *Player) sendBlockChanges(level *Level, indices []int32, blocks []BlockID) {
+	if player.loadState() != stateGame {
+		return
+	}
+
+	player.levelLock.Lock()
+	defer player.levelLock.Unlock()
+
+	switch {
+	case player.loading == nil:
+		player.writeBlockChanges(level, indices, blocks)
+	case player.loading != level:
+	case len(player.pendingBlocks)+len(blocks) > maxPendingBlocks:
+		player.requestLevel(level)
+	default:
+		player.pendingIndices = append(player.pendingIndices, indices...)
+		player.pendingBlocks = append(player.pendingBlocks, blocks...)
+	}
+}
+
+// writeBlockChanges queues the packets for the changes of the blocks at
+// indices of level.
+func (player *Player) writeBlockChanges(level *Level, indices []int32, blocks []BlockID) {
+	for len(indices) > 0 {
+		n := min(len(indices), 256)
+		converted := make([]uint16, n)
+		for i, block := range blocks[:n] {
+			converted[i] = uint16(player.convertBlock(block, level))
+		}
+
+		if n > 1 && player.cpe[CpeBulkBlockUpdate] {
+			player.sendPacket(&proto.BulkBlockUpdate{
+				Indices: indices[:n],
+				Blocks:  converted,
+			})
+		} else {
+			packets := make([]proto.Packet, n)
+			for i := range packets {
+				x, y, z := level.Position(int(indices[i]))
+				packets[i] = &proto.SetBlock{X: int16(x), Y: int16(y), Z: int16(z), Block: converted[i]}
+			}
+
+			player.sendPacket(packets...)
+		}
+
+		indices, blocks = indices[n:], blocks[n:]
 	}
 }
 
 func (player *Player) sendCPE() {
-	var packet packet
-	packet.extInfo()
+	packets := []proto.Packet{&proto.ExtInfo{
+		AppName:        ServerSoftware,
+		ExtensionCount: int16(len(Extensions)),
+	}}
+
 	for _, extension := range Extensions {
-		packet.extEntry(&extension)
+		packets = append(packets, &proto.ExtEntry{
+			ExtName: extension.Name,
+			Version: int32(extension.Version),
+		})
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 func (player *Player) sendHotkeys() {
-	if player.state == stateGame && player.cpe[CpeTextHotKey] {
-		var packet packet
+	if player.loadState() == stateGame && player.cpe[CpeTextHotKey] {
+		var packets []proto.Packet
 		for _, desc := range player.server.Hotkeys {
-			packet.setTextHotKey(&desc)
+			packets = append(packets, &proto.SetTextHotKey{
+				Label:   desc.Label,
+				Action:  desc.Action,
+				KeyCode: int32(desc.Key),
+				KeyMods: desc.KeyMods,
+			})
 		}
 
-		player.sendPacket(packet)
+		player.sendPacket(packets...)
 	}
 }
 
 func (player *Player) sendTextColors() {
-	if player.state == stateGame && player.cpe[CpeTextColors] {
-		var packet packet
+	if player.loadState() == stateGame && player.cpe[CpeTextColors] {
+		var packets []proto.Packet
 		for _, desc := range player.server.Colors {
-			packet.setTextColor(&desc)
+			packets = append(packets, &proto.SetTextColor{
+				R:    desc.R,
+				G:    desc.G,
+				B:    desc.B,
+				A:    desc.A,
+				Code: desc.Code,
+			})
 		}
 
-		player.sendPacket(packet)
+		player.sendPacket(packets...)
 	}
 }
 
 func (player *Player) sendAddPlayerList(entity *Entity) {
-	if player.state == stateGame && player.cpe[CpeExtPlayerList] {
-		var packet packet
-		packet.extAddPlayerName(entity, entity.id == player.id)
-		player.sendPacket(packet)
+	if player.loadState() != stateGame || !player.cpe[CpeExtPlayerList] {
+		return
+	}
+
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+
+	id, ok := byte(proto.SelfID), true
+	if entity != player.Entity {
+		id, ok = player.nameIDs.add(entity)
+	}
+
+	if ok {
+		player.sendPacket(extAddPlayerNamePacket(entity, id))
 	}
 }
 
 func (player *Player) sendRemovePlayerList(entity *Entity) {
-	if player.state == stateGame && player.cpe[CpeExtPlayerList] {
-		var packet packet
-		packet.extRemovePlayerName(entity, entity.id == player.id)
-		player.sendPacket(packet)
+	if player.loadState() != stateGame || !player.cpe[CpeExtPlayerList] {
+		return
+	}
+
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+
+	id, ok := byte(proto.SelfID), true
+	if entity != player.Entity {
+		id, ok = player.nameIDs.remove(entity)
+	}
+
+	if ok {
+		player.sendPacket(&proto.ExtRemovePlayerName{NameID: int16(id)})
 	}
 }
 
 func (player *Player) sendChangeModel(entity *Entity) {
-	if player.state == stateGame && player.cpe[CpeChangeModel] {
-		var packet packet
-		packet.changeModel(entity, entity.id == player.id)
-		player.sendPacket(packet)
+	if player.cpe[CpeChangeModel] {
+		player.sendEntityPacket(entity, func(id byte) proto.Packet {
+			return &proto.ChangeModel{EntityID: id, Model: entity.Model}
+		})
 	}
 }
 
 func (player *Player) sendEntityProps(entity *Entity, mask uint32) {
-	if player.state != stateGame || !player.cpe[CpeEntityProperty] {
+	if player.loadState() != stateGame || !player.cpe[CpeEntityProperty] {
+		return
+	}
+
+	id, ok := player.entityID(entity)
+	if !ok {
 		return
 	}
 
-	var packet packet
+	var packets []proto.Packet
 	props := entity.Props
-	self := entity.id == player.id
+	setProperty := func(prop byte, value int32) {
+		packets = append(packets, &proto.SetEntityProperty{
+			EntityID: id,
+			Property: prop,
+			Value:    value,
+		})
+	}
+
 	if mask&EntityPropRotX != 0 {
-		packet.entityProperty(entity, self, 0, int32(props.RotX))
+		setProperty(0, int32(props.RotX))
 	}
 	if mask&EntityPropRotY != 0 {
-		packet.entityProperty(entity, self, 1, int32(props.RotY))
+		setProperty(1, int32(props.RotY))
 	}
 	if mask&EntityPropRotZ != 0 {
-		packet.entityProperty(entity, self, 2, int32(props.RotZ))
+		setProperty(2, int32(props.RotZ))
 	}
 	if mask&EntityPropScaleX != 0 {
-		packet.entityProperty(entity, self, 3, int32(1000*props.ScaleX))
+		setProperty(3, int32(1000*props.ScaleX))
 	}
 	if mask&EntityPropScaleY != 0 {
-		packet.entityProperty(entity, self, 4, int32(1000*props.ScaleY))
+		setProperty(4, int32(1000*props.ScaleY))
 	}
 	if mask&EntityPropScaleZ != 0 {
-		packet.entityProperty(entity, self, 5, int32(1000*props.ScaleZ))
+		setProperty(5, int32(1000*props.ScaleZ))
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 func (player *Player) sendBlockDefinitions(level *Level) {
-	if player.state != stateGame || !player.cpe[CpeBlockDefinitions] {
+	if player.loadState() != stateGame || !player.cpe[CpeBlockDefinitions] {
 		return
 	}
 
-	var packet packet
-	extTex := player.cpe[CpeExtendedTextures]
+	var packets []proto.Packet
+	extBlocks := player.cpe[CpeExtendedBlocks]
 	for id, def := range level.BlockDefs {
-		if def != nil {
-			if player.cpe[CpeBlockDefinitionsExt] && def.Shape != 0 {
-				packet.defineBlock(byte(id), def, true, extTex)
-			} else {
-				packet.defineBlock(byte(id), def, false, extTex)
-			}
+		if def != nil && (id <= 0xff || extBlocks) {
+			ext := player.cpe[CpeBlockDefinitionsExt] && def.Shape != 0
+			packets = append(packets, defineBlockPacket(BlockID(id), def, ext))
 		}
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 func (player *Player) resetBlockDefinitions(level *Level) {
-	if player.state != stateGame || !player.cpe[CpeBlockDefinitions] {
+	if player.loadState() != stateGame || !player.cpe[CpeBlockDefinitions] {
 		return
 	}
 
-	var packet packet
+	var packets []proto.Packet
+	extBlocks := player.cpe[CpeExtendedBlocks]
 	for id, def := range level.BlockDefs {
-		if def != nil {
-			packet.removeBlockDefinition(byte(id))
+		if def != nil && (id <= 0xff || extBlocks) {
+			packets = append(packets, &proto.RemoveBlockDefinition{Block: uint16(id)})
 		}
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 func (player *Player) sendInventory(level *Level) {
-	if player.state == stateGame && player.cpe[CpeInventoryOrder] {
-		var packet packet
+	if player.loadState() == stateGame && player.cpe[CpeInventoryOrder] {
+		var packets []proto.Packet
+		extBlocks := player.cpe[CpeExtendedBlocks]
 		for id, order := range level.Inventory {
-			packet.setInventoryOrder(order, byte(id))
+			if (id <= 0xff && order <= 0xff) || extBlocks {
+				packets = append(packets, &proto.SetInventoryOrder{
+					Order: uint16(order),
+					Block: uint16(id),
+				})
+			}
 		}
 
-		player.sendPacket(packet)
+		player.sendPacket(packets...)
 	}
 }
 
 func (player *Player) resetInventory(level *Level) {
-	if player.state == stateGame && player.cpe[CpeInventoryOrder] {
-		var packet packet
+	if player.loadState() == stateGame && player.cpe[CpeInventoryOrder] {
+		var packets []proto.Packet
+		extBlocks := player.cpe[CpeExtendedBlocks]
 		for id := range level.Inventory {
-			packet.setInventoryOrder(byte(id), byte(id))
+			if id <= 0xff || extBlocks {
+				packets = append(packets, &proto.SetInventoryOrder{
+					Order: uint16(id),
+					Block: uint16(id),
+				})
+			}
 		}
 
-		player.sendPacket(packet)
+		player.sendPacket(packets...)
 	}
 }
 
 func (player *Player) sendEnvConfig(level *Level, mask uint32) {
-	if player.state != stateGame {
+	if player.loadState() != stateGame {
 		return
 	}
 
-	var packet packet
+	var packets []proto.Packet
+	setProperty := func(prop byte, value int32) {
+		packets = append(packets, &proto.SetMapEnvProperty{
+			Property: prop,
+			Value:    value,
+		})
+	}
+
 	config := &level.EnvConfig
 	if player.cpe[CpeEnvMapAspect] {
 		if mask&EnvPropSideBlock != 0 {
-			packet.mapEnvProperty(
+			setProperty(
 				0, int32(player.convertBlock(config.SideBlock, level)))
 		}
 		if mask&EnvPropEdgeBlock != 0 {
-			packet.mapEnvProperty(
+			setProperty(
 				1, int32(player.convertBlock(config.EdgeBlock, level)))
 		}
 		if mask&EnvPropEdgeHeight != 0 {
-			packet.mapEnvProperty(2, int32(config.EdgeHeight))
+			setProperty(2, int32(config.EdgeHeight))
 		}
 		if mask&EnvPropCloudHeight != 0 {
-			packet.mapEnvProperty(3, int32(config.CloudHeight))
+			setProperty(3, int32(config.CloudHeight))
 		}
 		if mask&EnvPropMaxViewDistance != 0 {
-			packet.mapEnvProperty(4, int32(config.MaxViewDistance))
+			setProperty(4, int32(config.MaxViewDistance))
 		}
 		if mask&EnvPropCloudSpeed != 0 {
-			packet.mapEnvProperty(5, int32(256*config.CloudSpeed))
+			setProperty(5, int32(256*config.CloudSpeed))
 		}
 		if mask&EnvPropWeatherSpeed != 0 {
-			packet.mapEnvProperty(6, int32(256*config.WeatherSpeed))
+			setProperty(6, int32(256*config.WeatherSpeed))
 		}
 		if mask&EnvPropWeatherFade != 0 {
-			packet.mapEnvProperty(7, int32(128*config.WeatherFade))
+			setProperty(7, int32(128*config.WeatherFade))
 		}
 		if mask&EnvPropExpFog != 0 {
 			if config.ExpFog {
-				packet.mapEnvProperty(8, 1)
+				setProperty(8, 1)
 			} else {
-				packet.mapEnvProperty(8, 0)
+				setProperty(8, 0)
 			}
 		}
 		if mask&EnvPropSideOffset != 0 {
-			packet.mapEnvProperty(9, int32(config.SideOffset))
+			setProperty(9, int32(config.SideOffset))
 		}
 		if mask&EnvPropTexturePack != 0 {
-			packet.mapEnvUrl(config.TexturePack)
+			packets = append(packets, &proto.SetMapEnvURL{URL: config.TexturePack})
 		}
 	}
 
 	if player.cpe[CpeEnvColors] {
 		if mask&EnvPropSkyColor != 0 {
-			packet.envSetColor(0, config.SkyColor)
+			packets = append(packets, envSetColorPacket(0, config.SkyColor))
 		}
 		if mask&EnvPropCloudColor != 0 {
-			packet.envSetColor(1, config.CloudColor)
+			packets = append(packets, envSetColorPacket(1, config.CloudColor))
 		}
 		if mask&EnvPropFogColor != 0 {
-			packet.envSetColor(2, config.FogColor)
+			packets = append(packets, envSetColorPacket(2, config.FogColor))
 		}
 		if mask&EnvPropAmbientColor != 0 {
-			packet.envSetColor(3, config.AmbientColor)
+			packets = append(packets, envSetColorPacket(3, config.AmbientColor))
 		}
 		if mask&EnvPropDiffuseColor != 0 {
-			packet.envSetColor(4, config.DiffuseColor)
+			packets = append(packets, envSetColorPacket(4, config.DiffuseColor))
 		}
 	}
 
 	if player.cpe[CpeEnvWeatherType] {
 		if mask&EnvPropWeather != 0 {
-			packet.envWeatherType(config.Weather)
+			packets = append(packets, &proto.EnvSetWeatherType{Weather: config.Weather})
 		}
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 func (player *Player) sendHackConfig(level *Level) {
-	if player.state != stateGame {
+	if player.loadState() != stateGame {
 		return
 	}
 
-	var packet packet
+	var packets []proto.Packet
 	config := &level.HackConfig
 	if player.cpe[CpeClickDistance] {
-		packet.clickDistance(config.ReachDistance)
+		packets = append(packets, &proto.SetClickDistance{Distance: config.ReachDistance})
 	}
 	if player.cpe[CpeHackControl] {
-		packet.hackControl(config)
+		packets = append(packets, hackControlPacket(config))
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 // SendPermissions sends the block permissions to the player.
 func (player *Player) SendPermissions() {
-	if player.state != stateGame {
+	if player.loadState() != stateGame {
 		return
 	}
 
@@ -639,119 +1040,136 @@ func (player *Player) SendPermissions() {
 		rank = &DefaultRank
 	}
 
-	var packet packet
-	packet.updateUserType(rank.CanPlace[BlockBedrock])
+	var packets []proto.Packet
+	if player.codec.Version >= ProtocolVersion7 {
+		packets = append(packets, &proto.UpdateUserType{
+			UserType: userType(rank.CanPlace[BlockBedrock]),
+		})
+	}
+
 	if player.cpe[CpeBlockPermissions] {
+		extBlocks := player.cpe[CpeExtendedBlocks]
 		for i := 0; i < BlockCount; i++ {
-			packet.setBlockPermission(byte(i), rank.CanPlace[i], rank.CanBreak[i])
+			if i > 0xff && !extBlocks {
+				break
+			}
+
+			packets = append(packets, &proto.SetBlockPermission{
+				Block:          uint16(i),
+				AllowPlacement: rank.CanPlace[i],
+				AllowDeletion:  rank.CanBreak[i],
+			})
 		}
 	}
 
-	player.sendPacket(packet)
+	player.sendPacket(packets...)
 }
 
 func (player *Player) handle() {
-	buffer := make([]byte, 256)
+	go player.writeLoop()
+	go player.levelLoop()
+
+	config := player.server.Config()
+	var loginDeadline time.Time
+	if config.LoginTimeout > 0 {
+		loginDeadline = time.Now().Add(time.Duration(config.LoginTimeout) * time.Second)
+	}
+
+	reader := proto.NewReader(player.conn, &player.codec, proto.ServerBound)
 	atomic.StoreUint32(&player.state, stateLogin)
-	for player.state != stateClosed {
-		buffer = buffer[:1]
-		_, err := io.ReadFull(player.conn, buffer)
+	for player.loadState() != stateClosed {
+		if player.loadState() != stateGame {
+			player.conn.SetReadDeadline(loginDeadline)
+		} else if config.ReadTimeout > 0 {
+			player.conn.SetReadDeadline(time.Now().Add(time.Duration(config.ReadTimeout) * time.Second))
+		} else {
+			player.conn.SetReadDeadline(time.Time{})
+		}
+
+		packet, err := reader.ReadPacket()
 		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				player.kick("Timed out!", true)
+				return
+			}
+
+			if err == proto.ErrUnknownPacket {
+				player.kick("Invalid packet", true)
+				return
+			}
+
+			if err == proto.ErrUnsupportedVersion {
+				player.kick("Wrong version!", true)
+				return
+			}
+
 			player.Disconnect()
 			return
 		}
 
-		id := buffer[0]
-		var size int
-		switch player.state {
+		player.server.stats.countPacket(proto.ServerBound, packet.ID(), reader.Size())
+
+		valid := true
+		switch player.loadState() {
 		case stateLogin:
-			switch id {
-			case packetTypeIdentification:
-				size = 131
-			case packetTypeExtInfo:
-				size = 67
-			case packetTypeExtEntry:
-				size = 69
-			case packetTypeCustomBlockSupportLevel:
-				size = 2
+			switch packet := packet.(type) {
+			case *proto.IdentificationClient:
+				player.handleIdentification(packet)
+			case *proto.ExtInfo:
+				player.handleExtInfo(packet)
+			case *proto.ExtEntry:
+				player.handleExtEntry(packet)
+			case *proto.CustomBlockSupportLevel:
+				player.handleCustomBlockSupportLevel(packet)
+			default:
+				valid = false
 			}
 
 		case stateGame:
-			switch id {
-			case packetTypeSetBlockClient:
-				size = 9
-			case packetTypePlayerTeleport:
-				if player.cpe[CpeExtEntityPositions] {
-					size = 16
-				} else {
-					size = 10
-				}
-			case packetTypeMessage:
-				size = 66
-			case packetTypePlayerClicked:
-				size = 15
-			case packetTypeTwoWayPing:
-				size = 4
+			switch packet := packet.(type) {
+			case *proto.SetBlockClient:
+				player.handleSetBlock(packet)
+			case *proto.PlayerTeleportClient:
+				player.handleTeleport(packet)
+			case *proto.Message:
+				player.handleMessage(packet)
+			case *proto.PlayerClicked:
+				player.handlePlayerClicked(packet)
+			case *proto.TwoWayPing:
+				player.handleTwoWayPing(packet)
+			default:
+				valid = false
 			}
 		}
 
-		if size == 0 {
-			player.Kick("Invalid packet")
+		if !valid {
+			player.kick("Invalid packet", true)
 			break
 		}
-
-		buffer = buffer[:size]
-		_, err = io.ReadFull(player.conn, buffer[1:])
-		if err != nil {
-			player.Disconnect()
-			return
-		}
-
-		reader := bytes.NewReader(buffer)
-		switch id {
-		case packetTypeIdentification:
-			player.handleIdentification(reader)
-		case packetTypeSetBlockClient:
-			player.handleSetBlock(reader)
-		case packetTypePlayerTeleport:
-			player.handleTeleport(reader)
-		case packetTypeMessage:
-			player.handleMessage(reader)
-		case packetTypeExtInfo:
-			player.handleExtInfo(reader)
-		case packetTypeExtEntry:
-			player.handleExtEntry(reader)
-		case packetTypeCustomBlockSupportLevel:
-			player.handleCustomBlockSupportLevel(reader)
-		case packetTypePlayerClicked:
-			player.handlePlayerClicked(reader)
-		case packetTypeTwoWayPing:
-			player.handleTwoWayPing(reader)
-		}
 	}
 }
 
 func (player *Player) login() {
-	if player.state != stateLogin {
+	if player.loadState() != stateLogin {
 		return
 	}
 
 	event := EventPlayerLogin{player, false, ""}
 	player.server.FireEvent(EventTypePlayerLogin, &event)
 	if event.Cancel {
-		player.Kick(event.CancelReason)
+		player.kick(event.CancelReason, true)
 		return
 	}
 
 	if player.server.FindEntity(player.name) != nil {
-		player.Kick("Already logged in!")
+		player.kick("Already logged in!", true)
 		return
 	}
 
 	for {
-		count := player.server.playerCount
-		if int(count) >= player.server.Config.MaxPlayers {
-			player.Kick("Server full!")
+		count := atomic.LoadInt32(&player.server.playerCount)
+		if int(count) >= player.server.Config().MaxPlayers {
+			player.kick("Server full!", true)
 			return
 		}
 
@@ -760,22 +1178,27 @@ func (player *Player) login() {
 		}
 	}
 
-	if player.cpe[CpeBlockDefinitions] {
+	if player.cpe[CpeBlockDefinitions] && player.cpe[CpeExtendedBlocks] {
 		player.maxBlockID = BlockMax
+	} else if player.cpe[CpeBlockDefinitions] {
+		player.maxBlockID = 0xff
 	} else if player.cpe[CpeCustomBlocks] && player.cpeBlockLevel == 1 {
 		player.maxBlockID = BlockMaxCPE
+	} else if player.codec.Version < ProtocolVersion7 {
+		player.maxBlockID = BlockMaxLegacy
 	} else {
 		player.maxBlockID = BlockMaxClassic
 	}
 
+	player.codec.ExtPositions = player.cpe[CpeExtEntityPositions]
+	player.codec.ExtBlocks = player.cpe[CpeExtendedBlocks]
+	player.codec.ExtTextures = player.cpe[CpeExtendedTextures]
+	player.codec.FastMap = player.cpe[CpeFastMap]
+
 	joinEvent := EventPlayerJoin{player}
 	player.server.FireEvent(EventTypePlayerJoin, &joinEvent)
 
-	if !player.server.AddEntity(player.Entity) {
-		player.Kick("Server full!")
-		return
-	}
-
+	player.server.AddEntity(player.Entity)
 	player.Entity.player = player
 
 	atomic.StoreUint32(&player.state, stateGame)
@@ -794,52 +1217,56 @@ func (player *Player) login() {
 		player.TeleportLevel(player.server.MainLevel)
 	}
 
-	player.pingTicker = time.NewTicker(2 * time.Second)
-	go func() {
-		for range player.pingTicker.C {
-			var packet packet
-			if player.cpe[CpeTwoWayPing] {
-				packet.twoWayPing(1, player.pingBuffer.next())
-			} else {
-				packet.ping()
-			}
+	task := &Task{Delay: pingInterval, Interval: pingInterval}
+	task.Func = func() {
+		if atomic.LoadUint32(&player.state) == stateClosed {
+			task.Cancel()
+			return
+		}
 
-			player.sendPacket(packet)
+		if player.cpe[CpeTwoWayPing] {
+			player.sendPacket(&proto.TwoWayPing{
+				Direction: 1,
+				Data:      player.pingBuffer.next(),
+			})
+		} else {
+			player.sendPacket(&proto.Ping{})
 		}
-	}()
+	}
+
+	player.pingTask = task
+	player.server.addTask(task)
 }
 
-func (player *Player) verify(key []byte) bool {
-	if len(key) != md5.Size {
+// verify reports whether key is the verification key of the player for any of
+// the server lists.
+func (player *Player) verify(key string) bool {
+	data, err := hex.DecodeString(key)
+	if err != nil || len(data) != md5.Size {
 		return false
 	}
 
-	data := make([]byte, len(player.server.salt))
-	copy(data, player.server.salt[:])
-	data = append(data, []byte(player.name)...)
+	for _, salt := range player.server.Salts() {
+		digest := md5.Sum([]byte(salt + player.name))
+		if bytes.Equal(digest[:], data) {
+			return true
+		}
+	}
 
-	digest := md5.Sum(data)
-	return bytes.Equal(digest[:], key)
+	return false
 }
 
-func (player *Player) handleIdentification(reader io.Reader) {
-	packet := struct {
-		PacketID        byte
-		ProtocolVersion byte
-		Name            [64]byte
-		VerificationKey [64]byte
-		Type            byte
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet)
-
-	if packet.ProtocolVersion != 0x07 {
-		player.Kick("Wrong version!")
+func (player *Player) handleIdentification(packet *proto.IdentificationClient) {
+	if packet.Version < ProtocolVersion5 || packet.Version > ProtocolVersion7 {
+		player.kick("Wrong version!", true)
 		return
 	}
 
-	player.name = trimString(packet.Name)
+	player.codec.Version = packet.Version
+
+	player.name = packet.Name
 	if !IsValidName(player.name) {
-		player.Kick("Invalid name!")
+		player.kick("Invalid name!", true)
 		return
 	}
 
@@ -848,15 +1275,14 @@ func (player *Player) handleIdentification(reader io.Reader) {
 	player.SkinName = player.name
 	player.ListName = player.name
 
-	key := trimString(packet.VerificationKey)
-	if player.server.Config.Verify {
-		if !player.verify([]byte(key)) {
-			player.Kick("Login failed!")
+	if player.listener.verifyNames(player.server.Config()) {
+		if !player.verify(packet.VerificationKey) {
+			player.kick("Login failed!", true)
 			return
 		}
 	}
 
-	if packet.Type == 0x42 {
+	if packet.Type == 0x42 && player.codec.Version == ProtocolVersion7 {
 		player.sendCPE()
 	} else {
 		player.login()
@@ -864,21 +1290,14 @@ func (player *Player) handleIdentification(reader io.Reader) {
 }
 
 func (player *Player) revertBlock(x, y, z int) {
-	player.sendBlockChange(x, y, z, player.level.GetBlock(x, y, z))
+	player.sendBlockChange(x, y, z, player.Level().GetBlock(x, y, z))
 }
 
-func (player *Player) handleSetBlock(reader io.Reader) {
-	packet := struct {
-		PacketID  byte
-		X, Y, Z   int16
-		Mode      byte
-		BlockType byte
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet)
+func (player *Player) handleSetBlock(packet *proto.SetBlockClient) {
 	x, y, z := int(packet.X), int(packet.Y), int(packet.Z)
-	block := packet.BlockType
+	block := BlockID(packet.Block)
 
-	level := player.level
+	level := player.Level()
 	if !level.InBounds(x, y, z) {
 		return
 	}
@@ -903,7 +1322,7 @@ func (player *Player) handleSetBlock(reader io.Reader) {
 			return
 		}
 
-		event := &EventBlockBreak{player, level, oldBlock, x, y, z, false}
+		event := EventBlockBreak{player, level, oldBlock, x, y, z, false}
 		player.server.FireEvent(EventTypeBlockBreak, &event)
 		if event.Cancel {
 			player.revertBlock(x, y, z)
@@ -926,7 +1345,7 @@ func (player *Player) handleSetBlock(reader io.Reader) {
 			return
 		}
 
-		event := &EventBlockPlace{player, level, block, oldBlock, x, y, z, false}
+		event := EventBlockPlace{player, level, block, oldBlock, x, y, z, false}
 		player.server.FireEvent(EventTypeBlockPlace, &event)
 		if event.Cancel {
 			player.revertBlock(x, y, z)
@@ -937,60 +1356,40 @@ func (player *Player) handleSetBlock(reader io.Reader) {
 	}
 }
 
-func (player *Player) handleTeleport(reader io.Reader) {
-	packet0 := struct{ PacketID, PlayerID byte }{}
-	binary.Read(reader, binary.BigEndian, &packet0)
-
-	location := Location{}
-	if player.cpe[CpeExtEntityPositions] {
-		packet1 := struct{ X, Y, Z int32 }{}
-		binary.Read(reader, binary.BigEndian, &packet1)
-		location.X = float64(packet1.X) / 32
-		location.Y = float64(packet1.Y) / 32
-		location.Z = float64(packet1.Z) / 32
-	} else {
-		packet1 := struct{ X, Y, Z int16 }{}
-		binary.Read(reader, binary.BigEndian, &packet1)
-		location.X = float64(packet1.X) / 32
-		location.Y = float64(packet1.Y) / 32
-		location.Z = float64(packet1.Z) / 32
+func (player *Player) handleTeleport(packet *proto.PlayerTeleportClient) {
+	location := Location(packet.Location)
+	if player.cpe[CpeHeldBlock] {
+		if held := BlockID(packet.Held); held != player.heldBlock {
+			event := EventPlayerHeldBlock{player, player.heldBlock, held}
+			player.heldBlock = held
+			player.server.FireEvent(EventTypePlayerHeldBlock, &event)
+		}
+	} else if packet.Held != proto.SelfID {
+		return
 	}
 
-	packet2 := struct{ Yaw, Pitch byte }{}
-	binary.Read(reader, binary.BigEndian, &packet2)
-	location.Yaw = float64(packet2.Yaw) * 360 / 256
-	location.Pitch = float64(packet2.Pitch) * 360 / 256
-
-	if player.cpe[CpeHeldBlock] {
-		player.heldBlock = packet0.PlayerID
-	} else if packet0.PlayerID != 0xff {
+	if !player.validateMove(location) {
 		return
 	}
 
-	if player.level == nil || location == player.location {
+	from := player.Location()
+	if player.Level() == nil || location == from {
 		return
 	}
 
-	event := &EventEntityMove{player.Entity, player.location, location, false}
+	event := EventEntityMove{player.Entity, from, location, false}
 	player.server.FireEvent(EventTypeEntityMove, &event)
 	if event.Cancel {
 		player.sendTeleport(player.Entity)
 		return
 	}
 
-	player.location = location
+	player.setLocation(location)
 }
 
-func (player *Player) handleMessage(reader io.Reader) {
-	packet := struct {
-		PacketID byte
-		PlayerID byte
-		Message  [64]byte
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet)
-
-	player.message += trimString(packet.Message)
-	if packet.PlayerID != 0x00 && player.cpe[CpeLongerMessages] {
+func (player *Player) handleMessage(packet *proto.Message) {
+	player.message += packet.Message
+	if packet.Type != 0x00 && player.cpe[CpeLongerMessages] {
 		return
 	}
 
@@ -998,7 +1397,7 @@ func (player *Player) handleMessage(reader io.Reader) {
 	player.message = ""
 
 	if !IsValidMessage(message) {
-		player.Kick("Invalid message!")
+		player.kick("Invalid message!", true)
 		return
 	}
 
@@ -1030,31 +1429,17 @@ func (player *Player) handleMessage(reader io.Reader) {
 	}
 }
 
-func (player *Player) handleExtInfo(reader io.Reader) {
-	packet := struct {
-		PacketID       byte
-		AppName        [64]byte
-		ExtensionCount int16
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet)
-
+func (player *Player) handleExtInfo(packet *proto.ExtInfo) {
 	player.remExtensions = int(packet.ExtensionCount)
 	if player.remExtensions == 0 {
 		player.login()
 	}
 }
 
-func (player *Player) handleExtEntry(reader io.Reader) {
-	packet0 := struct {
-		PacketID byte
-		ExtName  [64]byte
-		Version  int32
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet0)
-
+func (player *Player) handleExtEntry(packet *proto.ExtEntry) {
 	for i, extension := range Extensions {
-		if extension.Name == trimString(packet0.ExtName) {
-			if extension.Version == int(packet0.Version) {
+		if extension.Name == packet.ExtName {
+			if extension.Version == int(packet.Version) {
 				player.cpe[i] = true
 				break
 			}
@@ -1064,18 +1449,14 @@ func (player *Player) handleExtEntry(reader io.Reader) {
 	player.remExtensions--
 	if player.remExtensions == 0 {
 		if player.cpe[CpeCustomBlocks] {
-			var packet1 packet
-			packet1.customBlockSupportLevel(1)
-			player.sendPacket(packet1)
+			player.sendPacket(&proto.CustomBlockSupportLevel{SupportLevel: 1})
 		} else {
 			player.login()
 		}
 	}
 }
 
-func (player *Player) handleCustomBlockSupportLevel(reader io.Reader) {
-	packet := struct{ PacketID, SupportLevel byte }{}
-	binary.Read(reader, binary.BigEndian, &packet)
+func (player *Player) handleCustomBlockSupportLevel(packet *proto.CustomBlockSupportLevel) {
 	if packet.SupportLevel <= 1 {
 		player.cpeBlockLevel = packet.SupportLevel
 	}
@@ -1083,27 +1464,16 @@ func (player *Player) handleCustomBlockSupportLevel(reader io.Reader) {
 	player.login()
 }
 
-func (player *Player) handlePlayerClicked(reader io.Reader) {
-	packet := struct {
-		PacketID               byte
-		Button, Action         byte
-		Yaw, Pitch             int16
-		TargetID               byte
-		BlockX, BlockY, BlockZ int16
-		BlockFace              byte
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet)
-
+func (player *Player) handlePlayerClicked(packet *proto.PlayerClicked) {
 	var target *Entity = nil
-	if packet.TargetID != 0xff {
-		target = player.server.FindEntityByID(packet.TargetID)
+	if packet.TargetID != proto.SelfID {
+		target = player.FindEntityByID(packet.TargetID)
 	}
 
 	event := EventPlayerClick{
 		player,
 		packet.Button, packet.Action,
-		float64(packet.Yaw) * 360 / 65536,
-		float64(packet.Pitch) * 360 / 65536,
+		packet.Yaw, packet.Pitch,
 		target,
 		int(packet.BlockX), int(packet.BlockY), int(packet.BlockZ),
 		packet.BlockFace,
@@ -1111,21 +1481,12 @@ func (player *Player) handlePlayerClicked(reader io.Reader) {
 	player.server.FireEvent(EventTypePlayerClick, &event)
 }
 
-func (player *Player) handleTwoWayPing(reader io.Reader) {
-	packet0 := struct {
-		PacketID  byte
-		Direction byte
-		Data      int16
-	}{}
-	binary.Read(reader, binary.BigEndian, &packet0)
-
-	switch packet0.Direction {
+func (player *Player) handleTwoWayPing(packet *proto.TwoWayPing) {
+	switch packet.Direction {
 	case 0:
-		var packet1 packet
-		packet1.twoWayPing(0, packet0.Data)
-		player.sendPacket(packet1)
+		player.sendPacket(&proto.TwoWayPing{Direction: 0, Data: packet.Data})
 
 	case 1:
-		player.pingBuffer.update(packet0.Data)
+		player.pingBuffer.update(packet.Data)
 	}
 }
@@ -0,0 +1,380 @@
+package mcc
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	PluginDisabled = iota
+	PluginEnabled
+	PluginFailed
+)
+
+// Plugin is the interface that must be implemented by all plugins. If
+// Enable returns an error, the plugin is marked as failed.
+type Plugin interface {
+	Name() string
+	Enable(*Server) error
+	Disable(*Server)
+}
+
+// PluginInfo contains the metadata of a plugin. The plugins listed in
+// Depends must be enabled before the plugin. The plugins listed in
+// SoftDepends are enabled before the plugin if they are present.
+type PluginInfo struct {
+	Name        string
+	Version     string
+	Authors     []string
+	Depends     []string
+	SoftDepends []string
+}
+
+// DescribedPlugin is implemented by plugins that provide their metadata.
+type DescribedPlugin interface {
+	Plugin
+	Info() PluginInfo
+}
+
+// PluginStatus describes a plugin registered to the server. If State is
+// PluginFailed, Err contains the reason.
+type PluginStatus struct {
+	Plugin Plugin
+	Info   PluginInfo
+	State  int
+	Err    error
+}
+
+type pluginEntry struct {
+	plugin Plugin
+	info   PluginInfo
+	state  int
+	err    error
+}
+
+// pluginInfo returns the metadata of plugin.
+func pluginInfo(plugin Plugin) PluginInfo {
+	var info PluginInfo
+	if described, ok := plugin.(DescribedPlugin); ok {
+		info = described.Info()
+	}
+
+	if len(info.Name) == 0 {
+		info.Name = plugin.Name()
+	}
+
+	return info
+}
+
+// AddPlugin registers and enables plugin.
+func (server *Server) AddPlugin(plugin Plugin) error {
+	if errs := server.LoadPlugins([]Plugin{plugin}); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// LoadPlugins registers and enables plugins. The plugins are enabled after
+// their dependencies, and otherwise in the order they are given. Plugins that
+// cannot be enabled are skipped, and the reasons are returned.
+func (server *Server) LoadPlugins(plugins []Plugin) (errs []error) {
+	server.pluginsOpLock.Lock()
+	defer server.pluginsOpLock.Unlock()
+
+	server.pluginsLock.Lock()
+	known := make(map[string]bool)
+	for _, entry := range server.plugins {
+		known[entry.info.Name] = true
+	}
+
+	var entries []*pluginEntry
+	for _, plugin := range plugins {
+		if plugin == nil {
+			errs = append(errs, errors.New("plugin: nil plugin"))
+			continue
+		}
+
+		info := pluginInfo(plugin)
+		if known[info.Name] {
+			errs = append(errs, fmt.Errorf("plugin %s: already loaded", info.Name))
+			continue
+		}
+
+		known[info.Name] = true
+		entries = append(entries, &pluginEntry{plugin: plugin, info: info})
+	}
+
+	entries = sortPlugins(entries)
+	server.plugins = append(server.plugins, entries...)
+	server.pluginsLock.Unlock()
+
+	for _, entry := range entries {
+		if entry.state != PluginFailed {
+			server.enablePlugin(entry)
+		}
+
+		if entry.state == PluginFailed {
+			errs = append(errs, fmt.Errorf("plugin %s: %s", entry.info.Name, entry.err))
+		}
+	}
+
+	return
+}
+
+// sortPlugins sorts entries so that each plugin follows its dependencies.
+// Plugins with missing or cyclic dependencies are marked as failed.
+func sortPlugins(entries []*pluginEntry) []*pluginEntry {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	byName := make(map[string]*pluginEntry)
+	for _, entry := range entries {
+		byName[entry.info.Name] = entry
+	}
+
+	sorted := make([]*pluginEntry, 0, len(entries))
+	marks := make(map[*pluginEntry]int)
+
+	var visit func(entry *pluginEntry)
+	visit = func(entry *pluginEntry) {
+		marks[entry] = visiting
+		for _, name := range entry.info.Depends {
+			dep := byName[name]
+			if dep == nil {
+				continue
+			}
+
+			switch marks[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				entry.state = PluginFailed
+				entry.err = fmt.Errorf("dependency cycle with %s", name)
+			}
+		}
+
+		for _, name := range entry.info.SoftDepends {
+			if dep := byName[name]; dep != nil && marks[dep] == unvisited {
+				visit(dep)
+			}
+		}
+
+		marks[entry] = visited
+		sorted = append(sorted, entry)
+	}
+
+	for _, entry := range entries {
+		if marks[entry] == unvisited {
+			visit(entry)
+		}
+	}
+
+	return sorted
+}
+
+// FindPlugin returns the plugin with the specified name.
+func (server *Server) FindPlugin(name string) Plugin {
+	server.pluginsLock.RLock()
+	defer server.pluginsLock.RUnlock()
+
+	if entry := server.findPluginEntry(name); entry != nil {
+		return entry.plugin
+	}
+
+	return nil
+}
+
+func (server *Server) findPluginEntry(name string) *pluginEntry {
+	for _, entry := range server.plugins {
+		if entry.info.Name == name {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Plugins returns the status of all registered plugins, in the order they
+// were enabled.
+func (server *Server) Plugins() []PluginStatus {
+	server.pluginsLock.RLock()
+	defer server.pluginsLock.RUnlock()
+
+	plugins := make([]PluginStatus, len(server.plugins))
+	for i, entry := range server.plugins {
+		plugins[i] = PluginStatus{entry.plugin, entry.info, entry.state, entry.err}
+	}
+
+	return plugins
+}
+
+// EnablePlugin enables the plugin with the specified name. Its dependencies
+// must be enabled.
+func (server *Server) EnablePlugin(name string) error {
+	server.pluginsOpLock.Lock()
+	defer server.pluginsOpLock.Unlock()
+
+	server.pluginsLock.RLock()
+	entry := server.findPluginEntry(name)
+	server.pluginsLock.RUnlock()
+	if entry == nil {
+		return fmt.Errorf("plugin %s: not found", name)
+	} else if entry.state == PluginEnabled {
+		return fmt.Errorf("plugin %s: already enabled", name)
+	}
+
+	server.enablePlugin(entry)
+	if entry.state == PluginFailed {
+		return fmt.Errorf("plugin %s: %s", name, entry.err)
+	}
+
+	return nil
+}
+
+// DisablePlugin disables the plugin with the specified name. The plugins
+// that depend on it must be disabled first.
+func (server *Server) DisablePlugin(name string) error {
+	server.pluginsOpLock.Lock()
+	defer server.pluginsOpLock.Unlock()
+
+	server.pluginsLock.RLock()
+	entry := server.findPluginEntry(name)
+	var dependents []string
+	for _, other := range server.plugins {
+		if other.state == PluginEnabled && contains(other.info.Depends, name) {
+			dependents = append(dependents, other.info.Name)
+		}
+	}
+	server.pluginsLock.RUnlock()
+
+	if entry == nil {
+		return fmt.Errorf("plugin %s: not found", name)
+	} else if entry.state != PluginEnabled {
+		return fmt.Errorf("plugin %s: not enabled", name)
+	} else if len(dependents) > 0 {
+		return fmt.Errorf("plugin %s: required by %s", name, dependents[0])
+	}
+
+	return server.disablePlugin(entry)
+}
+
+// enablePlugin enables the plugin of entry and moves it to the end of the
+// load order. If a dependency is not enabled or the plugin fails, entry is
+// marked as failed.
+func (server *Server) enablePlugin(entry *pluginEntry) {
+	var err error
+	server.pluginsLock.RLock()
+	for _, name := range entry.info.Depends {
+		if dep := server.findPluginEntry(name); dep == nil {
+			err = fmt.Errorf("missing dependency %s", name)
+		} else if dep.state != PluginEnabled {
+			err = fmt.Errorf("dependency %s is not enabled", name)
+		}
+	}
+	server.pluginsLock.RUnlock()
+
+	if err == nil {
+		err = server.callEnable(entry.plugin)
+	}
+
+	server.pluginsLock.Lock()
+	if err != nil {
+		entry.state, entry.err = PluginFailed, err
+	} else {
+		entry.state, entry.err = PluginEnabled, nil
+		for i, other := range server.plugins {
+			if other == entry {
+				copy(server.plugins[i:], server.plugins[i+1:])
+				server.plugins[len(server.plugins)-1] = entry
+				break
+			}
+		}
+	}
+	server.pluginsLock.Unlock()
+
+	if err == nil {
+		event := EventPluginEnable{entry.plugin}
+		server.FireEvent(EventTypePluginEnable, &event)
+	}
+}
+
+// callEnable calls the Enable method of plugin. If it fails or panics, the
+// handlers, commands, tasks and metrics registered by the plugin are removed.
+func (server *Server) callEnable(plugin Plugin) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("enable failed: %v", r)
+		}
+
+		if err != nil {
+			server.removePluginHandlers(plugin)
+			server.removePluginCommands(plugin)
+			server.cancelPluginTasks(plugin)
+			server.metrics.removeOwner(plugin)
+		}
+	}()
+
+	return plugin.Enable(server)
+}
+
+// disablePlugin removes the handlers, commands, tasks and metrics of the
+// plugin of entry and disables it.
+func (server *Server) disablePlugin(entry *pluginEntry) (err error) {
+	plugin := entry.plugin
+	event := EventPluginDisable{plugin}
+	server.FireEvent(EventTypePluginDisable, &event)
+
+	server.removePluginHandlers(plugin)
+	server.removePluginCommands(plugin)
+	server.cancelPluginTasks(plugin)
+	server.metrics.removeOwner(plugin)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("disabling plugin %s: %v", entry.info.Name, r)
+		}
+
+		server.pluginsLock.Lock()
+		entry.state = PluginDisabled
+		server.pluginsLock.Unlock()
+	}()
+
+	plugin.Disable(server)
+	return
+}
+
+// disablePlugins disables all enabled plugins in reverse load order.
+func (server *Server) disablePlugins() (errs []error) {
+	server.pluginsOpLock.Lock()
+	defer server.pluginsOpLock.Unlock()
+
+	server.pluginsLock.RLock()
+	entries := make([]*pluginEntry, len(server.plugins))
+	copy(entries, server.plugins)
+	server.pluginsLock.RUnlock()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].state != PluginEnabled {
+			continue
+		}
+
+		if err := server.disablePlugin(entries[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+package mcc
+
+import (
+	"errors"
+	"testing"
+)
+
+// testPlugin registers a command and fails to enable if err is set.
+type testPlugin struct {
+	name string
+	err  error
+}
+
+func (plugin *testPlugin) Name() string { return plugin.name }
+
+func (plugin *testPlugin) Enable(server *Server) error {
+	server.Registrar(plugin).AddCommand(&Command{
+		Name:    plugin.name,
+		Handler: func(sender CommandSender, command *Command, message string) {},
+	})
+
+	return plugin.err
+}
+
+func (plugin *testPlugin) Disable(server *Server) {}
+
+func TestPluginEnableError(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	if err := server.AddPlugin(&testPlugin{name: "good"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := server.AddPlugin(&testPlugin{name: "bad", err: errors.New("no database")})
+	if err == nil || err.Error() != "plugin bad: no database" {
+		t.Fatalf("got error %v", err)
+	}
+
+	for _, status := range server.Plugins() {
+		want := PluginEnabled
+		if status.Info.Name == "bad" {
+			want = PluginFailed
+		}
+
+		if status.State != want {
+			t.Errorf("plugin %s: got state %d, want %d", status.Info.Name, status.State, want)
+		}
+	}
+
+	if server.FindCommand("good") == nil {
+		t.Error("command of the enabled plugin was removed")
+	}
+
+	if server.FindCommand("bad") != nil {
+		t.Error("command of the failed plugin was not removed")
+	}
+}
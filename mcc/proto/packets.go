@@ -0,0 +1,1196 @@
+package proto
+
+// Packet IDs.
+const (
+	TypeIdentification            = 0x00
+	TypePing                      = 0x01
+	TypeLevelInitialize           = 0x02
+	TypeLevelDataChunk            = 0x03
+	TypeLevelFinalize             = 0x04
+	TypeSetBlockClient            = 0x05
+	TypeSetBlock                  = 0x06
+	TypeAddEntity                 = 0x07
+	TypePlayerTeleport            = 0x08
+	TypePositionOrientationUpdate = 0x09
+	TypePositionUpdate            = 0x0a
+	TypeOrientationUpdate         = 0x0b
+	TypeRemoveEntity              = 0x0c
+	TypeMessage                   = 0x0d
+	TypeKick                      = 0x0e
+	TypeUpdateUserType            = 0x0f
+	TypeExtInfo                   = 0x10
+	TypeExtEntry                  = 0x11
+	TypeSetClickDistance          = 0x12
+	TypeCustomBlockSupportLevel   = 0x13
+	TypeHoldThis                  = 0x14
+	TypeSetTextHotKey             = 0x15
+	TypeExtAddPlayerName          = 0x16
+	TypeExtRemovePlayerName       = 0x18
+	TypeEnvSetColor               = 0x19
+	TypeMakeSelection             = 0x1a
+	TypeRemoveSelection           = 0x1b
+	TypeSetBlockPermission        = 0x1c
+	TypeChangeModel               = 0x1d
+	TypeEnvSetWeatherType         = 0x1f
+	TypeHackControl               = 0x20
+	TypeExtAddEntity2             = 0x21
+	TypePlayerClicked             = 0x22
+	TypeDefineBlock               = 0x23
+	TypeRemoveBlockDefinition     = 0x24
+	TypeDefineBlockExt            = 0x25
+	TypeBulkBlockUpdate           = 0x26
+	TypeSetTextColor              = 0x27
+	TypeSetMapEnvURL              = 0x28
+	TypeSetMapEnvProperty         = 0x29
+	TypeSetEntityProperty         = 0x2a
+	TypeTwoWayPing                = 0x2b
+	TypeSetInventoryOrder         = 0x2c
+)
+
+// SelfID is the entity ID that refers to the receiving player.
+const SelfID = 0xff
+
+// LevelChunkSize is the maximum length of the data in a LevelDataChunk.
+const LevelChunkSize = 1024
+
+// BulkBlockUpdateSize is the maximum number of blocks in a BulkBlockUpdate.
+const BulkBlockUpdateSize = 256
+
+// NewPacket returns a new, empty packet of the type with the specified ID that
+// is sent in the specified direction. If the packet is unknown, the function
+// returns nil.
+func NewPacket(id byte, direction Direction) Packet {
+	switch id {
+	case TypeMessage:
+		return &Message{}
+	case TypeExtInfo:
+		return &ExtInfo{}
+	case TypeExtEntry:
+		return &ExtEntry{}
+	case TypeCustomBlockSupportLevel:
+		return &CustomBlockSupportLevel{}
+	case TypeTwoWayPing:
+		return &TwoWayPing{}
+	}
+
+	if direction == ServerBound {
+		switch id {
+		case TypeIdentification:
+			return &IdentificationClient{}
+		case TypeSetBlockClient:
+			return &SetBlockClient{}
+		case TypePlayerTeleport:
+			return &PlayerTeleportClient{}
+		case TypePlayerClicked:
+			return &PlayerClicked{}
+		}
+
+		return nil
+	}
+
+	switch id {
+	case TypeIdentification:
+		return &Identification{}
+	case TypePing:
+		return &Ping{}
+	case TypeLevelInitialize:
+		return &LevelInitialize{}
+	case TypeLevelDataChunk:
+		return &LevelDataChunk{}
+	case TypeLevelFinalize:
+		return &LevelFinalize{}
+	case TypeSetBlock:
+		return &SetBlock{}
+	case TypeAddEntity:
+		return &AddEntity{}
+	case TypePlayerTeleport:
+		return &PlayerTeleport{}
+	case TypePositionOrientationUpdate:
+		return &PositionOrientationUpdate{}
+	case TypePositionUpdate:
+		return &PositionUpdate{}
+	case TypeOrientationUpdate:
+		return &OrientationUpdate{}
+	case TypeRemoveEntity:
+		return &RemoveEntity{}
+	case TypeKick:
+		return &Kick{}
+	case TypeUpdateUserType:
+		return &UpdateUserType{}
+	case TypeSetClickDistance:
+		return &SetClickDistance{}
+	case TypeHoldThis:
+		return &HoldThis{}
+	case TypeSetTextHotKey:
+		return &SetTextHotKey{}
+	case TypeExtAddPlayerName:
+		return &ExtAddPlayerName{}
+	case TypeExtRemovePlayerName:
+		return &ExtRemovePlayerName{}
+	case TypeEnvSetColor:
+		return &EnvSetColor{}
+	case TypeMakeSelection:
+		return &MakeSelection{}
+	case TypeRemoveSelection:
+		return &RemoveSelection{}
+	case TypeSetBlockPermission:
+		return &SetBlockPermission{}
+	case TypeChangeModel:
+		return &ChangeModel{}
+	case TypeEnvSetWeatherType:
+		return &EnvSetWeatherType{}
+	case TypeHackControl:
+		return &HackControl{}
+	case TypeExtAddEntity2:
+		return &ExtAddEntity2{}
+	case TypeDefineBlock:
+		return &DefineBlock{}
+	case TypeRemoveBlockDefinition:
+		return &RemoveBlockDefinition{}
+	case TypeDefineBlockExt:
+		return &DefineBlockExt{}
+	case TypeBulkBlockUpdate:
+		return &BulkBlockUpdate{}
+	case TypeSetTextColor:
+		return &SetTextColor{}
+	case TypeSetMapEnvURL:
+		return &SetMapEnvURL{}
+	case TypeSetMapEnvProperty:
+		return &SetMapEnvProperty{}
+	case TypeSetEntityProperty:
+		return &SetEntityProperty{}
+	case TypeSetInventoryOrder:
+		return &SetInventoryOrder{}
+	}
+
+	return nil
+}
+
+// IdentificationClient is sent by the client to log in.
+// Type is 0x42 if the client supports CPE. It is omitted for clients older than
+// Version7.
+type IdentificationClient struct {
+	Version         byte
+	Name            string
+	VerificationKey string
+	Type            byte
+}
+
+func (p *IdentificationClient) ID() byte { return TypeIdentification }
+
+func (p *IdentificationClient) encode(e *encoder) {
+	e.byte(p.Version)
+	e.string(p.Name)
+	e.string(p.VerificationKey)
+	if e.codec.Version >= Version7 {
+		e.byte(p.Type)
+	}
+}
+
+func (p *IdentificationClient) decode(d *decoder) {
+	p.Version = d.byte()
+	p.Name = d.string()
+	p.VerificationKey = d.string()
+	if d.codec.Version >= Version7 {
+		p.Type = d.byte()
+	}
+}
+
+// Identification is sent by the server in response to a login, and whenever
+// the MOTD changes. UserType is omitted for clients older than Version7.
+type Identification struct {
+	Version  byte
+	Name     string
+	MOTD     string
+	UserType byte
+}
+
+func (p *Identification) ID() byte { return TypeIdentification }
+
+func (p *Identification) encode(e *encoder) {
+	e.byte(p.Version)
+	e.string(p.Name)
+	e.string(p.MOTD)
+	if e.codec.Version >= Version7 {
+		e.byte(p.UserType)
+	}
+}
+
+func (p *Identification) decode(d *decoder) {
+	p.Version = d.byte()
+	p.Name = d.string()
+	p.MOTD = d.string()
+	if d.codec.Version >= Version7 {
+		p.UserType = d.byte()
+	}
+}
+
+// Ping is sent periodically by the server to check the connection.
+type Ping struct{}
+
+func (p *Ping) ID() byte          { return TypePing }
+func (p *Ping) encode(e *encoder) {}
+func (p *Ping) decode(d *decoder) {}
+
+// LevelInitialize starts the transfer of a level. Size is only sent if the
+// FastMap extension is enabled.
+type LevelInitialize struct {
+	Size int32
+}
+
+func (p *LevelInitialize) ID() byte { return TypeLevelInitialize }
+
+func (p *LevelInitialize) encode(e *encoder) {
+	if e.codec.FastMap {
+		e.int32(p.Size)
+	}
+}
+
+func (p *LevelInitialize) decode(d *decoder) {
+	if d.codec.FastMap {
+		p.Size = d.int32()
+	}
+}
+
+// LevelDataChunk carries up to LevelChunkSize bytes of compressed level data.
+type LevelDataChunk struct {
+	Data    []byte
+	Percent byte
+}
+
+func (p *LevelDataChunk) ID() byte { return TypeLevelDataChunk }
+
+func (p *LevelDataChunk) encode(e *encoder) {
+	e.int16(int16(len(p.Data)))
+	e.bytes(p.Data, LevelChunkSize)
+	e.byte(p.Percent)
+}
+
+func (p *LevelDataChunk) decode(d *decoder) {
+	length := int(d.int16())
+	data := d.bytes(LevelChunkSize)
+	if length >= 0 && length <= LevelChunkSize {
+		p.Data = data[:length]
+	}
+
+	p.Percent = d.byte()
+}
+
+// LevelFinalize completes the transfer of a level.
+type LevelFinalize struct {
+	X, Y, Z int16
+}
+
+func (p *LevelFinalize) ID() byte { return TypeLevelFinalize }
+
+func (p *LevelFinalize) encode(e *encoder) {
+	e.int16(p.X)
+	e.int16(p.Y)
+	e.int16(p.Z)
+}
+
+func (p *LevelFinalize) decode(d *decoder) {
+	p.X = d.int16()
+	p.Y = d.int16()
+	p.Z = d.int16()
+}
+
+// SetBlockClient is sent by the client when it breaks (Mode 0) or places
+// (Mode 1) a block.
+type SetBlockClient struct {
+	X, Y, Z int16
+	Mode    byte
+	Block   uint16
+}
+
+func (p *SetBlockClient) ID() byte { return TypeSetBlockClient }
+
+func (p *SetBlockClient) encode(e *encoder) {
+	e.int16(p.X)
+	e.int16(p.Y)
+	e.int16(p.Z)
+	e.byte(p.Mode)
+	e.block(p.Block)
+}
+
+func (p *SetBlockClient) decode(d *decoder) {
+	p.X = d.int16()
+	p.Y = d.int16()
+	p.Z = d.int16()
+	p.Mode = d.byte()
+	p.Block = d.block()
+}
+
+// SetBlock changes a block in the level.
+type SetBlock struct {
+	X, Y, Z int16
+	Block   uint16
+}
+
+func (p *SetBlock) ID() byte { return TypeSetBlock }
+
+func (p *SetBlock) encode(e *encoder) {
+	e.int16(p.X)
+	e.int16(p.Y)
+	e.int16(p.Z)
+	e.block(p.Block)
+}
+
+func (p *SetBlock) decode(d *decoder) {
+	p.X = d.int16()
+	p.Y = d.int16()
+	p.Z = d.int16()
+	p.Block = d.block()
+}
+
+// AddEntity spawns an entity.
+type AddEntity struct {
+	EntityID byte
+	Name     string
+	Location Location
+}
+
+func (p *AddEntity) ID() byte { return TypeAddEntity }
+
+func (p *AddEntity) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.string(p.Name)
+	e.location(p.Location)
+}
+
+func (p *AddEntity) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.Name = d.string()
+	p.Location = d.location()
+}
+
+// PlayerTeleportClient is sent by the client when it moves. Held is the
+// block that the player is holding if the HeldBlock extension is enabled,
+// and SelfID otherwise.
+type PlayerTeleportClient struct {
+	Held     uint16
+	Location Location
+}
+
+func (p *PlayerTeleportClient) ID() byte { return TypePlayerTeleport }
+
+func (p *PlayerTeleportClient) encode(e *encoder) {
+	e.block(p.Held)
+	e.location(p.Location)
+}
+
+func (p *PlayerTeleportClient) decode(d *decoder) {
+	p.Held = d.block()
+	p.Location = d.location()
+}
+
+// PlayerTeleport moves an entity to an absolute location.
+type PlayerTeleport struct {
+	EntityID byte
+	Location Location
+}
+
+func (p *PlayerTeleport) ID() byte { return TypePlayerTeleport }
+
+func (p *PlayerTeleport) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.location(p.Location)
+}
+
+func (p *PlayerTeleport) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.Location = d.location()
+}
+
+// PositionOrientationUpdate moves an entity by a small offset and changes its
+// orientation.
+type PositionOrientationUpdate struct {
+	EntityID   byte
+	DX, DY, DZ float64
+	Yaw, Pitch float64
+}
+
+func (p *PositionOrientationUpdate) ID() byte { return TypePositionOrientationUpdate }
+
+func (p *PositionOrientationUpdate) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.delta(p.DX)
+	e.delta(p.DY)
+	e.delta(p.DZ)
+	e.angle(p.Yaw)
+	e.angle(p.Pitch)
+}
+
+func (p *PositionOrientationUpdate) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.DX = d.delta()
+	p.DY = d.delta()
+	p.DZ = d.delta()
+	p.Yaw = d.angle()
+	p.Pitch = d.angle()
+}
+
+// PositionUpdate moves an entity by a small offset.
+type PositionUpdate struct {
+	EntityID   byte
+	DX, DY, DZ float64
+}
+
+func (p *PositionUpdate) ID() byte { return TypePositionUpdate }
+
+func (p *PositionUpdate) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.delta(p.DX)
+	e.delta(p.DY)
+	e.delta(p.DZ)
+}
+
+func (p *PositionUpdate) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.DX = d.delta()
+	p.DY = d.delta()
+	p.DZ = d.delta()
+}
+
+// OrientationUpdate changes the orientation of an entity.
+type OrientationUpdate struct {
+	EntityID   byte
+	Yaw, Pitch float64
+}
+
+func (p *OrientationUpdate) ID() byte { return TypeOrientationUpdate }
+
+func (p *OrientationUpdate) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.angle(p.Yaw)
+	e.angle(p.Pitch)
+}
+
+func (p *OrientationUpdate) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.Yaw = d.angle()
+	p.Pitch = d.angle()
+}
+
+// RemoveEntity despawns an entity.
+type RemoveEntity struct {
+	EntityID byte
+}
+
+func (p *RemoveEntity) ID() byte          { return TypeRemoveEntity }
+func (p *RemoveEntity) encode(e *encoder) { e.byte(p.EntityID) }
+func (p *RemoveEntity) decode(d *decoder) { p.EntityID = d.byte() }
+
+// Message is a chat message. Type is the message type when sent by the
+// server. When sent by the client, a non-zero Type indicates that more parts
+// of the message follow, if the LongerMessages extension is enabled.
+type Message struct {
+	Type    byte
+	Message string
+}
+
+func (p *Message) ID() byte { return TypeMessage }
+
+func (p *Message) encode(e *encoder) {
+	e.byte(p.Type)
+	e.string(p.Message)
+}
+
+func (p *Message) decode(d *decoder) {
+	p.Type = d.byte()
+	p.Message = d.string()
+}
+
+// Kick disconnects the client.
+type Kick struct {
+	Reason string
+}
+
+func (p *Kick) ID() byte          { return TypeKick }
+func (p *Kick) encode(e *encoder) { e.string(p.Reason) }
+func (p *Kick) decode(d *decoder) { p.Reason = d.string() }
+
+// UpdateUserType changes whether the player is an operator (0x64) or not.
+type UpdateUserType struct {
+	UserType byte
+}
+
+func (p *UpdateUserType) ID() byte          { return TypeUpdateUserType }
+func (p *UpdateUserType) encode(e *encoder) { e.byte(p.UserType) }
+func (p *UpdateUserType) decode(d *decoder) { p.UserType = d.byte() }
+
+// ExtInfo starts the CPE negotiation.
+type ExtInfo struct {
+	AppName        string
+	ExtensionCount int16
+}
+
+func (p *ExtInfo) ID() byte { return TypeExtInfo }
+
+func (p *ExtInfo) encode(e *encoder) {
+	e.string(p.AppName)
+	e.int16(p.ExtensionCount)
+}
+
+func (p *ExtInfo) decode(d *decoder) {
+	p.AppName = d.string()
+	p.ExtensionCount = d.int16()
+}
+
+// ExtEntry declares support for a CPE extension.
+type ExtEntry struct {
+	ExtName string
+	Version int32
+}
+
+func (p *ExtEntry) ID() byte { return TypeExtEntry }
+
+func (p *ExtEntry) encode(e *encoder) {
+	e.string(p.ExtName)
+	e.int32(p.Version)
+}
+
+func (p *ExtEntry) decode(d *decoder) {
+	p.ExtName = d.string()
+	p.Version = d.int32()
+}
+
+// SetClickDistance changes the reach distance of the player.
+type SetClickDistance struct {
+	Distance float64
+}
+
+func (p *SetClickDistance) ID() byte          { return TypeSetClickDistance }
+func (p *SetClickDistance) encode(e *encoder) { e.int16(int16(p.Distance * 32)) }
+func (p *SetClickDistance) decode(d *decoder) { p.Distance = float64(d.int16()) / 32 }
+
+// CustomBlockSupportLevel negotiates the CustomBlocks support level.
+type CustomBlockSupportLevel struct {
+	SupportLevel byte
+}
+
+func (p *CustomBlockSupportLevel) ID() byte          { return TypeCustomBlockSupportLevel }
+func (p *CustomBlockSupportLevel) encode(e *encoder) { e.byte(p.SupportLevel) }
+func (p *CustomBlockSupportLevel) decode(d *decoder) { p.SupportLevel = d.byte() }
+
+// HoldThis changes the block that the player is holding.
+type HoldThis struct {
+	Block         uint16
+	PreventChange bool
+}
+
+func (p *HoldThis) ID() byte { return TypeHoldThis }
+
+func (p *HoldThis) encode(e *encoder) {
+	e.block(p.Block)
+	e.bool(p.PreventChange)
+}
+
+func (p *HoldThis) decode(d *decoder) {
+	p.Block = d.block()
+	p.PreventChange = d.bool()
+}
+
+// SetTextHotKey binds a chat action to a key.
+type SetTextHotKey struct {
+	Label   string
+	Action  string
+	KeyCode int32
+	KeyMods byte
+}
+
+func (p *SetTextHotKey) ID() byte { return TypeSetTextHotKey }
+
+func (p *SetTextHotKey) encode(e *encoder) {
+	e.string(p.Label)
+	e.string(p.Action)
+	e.int32(p.KeyCode)
+	e.byte(p.KeyMods)
+}
+
+func (p *SetTextHotKey) decode(d *decoder) {
+	p.Label = d.string()
+	p.Action = d.string()
+	p.KeyCode = d.int32()
+	p.KeyMods = d.byte()
+}
+
+// ExtAddPlayerName adds or updates an entry in the player list.
+type ExtAddPlayerName struct {
+	NameID     int16
+	PlayerName string
+	ListName   string
+	GroupName  string
+	GroupRank  byte
+}
+
+func (p *ExtAddPlayerName) ID() byte { return TypeExtAddPlayerName }
+
+func (p *ExtAddPlayerName) encode(e *encoder) {
+	e.int16(p.NameID)
+	e.string(p.PlayerName)
+	e.string(p.ListName)
+	e.string(p.GroupName)
+	e.byte(p.GroupRank)
+}
+
+func (p *ExtAddPlayerName) decode(d *decoder) {
+	p.NameID = d.int16()
+	p.PlayerName = d.string()
+	p.ListName = d.string()
+	p.GroupName = d.string()
+	p.GroupRank = d.byte()
+}
+
+// ExtRemovePlayerName removes an entry from the player list.
+type ExtRemovePlayerName struct {
+	NameID int16
+}
+
+func (p *ExtRemovePlayerName) ID() byte          { return TypeExtRemovePlayerName }
+func (p *ExtRemovePlayerName) encode(e *encoder) { e.int16(p.NameID) }
+func (p *ExtRemovePlayerName) decode(d *decoder) { p.NameID = d.int16() }
+
+// EnvSetColor changes an environment color. A negative component resets the
+// color to the default.
+type EnvSetColor struct {
+	Variable byte
+	R, G, B  int16
+}
+
+func (p *EnvSetColor) ID() byte { return TypeEnvSetColor }
+
+func (p *EnvSetColor) encode(e *encoder) {
+	e.byte(p.Variable)
+	e.int16(p.R)
+	e.int16(p.G)
+	e.int16(p.B)
+}
+
+func (p *EnvSetColor) decode(d *decoder) {
+	p.Variable = d.byte()
+	p.R = d.int16()
+	p.G = d.int16()
+	p.B = d.int16()
+}
+
+// MakeSelection marks a cuboid selection.
+type MakeSelection struct {
+	SelectionID            byte
+	Label                  string
+	StartX, StartY, StartZ int16
+	EndX, EndY, EndZ       int16
+	R, G, B, Opacity       int16
+}
+
+func (p *MakeSelection) ID() byte { return TypeMakeSelection }
+
+func (p *MakeSelection) encode(e *encoder) {
+	e.byte(p.SelectionID)
+	e.string(p.Label)
+	e.int16(p.StartX)
+	e.int16(p.StartY)
+	e.int16(p.StartZ)
+	e.int16(p.EndX)
+	e.int16(p.EndY)
+	e.int16(p.EndZ)
+	e.int16(p.R)
+	e.int16(p.G)
+	e.int16(p.B)
+	e.int16(p.Opacity)
+}
+
+func (p *MakeSelection) decode(d *decoder) {
+	p.SelectionID = d.byte()
+	p.Label = d.string()
+	p.StartX = d.int16()
+	p.StartY = d.int16()
+	p.StartZ = d.int16()
+	p.EndX = d.int16()
+	p.EndY = d.int16()
+	p.EndZ = d.int16()
+	p.R = d.int16()
+	p.G = d.int16()
+	p.B = d.int16()
+	p.Opacity = d.int16()
+}
+
+// RemoveSelection removes a cuboid selection.
+type RemoveSelection struct {
+	SelectionID byte
+}
+
+func (p *RemoveSelection) ID() byte          { return TypeRemoveSelection }
+func (p *RemoveSelection) encode(e *encoder) { e.byte(p.SelectionID) }
+func (p *RemoveSelection) decode(d *decoder) { p.SelectionID = d.byte() }
+
+// SetBlockPermission controls whether the player can place or delete a block.
+type SetBlockPermission struct {
+	Block          uint16
+	AllowPlacement bool
+	AllowDeletion  bool
+}
+
+func (p *SetBlockPermission) ID() byte { return TypeSetBlockPermission }
+
+func (p *SetBlockPermission) encode(e *encoder) {
+	e.block(p.Block)
+	e.bool(p.AllowPlacement)
+	e.bool(p.AllowDeletion)
+}
+
+func (p *SetBlockPermission) decode(d *decoder) {
+	p.Block = d.block()
+	p.AllowPlacement = d.bool()
+	p.AllowDeletion = d.bool()
+}
+
+// ChangeModel changes the model of an entity.
+type ChangeModel struct {
+	EntityID byte
+	Model    string
+}
+
+func (p *ChangeModel) ID() byte { return TypeChangeModel }
+
+func (p *ChangeModel) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.string(p.Model)
+}
+
+func (p *ChangeModel) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.Model = d.string()
+}
+
+// EnvSetWeatherType changes the weather.
+type EnvSetWeatherType struct {
+	Weather byte
+}
+
+func (p *EnvSetWeatherType) ID() byte          { return TypeEnvSetWeatherType }
+func (p *EnvSetWeatherType) encode(e *encoder) { e.byte(p.Weather) }
+func (p *EnvSetWeatherType) decode(d *decoder) { p.Weather = d.byte() }
+
+// HackControl restricts the client-side hacks. A negative JumpHeight resets
+// the jump height to the default.
+type HackControl struct {
+	Flying          bool
+	NoClip          bool
+	Speeding        bool
+	SpawnControl    bool
+	ThirdPersonView bool
+	JumpHeight      float64
+}
+
+func (p *HackControl) ID() byte { return TypeHackControl }
+
+func (p *HackControl) encode(e *encoder) {
+	e.bool(p.Flying)
+	e.bool(p.NoClip)
+	e.bool(p.Speeding)
+	e.bool(p.SpawnControl)
+	e.bool(p.ThirdPersonView)
+	if p.JumpHeight < 0 {
+		e.int16(-1)
+	} else {
+		e.int16(int16(p.JumpHeight * 32))
+	}
+}
+
+func (p *HackControl) decode(d *decoder) {
+	p.Flying = d.bool()
+	p.NoClip = d.bool()
+	p.Speeding = d.bool()
+	p.SpawnControl = d.bool()
+	p.ThirdPersonView = d.bool()
+	if height := d.int16(); height < 0 {
+		p.JumpHeight = -1
+	} else {
+		p.JumpHeight = float64(height) / 32
+	}
+}
+
+// ExtAddEntity2 spawns an entity with a skin.
+type ExtAddEntity2 struct {
+	EntityID    byte
+	DisplayName string
+	SkinName    string
+	Location    Location
+}
+
+func (p *ExtAddEntity2) ID() byte { return TypeExtAddEntity2 }
+
+func (p *ExtAddEntity2) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.string(p.DisplayName)
+	e.string(p.SkinName)
+	e.location(p.Location)
+}
+
+func (p *ExtAddEntity2) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.DisplayName = d.string()
+	p.SkinName = d.string()
+	p.Location = d.location()
+}
+
+// PlayerClicked is sent by the client when it clicks. Yaw and Pitch are
+// specified in degrees. TargetID is SelfID if no entity was clicked.
+type PlayerClicked struct {
+	Button, Action         byte
+	Yaw, Pitch             float64
+	TargetID               byte
+	BlockX, BlockY, BlockZ int16
+	BlockFace              byte
+}
+
+func (p *PlayerClicked) ID() byte { return TypePlayerClicked }
+
+func (p *PlayerClicked) encode(e *encoder) {
+	e.byte(p.Button)
+	e.byte(p.Action)
+	e.int16(int16(int(p.Yaw * 65536 / 360)))
+	e.int16(int16(int(p.Pitch * 65536 / 360)))
+	e.byte(p.TargetID)
+	e.int16(p.BlockX)
+	e.int16(p.BlockY)
+	e.int16(p.BlockZ)
+	e.byte(p.BlockFace)
+}
+
+func (p *PlayerClicked) decode(d *decoder) {
+	p.Button = d.byte()
+	p.Action = d.byte()
+	p.Yaw = float64(d.int16()) * 360 / 65536
+	p.Pitch = float64(d.int16()) * 360 / 65536
+	p.TargetID = d.byte()
+	p.BlockX = d.int16()
+	p.BlockY = d.int16()
+	p.BlockZ = d.int16()
+	p.BlockFace = d.byte()
+}
+
+// DefineBlock defines a custom block.
+type DefineBlock struct {
+	Block          uint16
+	Name           string
+	CollideMode    byte
+	Speed          float64
+	TopTexture     uint16
+	SideTexture    uint16
+	BottomTexture  uint16
+	TransmitsLight bool
+	WalkSound      byte
+	FullBright     bool
+	Shape          byte
+	DrawMode       byte
+	FogDensity     byte
+	FogR           byte
+	FogG           byte
+	FogB           byte
+}
+
+func (p *DefineBlock) ID() byte { return TypeDefineBlock }
+
+func (p *DefineBlock) encode(e *encoder) {
+	e.block(p.Block)
+	e.string(p.Name)
+	e.byte(p.CollideMode)
+	e.byte(encodeSpeed(p.Speed))
+	e.texture(p.TopTexture)
+	e.texture(p.SideTexture)
+	e.texture(p.BottomTexture)
+	e.bool(p.TransmitsLight)
+	e.byte(p.WalkSound)
+	e.bool(p.FullBright)
+	e.byte(p.Shape)
+	e.byte(p.DrawMode)
+	e.byte(p.FogDensity)
+	e.byte(p.FogR)
+	e.byte(p.FogG)
+	e.byte(p.FogB)
+}
+
+func (p *DefineBlock) decode(d *decoder) {
+	p.Block = d.block()
+	p.Name = d.string()
+	p.CollideMode = d.byte()
+	p.Speed = decodeSpeed(d.byte())
+	p.TopTexture = d.texture()
+	p.SideTexture = d.texture()
+	p.BottomTexture = d.texture()
+	p.TransmitsLight = d.bool()
+	p.WalkSound = d.byte()
+	p.FullBright = d.bool()
+	p.Shape = d.byte()
+	p.DrawMode = d.byte()
+	p.FogDensity = d.byte()
+	p.FogR = d.byte()
+	p.FogG = d.byte()
+	p.FogB = d.byte()
+}
+
+// RemoveBlockDefinition removes a custom block.
+type RemoveBlockDefinition struct {
+	Block uint16
+}
+
+func (p *RemoveBlockDefinition) ID() byte          { return TypeRemoveBlockDefinition }
+func (p *RemoveBlockDefinition) encode(e *encoder) { e.block(p.Block) }
+func (p *RemoveBlockDefinition) decode(d *decoder) { p.Block = d.block() }
+
+// DefineBlockExt defines a custom block with a custom bounding box and a
+// texture for every face.
+type DefineBlockExt struct {
+	Block            uint16
+	Name             string
+	CollideMode      byte
+	Speed            float64
+	TopTexture       uint16
+	LeftTexture      uint16
+	RightTexture     uint16
+	FrontTexture     uint16
+	BackTexture      uint16
+	BottomTexture    uint16
+	TransmitsLight   bool
+	WalkSound        byte
+	FullBright       bool
+	MinX, MinY, MinZ byte
+	MaxX, MaxY, MaxZ byte
+	DrawMode         byte
+	FogDensity       byte
+	FogR             byte
+	FogG             byte
+	FogB             byte
+}
+
+func (p *DefineBlockExt) ID() byte { return TypeDefineBlockExt }
+
+func (p *DefineBlockExt) encode(e *encoder) {
+	e.block(p.Block)
+	e.string(p.Name)
+	e.byte(p.CollideMode)
+	e.byte(encodeSpeed(p.Speed))
+	e.texture(p.TopTexture)
+	e.texture(p.LeftTexture)
+	e.texture(p.RightTexture)
+	e.texture(p.FrontTexture)
+	e.texture(p.BackTexture)
+	e.texture(p.BottomTexture)
+	e.bool(p.TransmitsLight)
+	e.byte(p.WalkSound)
+	e.bool(p.FullBright)
+	e.byte(p.MinX)
+	e.byte(p.MinY)
+	e.byte(p.MinZ)
+	e.byte(p.MaxX)
+	e.byte(p.MaxY)
+	e.byte(p.MaxZ)
+	e.byte(p.DrawMode)
+	e.byte(p.FogDensity)
+	e.byte(p.FogR)
+	e.byte(p.FogG)
+	e.byte(p.FogB)
+}
+
+func (p *DefineBlockExt) decode(d *decoder) {
+	p.Block = d.block()
+	p.Name = d.string()
+	p.CollideMode = d.byte()
+	p.Speed = decodeSpeed(d.byte())
+	p.TopTexture = d.texture()
+	p.LeftTexture = d.texture()
+	p.RightTexture = d.texture()
+	p.FrontTexture = d.texture()
+	p.BackTexture = d.texture()
+	p.BottomTexture = d.texture()
+	p.TransmitsLight = d.bool()
+	p.WalkSound = d.byte()
+	p.FullBright = d.bool()
+	p.MinX = d.byte()
+	p.MinY = d.byte()
+	p.MinZ = d.byte()
+	p.MaxX = d.byte()
+	p.MaxY = d.byte()
+	p.MaxZ = d.byte()
+	p.DrawMode = d.byte()
+	p.FogDensity = d.byte()
+	p.FogR = d.byte()
+	p.FogG = d.byte()
+	p.FogB = d.byte()
+}
+
+// BulkBlockUpdate changes up to BulkBlockUpdateSize blocks at once. Indices
+// and Blocks must have the same length.
+type BulkBlockUpdate struct {
+	Indices []int32
+	Blocks  []uint16
+}
+
+func (p *BulkBlockUpdate) ID() byte { return TypeBulkBlockUpdate }
+
+func (p *BulkBlockUpdate) encode(e *encoder) {
+	// The count is sent minus one, so that a full packet of 256 blocks
+	// fits in a byte.
+	e.byte(byte(len(p.Indices) - 1))
+	for i := 0; i < BulkBlockUpdateSize; i++ {
+		if i < len(p.Indices) {
+			e.int32(p.Indices[i])
+		} else {
+			e.int32(0)
+		}
+	}
+
+	for i := 0; i < BulkBlockUpdateSize; i++ {
+		if i < len(p.Blocks) {
+			e.byte(byte(p.Blocks[i]))
+		} else {
+			e.byte(0)
+		}
+	}
+
+	if e.codec.ExtBlocks {
+		var high [BulkBlockUpdateSize / 4]byte
+		for i, block := range p.Blocks {
+			high[i/4] |= byte(block>>8) << uint(i%4*2)
+		}
+
+		e.bytes(high[:], len(high))
+	}
+}
+
+func (p *BulkBlockUpdate) decode(d *decoder) {
+	count := int(d.byte()) + 1
+	p.Indices = make([]int32, count)
+	for i := 0; i < BulkBlockUpdateSize; i++ {
+		index := d.int32()
+		if i < count {
+			p.Indices[i] = index
+		}
+	}
+
+	p.Blocks = make([]uint16, count)
+	for i := 0; i < BulkBlockUpdateSize; i++ {
+		block := d.byte()
+		if i < count {
+			p.Blocks[i] = uint16(block)
+		}
+	}
+
+	if d.codec.ExtBlocks {
+		high := d.bytes(BulkBlockUpdateSize / 4)
+		for i := range p.Blocks {
+			p.Blocks[i] |= uint16(high[i/4]>>uint(i%4*2)&3) << 8
+		}
+	}
+}
+
+// SetTextColor defines a custom color code.
+type SetTextColor struct {
+	R, G, B, A byte
+	Code       byte
+}
+
+func (p *SetTextColor) ID() byte { return TypeSetTextColor }
+
+func (p *SetTextColor) encode(e *encoder) {
+	e.byte(p.R)
+	e.byte(p.G)
+	e.byte(p.B)
+	e.byte(p.A)
+	e.byte(p.Code)
+}
+
+func (p *SetTextColor) decode(d *decoder) {
+	p.R = d.byte()
+	p.G = d.byte()
+	p.B = d.byte()
+	p.A = d.byte()
+	p.Code = d.byte()
+}
+
+// SetMapEnvURL changes the texture pack.
+type SetMapEnvURL struct {
+	URL string
+}
+
+func (p *SetMapEnvURL) ID() byte          { return TypeSetMapEnvURL }
+func (p *SetMapEnvURL) encode(e *encoder) { e.string(p.URL) }
+func (p *SetMapEnvURL) decode(d *decoder) { p.URL = d.string() }
+
+// SetMapEnvProperty changes an environment property.
+type SetMapEnvProperty struct {
+	Property byte
+	Value    int32
+}
+
+func (p *SetMapEnvProperty) ID() byte { return TypeSetMapEnvProperty }
+
+func (p *SetMapEnvProperty) encode(e *encoder) {
+	e.byte(p.Property)
+	e.int32(p.Value)
+}
+
+func (p *SetMapEnvProperty) decode(d *decoder) {
+	p.Property = d.byte()
+	p.Value = d.int32()
+}
+
+// SetEntityProperty changes a property of an entity.
+type SetEntityProperty struct {
+	EntityID byte
+	Property byte
+	Value    int32
+}
+
+func (p *SetEntityProperty) ID() byte { return TypeSetEntityProperty }
+
+func (p *SetEntityProperty) encode(e *encoder) {
+	e.byte(p.EntityID)
+	e.byte(p.Property)
+	e.int32(p.Value)
+}
+
+func (p *SetEntityProperty) decode(d *decoder) {
+	p.EntityID = d.byte()
+	p.Property = d.byte()
+	p.Value = d.int32()
+}
+
+// TwoWayPing measures the latency of the connection. Direction is 0 for pings
+// started by the client and 1 for pings started by the server.
+type TwoWayPing struct {
+	Direction byte
+	Data      int16
+}
+
+func (p *TwoWayPing) ID() byte { return TypeTwoWayPing }
+
+func (p *TwoWayPing) encode(e *encoder) {
+	e.byte(p.Direction)
+	e.int16(p.Data)
+}
+
+func (p *TwoWayPing) decode(d *decoder) {
+	p.Direction = d.byte()
+	p.Data = d.int16()
+}
+
+// SetInventoryOrder moves Block to position Order in the inventory.
+type SetInventoryOrder struct {
+	Order uint16
+	Block uint16
+}
+
+func (p *SetInventoryOrder) ID() byte { return TypeSetInventoryOrder }
+
+func (p *SetInventoryOrder) encode(e *encoder) {
+	e.block(p.Order)
+	e.block(p.Block)
+}
+
+func (p *SetInventoryOrder) decode(d *decoder) {
+	p.Order = d.block()
+	p.Block = d.block()
+}
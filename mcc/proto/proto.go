@@ -0,0 +1,329 @@
+// Package proto implements the Minecraft Classic protocol and the Classic
+// Protocol Extension (CPE) packets.
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownPacket is returned when a packet ID is not valid in the direction
+// being decoded.
+var ErrUnknownPacket = errors.New("proto: unknown packet")
+
+// ErrInvalidSize is returned when the data passed to Decode does not match the
+// size of the packet.
+var ErrInvalidSize = errors.New("proto: invalid packet size")
+
+// ErrUnsupportedVersion is returned when a client identifies with a protocol
+// version other than Version5, Version6 and Version7.
+var ErrUnsupportedVersion = errors.New("proto: unsupported protocol version")
+
+// Direction specifies who sends a packet.
+type Direction int
+
+const (
+	// ServerBound packets are sent by the client to the server.
+	ServerBound Direction = 0
+	// ClientBound packets are sent by the server to the client.
+	ClientBound Direction = 1
+)
+
+// Supported protocol versions.
+const (
+	Version5 = 0x05 // Classic 0.0.19a
+	Version6 = 0x06 // Classic 0.0.20a - 0.0.23a
+	Version7 = 0x07 // Classic 0.28 - 0.30
+)
+
+// Location represents the location of an entity. Yaw and Pitch are specified
+// in degrees.
+type Location struct {
+	X, Y, Z, Yaw, Pitch float64
+}
+
+// Packet is the interface implemented by all packet types.
+type Packet interface {
+	// ID returns the packet ID.
+	ID() byte
+
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+// Codec encodes and decodes packets according to the protocol version and
+// the extensions that were negotiated for a connection.
+type Codec struct {
+	Version byte
+
+	ExtPositions bool // ExtEntityPositions
+	ExtBlocks    bool // ExtendedBlocks
+	ExtTextures  bool // ExtendedTextures
+	FastMap      bool // FastMap
+}
+
+// NewCodec returns a new Codec for the latest protocol version, without any
+// extensions.
+func NewCodec() *Codec {
+	return &Codec{Version: Version7}
+}
+
+// Encode appends the encoding of packet to buf and returns the extended
+// buffer.
+func (codec *Codec) Encode(buf []byte, packet Packet) []byte {
+	e := encoder{codec, buf}
+	e.byte(packet.ID())
+	packet.encode(&e)
+	return e.buf
+}
+
+// Decode decodes a packet that was sent in the specified direction. data must
+// contain exactly one packet, including the packet ID.
+func (codec *Codec) Decode(data []byte, direction Direction) (Packet, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidSize
+	}
+
+	packet := NewPacket(data[0], direction)
+	if packet == nil {
+		return nil, ErrUnknownPacket
+	}
+
+	if len(data) != codec.PacketSize(data[0], direction) {
+		return nil, ErrInvalidSize
+	}
+
+	d := decoder{codec, data[1:]}
+	packet.decode(&d)
+	return packet, nil
+}
+
+// PacketSize returns the size of the packet with the specified ID, including
+// the packet ID. If the packet or the protocol version is unknown, the
+// function returns 0.
+func (codec *Codec) PacketSize(id byte, direction Direction) int {
+	if sizes := codec.sizes(direction); sizes != nil {
+		return sizes[id]
+	}
+
+	return 0
+}
+
+// sizeKey identifies a table of packet sizes. The layout of the packets only
+// depends on the fields of the codec.
+type sizeKey struct {
+	codec     Codec
+	direction Direction
+}
+
+// packetSizes caches the tables of packet sizes for each codec.
+var packetSizes sync.Map
+
+// sizes returns the size of every packet with the layout of codec, or nil if
+// the protocol version is not supported. The tables are computed once and
+// cached.
+func (codec *Codec) sizes(direction Direction) *[256]int {
+	if !supportedVersion(codec.Version) {
+		return nil
+	}
+
+	key := sizeKey{*codec, direction}
+	if sizes, ok := packetSizes.Load(key); ok {
+		return sizes.(*[256]int)
+	}
+
+	sizes := new([256]int)
+	for id := range sizes {
+		if packet := NewPacket(byte(id), direction); packet != nil {
+			sizes[id] = len(codec.Encode(nil, packet))
+		}
+	}
+
+	packetSizes.Store(key, sizes)
+	return sizes
+}
+
+func supportedVersion(version byte) bool {
+	return version >= Version5 && version <= Version7
+}
+
+func padString(str string) []byte {
+	result := bytes.Repeat([]byte{' '}, 64)
+	copy(result, str)
+	return result
+}
+
+func trimString(str []byte) string {
+	return strings.TrimRight(string(str), " ")
+}
+
+type encoder struct {
+	codec *Codec
+	buf   []byte
+}
+
+func (e *encoder) byte(v byte) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = append(e.buf, 0, 0)
+	binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], uint16(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], uint32(v))
+}
+
+func (e *encoder) string(v string) {
+	e.buf = append(e.buf, padString(v)...)
+}
+
+func (e *encoder) bytes(v []byte, size int) {
+	start := len(e.buf)
+	e.buf = append(e.buf, make([]byte, size)...)
+	copy(e.buf[start:], v)
+}
+
+func (e *encoder) block(v uint16) {
+	if e.codec.ExtBlocks {
+		e.int16(int16(v))
+	} else {
+		e.byte(byte(v))
+	}
+}
+
+func (e *encoder) texture(v uint16) {
+	if e.codec.ExtTextures {
+		e.int16(int16(v))
+	} else {
+		e.byte(byte(v))
+	}
+}
+
+func (e *encoder) coord(v float64) {
+	if e.codec.ExtPositions {
+		e.int32(int32(v * 32))
+	} else {
+		e.int16(int16(v * 32))
+	}
+}
+
+func (e *encoder) delta(v float64) {
+	e.byte(byte(int8(v * 32)))
+}
+
+func (e *encoder) angle(v float64) {
+	e.byte(byte(int(v * 256 / 360)))
+}
+
+func (e *encoder) location(v Location) {
+	e.coord(v.X)
+	e.coord(v.Y)
+	e.coord(v.Z)
+	e.angle(v.Yaw)
+	e.angle(v.Pitch)
+}
+
+type decoder struct {
+	codec *Codec
+	data  []byte
+}
+
+func (d *decoder) next(n int) []byte {
+	if len(d.data) < n {
+		d.data = make([]byte, n)
+	}
+
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	return d.next(1)[0]
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) int16() int16 {
+	return int16(binary.BigEndian.Uint16(d.next(2)))
+}
+
+func (d *decoder) int32() int32 {
+	return int32(binary.BigEndian.Uint32(d.next(4)))
+}
+
+func (d *decoder) string() string {
+	return trimString(d.next(64))
+}
+
+func (d *decoder) bytes(size int) []byte {
+	v := make([]byte, size)
+	copy(v, d.next(size))
+	return v
+}
+
+func (d *decoder) block() uint16 {
+	if d.codec.ExtBlocks {
+		return uint16(d.int16())
+	}
+
+	return uint16(d.byte())
+}
+
+func (d *decoder) texture() uint16 {
+	if d.codec.ExtTextures {
+		return uint16(d.int16())
+	}
+
+	return uint16(d.byte())
+}
+
+func (d *decoder) coord() float64 {
+	if d.codec.ExtPositions {
+		return float64(d.int32()) / 32
+	}
+
+	return float64(d.int16()) / 32
+}
+
+func (d *decoder) delta() float64 {
+	return float64(int8(d.byte())) / 32
+}
+
+func (d *decoder) angle() float64 {
+	return float64(d.byte()) * 360 / 256
+}
+
+func (d *decoder) location() (v Location) {
+	v.X = d.coord()
+	v.Y = d.coord()
+	v.Z = d.coord()
+	v.Yaw = d.angle()
+	v.Pitch = d.angle()
+	return
+}
+
+func encodeSpeed(speed float64) byte {
+	return byte(64*math.Log2(speed) + 128)
+}
+
+func decodeSpeed(v byte) float64 {
+	return math.Exp2((float64(v) - 128) / 64)
+}
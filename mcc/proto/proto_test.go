@@ -0,0 +1,219 @@
+package proto
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// identification returns the encoding of an identification packet, with
+// the trailing byte if it is not negative.
+func identification(version byte, s1, s2 string, last int) []byte {
+	data := []byte{TypeIdentification, version}
+	data = append(data, padString(s1)...)
+	data = append(data, padString(s2)...)
+	if last >= 0 {
+		data = append(data, byte(last))
+	}
+
+	return data
+}
+
+func TestIdentificationLayout(t *testing.T) {
+	tests := []struct {
+		version byte
+		last    int
+	}{
+		{Version5, -1},
+		{Version6, -1},
+		{Version7, 0x64},
+	}
+
+	for _, test := range tests {
+		codec := &Codec{Version: test.version}
+
+		server := identification(test.version, "Server", "MOTD", test.last)
+		packet := &Identification{test.version, "Server", "MOTD", 0x64}
+		if data := codec.Encode(nil, packet); !bytes.Equal(data, server) {
+			t.Errorf("version %d: Identification encoded as % x, want % x", test.version, data, server)
+		}
+
+		client := identification(test.version, "Player", "key", test.last)
+		reader := NewReader(bytes.NewReader(client), NewCodec(), ServerBound)
+		decoded, err := reader.ReadPacket()
+		if err != nil {
+			t.Errorf("version %d: ReadPacket: %s", test.version, err)
+			continue
+		}
+
+		want := &IdentificationClient{test.version, "Player", "key", 0}
+		if test.last >= 0 {
+			want.Type = byte(test.last)
+		}
+
+		if *decoded.(*IdentificationClient) != *want {
+			t.Errorf("version %d: IdentificationClient decoded as %+v, want %+v", test.version, decoded, want)
+		}
+
+		if reader.Size() != len(client) {
+			t.Errorf("version %d: size is %d, want %d", test.version, reader.Size(), len(client))
+		}
+	}
+}
+
+// bulkBlockUpdate returns the encoding of a BulkBlockUpdate that sets the
+// block at index 0x0102 to 0x12a.
+func bulkBlockUpdate(extBlocks bool) []byte {
+	data := make([]byte, 2+4*BulkBlockUpdateSize+BulkBlockUpdateSize)
+	data[0] = TypeBulkBlockUpdate
+	data[1] = 0
+	data[2], data[3], data[4], data[5] = 0x00, 0x00, 0x01, 0x02
+	data[2+4*BulkBlockUpdateSize] = 0x2a
+	if extBlocks {
+		high := make([]byte, BulkBlockUpdateSize/4)
+		high[0] = 0x01
+		data = append(data, high...)
+	}
+
+	return data
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	location := Location{X: 1.5, Y: 2, Z: -1, Yaw: 90, Pitch: 180}
+	tests := []struct {
+		name      string
+		codec     Codec
+		direction Direction
+		packet    Packet
+		data      []byte
+	}{
+		{"Ping", Codec{Version: Version7}, ClientBound,
+			&Ping{},
+			[]byte{0x01}},
+		{"LevelInitialize", Codec{Version: Version7}, ClientBound,
+			&LevelInitialize{},
+			[]byte{0x02}},
+		{"LevelInitializeFastMap", Codec{Version: Version7, FastMap: true}, ClientBound,
+			&LevelInitialize{Size: 0x01020304},
+			[]byte{0x02, 0x01, 0x02, 0x03, 0x04}},
+		{"LevelFinalize", Codec{Version: Version7}, ClientBound,
+			&LevelFinalize{X: 64, Y: 32, Z: 256},
+			[]byte{0x04, 0x00, 0x40, 0x00, 0x20, 0x01, 0x00}},
+		{"SetBlockClient", Codec{Version: Version7}, ServerBound,
+			&SetBlockClient{X: 1, Y: 2, Z: 3, Mode: 1, Block: 0x2a},
+			[]byte{0x05, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x01, 0x2a}},
+		{"SetBlock", Codec{Version: Version7}, ClientBound,
+			&SetBlock{X: 1, Y: 2, Z: 3, Block: 0x2a},
+			[]byte{0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x2a}},
+		{"SetBlockExtBlocks", Codec{Version: Version7, ExtBlocks: true}, ClientBound,
+			&SetBlock{X: 1, Y: 2, Z: 3, Block: 0x12a},
+			[]byte{0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x01, 0x2a}},
+		{"PlayerTeleport", Codec{Version: Version7}, ClientBound,
+			&PlayerTeleport{EntityID: 5, Location: location},
+			[]byte{0x08, 0x05, 0x00, 0x30, 0x00, 0x40, 0xff, 0xe0, 0x40, 0x80}},
+		{"PlayerTeleportExtPositions", Codec{Version: Version7, ExtPositions: true}, ClientBound,
+			&PlayerTeleport{EntityID: 5, Location: location},
+			[]byte{0x08, 0x05,
+				0x00, 0x00, 0x00, 0x30, 0x00, 0x00, 0x00, 0x40, 0xff, 0xff, 0xff, 0xe0,
+				0x40, 0x80}},
+		{"RemoveEntity", Codec{Version: Version7}, ClientBound,
+			&RemoveEntity{EntityID: 7},
+			[]byte{0x0c, 0x07}},
+		{"TwoWayPing", Codec{Version: Version7}, ClientBound,
+			&TwoWayPing{Direction: 1, Data: 0x1234},
+			[]byte{0x2b, 0x01, 0x12, 0x34}},
+		{"SetInventoryOrderExtBlocks", Codec{Version: Version7, ExtBlocks: true}, ClientBound,
+			&SetInventoryOrder{Order: 1, Block: 0x12a},
+			[]byte{0x2c, 0x00, 0x01, 0x01, 0x2a}},
+		{"BulkBlockUpdate", Codec{Version: Version7}, ClientBound,
+			&BulkBlockUpdate{Indices: []int32{0x0102}, Blocks: []uint16{0x2a}},
+			bulkBlockUpdate(false)},
+		{"BulkBlockUpdateExtBlocks", Codec{Version: Version7, ExtBlocks: true}, ClientBound,
+			&BulkBlockUpdate{Indices: []int32{0x0102}, Blocks: []uint16{0x12a}},
+			bulkBlockUpdate(true)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec := test.codec
+			if data := codec.Encode(nil, test.packet); !bytes.Equal(data, test.data) {
+				t.Errorf("encoded as % x, want % x", data, test.data)
+			}
+
+			if size := codec.PacketSize(test.packet.ID(), test.direction); size != len(test.data) {
+				t.Errorf("size is %d, want %d", size, len(test.data))
+			}
+
+			packet, err := codec.Decode(test.data, test.direction)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(packet, test.packet) {
+				t.Errorf("decoded as %+v, want %+v", packet, test.packet)
+			}
+		})
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	codec := NewCodec()
+	if _, err := codec.Decode([]byte{0x06, 0x00}, ClientBound); err != ErrInvalidSize {
+		t.Errorf("short packet: got error %v, want %v", err, ErrInvalidSize)
+	}
+
+	if _, err := codec.Decode([]byte{0xff}, ClientBound); err != ErrUnknownPacket {
+		t.Errorf("unknown packet: got error %v, want %v", err, ErrUnknownPacket)
+	}
+
+	if _, err := codec.Decode(nil, ClientBound); err != ErrInvalidSize {
+		t.Errorf("empty packet: got error %v, want %v", err, ErrInvalidSize)
+	}
+}
+
+func TestReader(t *testing.T) {
+	stream := []byte{
+		0x01,
+		0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x2a,
+		0x0c, 0x07,
+	}
+
+	reader := NewReader(bytes.NewReader(stream), NewCodec(), ClientBound)
+	want := []Packet{
+		&Ping{},
+		&SetBlock{X: 1, Y: 2, Z: 3, Block: 0x2a},
+		&RemoveEntity{EntityID: 7},
+	}
+
+	for _, packet := range want {
+		got, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, packet) {
+			t.Errorf("read %+v, want %+v", got, packet)
+		}
+	}
+
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Errorf("got error %v at the end of the stream, want %v", err, io.EOF)
+	}
+
+	reader = NewReader(bytes.NewReader([]byte{0x06, 0x00}), NewCodec(), ClientBound)
+	if _, err := reader.ReadPacket(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated packet: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	reader = NewReader(bytes.NewReader([]byte{0xff}), NewCodec(), ClientBound)
+	if _, err := reader.ReadPacket(); err != ErrUnknownPacket {
+		t.Errorf("unknown packet: got error %v, want %v", err, ErrUnknownPacket)
+	}
+
+	ident := identification(0x08, "Player", "key", 0)
+	reader = NewReader(bytes.NewReader(ident), NewCodec(), ServerBound)
+	if _, err := reader.ReadPacket(); err != ErrUnsupportedVersion {
+		t.Errorf("version 8: got error %v, want %v", err, ErrUnsupportedVersion)
+	}
+}
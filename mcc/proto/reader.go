@@ -0,0 +1,95 @@
+package proto
+
+import (
+	"io"
+)
+
+// Reader splits a stream into packets.
+type Reader struct {
+	r         io.Reader
+	codec     *Codec
+	direction Direction
+	buf       []byte
+	size      int
+}
+
+// NewReader returns a new Reader that reads packets sent in the specified
+// direction from r. Changes to codec affect the following packets.
+func NewReader(r io.Reader, codec *Codec, direction Direction) *Reader {
+	return &Reader{r: r, codec: codec, direction: direction}
+}
+
+// Size returns the size of the last packet that was read, including the
+// packet ID.
+func (reader *Reader) Size() int {
+	return reader.size
+}
+
+// ReadRaw reads the next packet without decoding it. The returned slice is
+// only valid until the next call.
+func (reader *Reader) ReadRaw() ([]byte, error) {
+	buf, _, err := reader.read()
+	return buf, err
+}
+
+// ReadPacket reads and decodes the next packet.
+func (reader *Reader) ReadPacket() (Packet, error) {
+	buf, codec, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Decode(buf, reader.direction)
+}
+
+// read reads the next packet and returns the codec that describes its
+// layout.
+func (reader *Reader) read() ([]byte, *Codec, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(reader.r, head[:1]); err != nil {
+		return nil, nil, err
+	}
+
+	codec, n := reader.codec, 1
+	if head[0] == TypeIdentification && reader.direction == ServerBound {
+		// The layout of the identification depends on the protocol
+		// version of the client, which is its first field.
+		if _, err := io.ReadFull(reader.r, head[1:]); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+
+		if !supportedVersion(head[1]) {
+			return nil, nil, ErrUnsupportedVersion
+		}
+
+		versioned := *reader.codec
+		versioned.Version = head[1]
+		codec, n = &versioned, 2
+	}
+
+	size := codec.PacketSize(head[0], reader.direction)
+	if size == 0 {
+		return nil, nil, ErrUnknownPacket
+	}
+
+	if cap(reader.buf) < size {
+		reader.buf = make([]byte, size)
+	}
+
+	buf := reader.buf[:size]
+	copy(buf, head[:n])
+	if _, err := io.ReadFull(reader.r, buf[n:]); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+
+	reader.size = size
+	return buf, codec, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
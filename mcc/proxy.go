@@ -0,0 +1,214 @@
+package mcc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	proxyV1MaxLength = 107
+
+	// proxyV2MaxLength is the maximum length of the address block of a
+	// version 2 header. Together with the fixed header, it fits in the 536
+	// bytes that the specification recommends.
+	proxyV2MaxLength = 536 - 16
+
+	proxyCommandLocal = 0x0
+	proxyCommandProxy = 0x1
+
+	proxyFamilyUnspec = 0x0
+	proxyFamilyInet   = 0x1
+	proxyFamilyInet6  = 0x2
+	proxyFamilyUnix   = 0x3
+
+	proxyTransportUnspec = 0x0
+	proxyTransportStream = 0x1
+)
+
+var proxyV2Signature = []byte{
+	0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a,
+}
+
+// parseNetworks parses a list of IP addresses and CIDR networks.
+func parseNetworks(list []string) (networks []*net.IPNet, err error) {
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("proxy: invalid address " + entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return
+}
+
+// isTrustedProxy reports whether addr belongs to one of the trusted proxy
+// networks.
+func isTrustedProxy(addr net.Addr, networks []*net.IPNet) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readProxyHeader reads a PROXY protocol header (version 1 or 2) from reader
+// and returns the source address of the proxied connection. If the proxy does
+// not report an address, the returned address is nil.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(signature, proxyV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyHeaderV1(reader)
+	}
+
+	return nil, errors.New("proxy: missing header")
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("proxy: header too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy: invalid header")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("proxy: invalid header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || strings.Contains(fields[2], ":") != (fields[1] == "TCP6") {
+		return nil, errors.New("proxy: invalid source address")
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.New("proxy: invalid source port")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, errors.New("proxy: unsupported version")
+	}
+
+	length := binary.BigEndian.Uint16(header[14:])
+	if length > proxyV2MaxLength {
+		return nil, errors.New("proxy: header too long")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+
+	switch header[12] & 0x0f {
+	case proxyCommandLocal:
+		// The connection was made by the proxy itself, and the address
+		// block must be ignored.
+		return nil, nil
+	case proxyCommandProxy:
+	default:
+		return nil, errors.New("proxy: invalid command")
+	}
+
+	family, transport := header[13]>>4, header[13]&0x0f
+	switch {
+	case family > proxyFamilyUnix:
+		return nil, errors.New("proxy: invalid address family")
+	case transport > proxyTransportStream:
+		return nil, errors.New("proxy: unsupported transport")
+	case family == proxyFamilyUnspec || transport == proxyTransportUnspec:
+		return nil, nil
+	}
+
+	var ip net.IP
+	var port uint16
+	switch family {
+	case proxyFamilyInet:
+		if len(data) < 12 {
+			return nil, errors.New("proxy: invalid address block")
+		}
+
+		ip = net.IP(data[0:4])
+		port = binary.BigEndian.Uint16(data[8:])
+
+	case proxyFamilyInet6:
+		if len(data) < 36 {
+			return nil, errors.New("proxy: invalid address block")
+		}
+
+		ip = net.IP(data[0:16])
+		port = binary.BigEndian.Uint16(data[32:])
+
+	default:
+		// Unix sockets have no address that could be reported.
+		return nil, nil
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,97 @@
+package mcc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// proxyV2 returns a version 2 header with the specified command, family and
+// address block.
+func proxyV2(command, family byte, data []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(data)))
+	return append(header, data...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tcp4 := []byte{
+		192, 0, 2, 1, // source address
+		192, 0, 2, 2, // destination address
+		0x30, 0x39, 0x63, 0xdd, // source and destination ports
+	}
+
+	tcp6 := make([]byte, 36)
+	copy(tcp6, []byte{0x20, 0x01, 0x0d, 0xb8})
+	tcp6[15] = 1
+	binary.BigEndian.PutUint16(tcp6[32:], 12345)
+
+	oversized := proxyV2(proxyCommandProxy, 0x11, tcp4)
+	binary.BigEndian.PutUint16(oversized[14:], 0xffff)
+
+	badVersion := proxyV2(proxyCommandProxy, 0x11, tcp4)
+	badVersion[12] = 0x11
+
+	tests := []struct {
+		name  string
+		input []byte
+		addr  string
+		err   string
+	}{
+		{"V1TCP4", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 12345 25565\r\n"), "192.0.2.1:12345", ""},
+		{"V1TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 25565\r\n"), "[2001:db8::1]:12345", ""},
+		{"V1Unknown", []byte("PROXY UNKNOWN\r\n"), "", ""},
+		{"V1UnknownAddresses", []byte("PROXY UNKNOWN ::1 ::1 1 2\r\n"), "", ""},
+		{"V1Truncated", []byte("PROXY TCP4 192.0.2.1 192.0"), "", "EOF"},
+		{"V1MissingCR", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 12345 25565\n"), "", "invalid header"},
+		{"V1TooLong", []byte("PROXY TCP4 " + strings.Repeat("1", 128) + "\r\n"), "", "header too long"},
+		{"V1UDP", []byte("PROXY UDP4 192.0.2.1 192.0.2.2 12345 25565\r\n"), "", "invalid header"},
+		{"V1FamilyMismatch", []byte("PROXY TCP4 2001:db8::1 2001:db8::2 12345 25565\r\n"), "", "invalid source address"},
+		{"V1BadPort", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 123456 25565\r\n"), "", "invalid source port"},
+		{"V2TCP4", proxyV2(proxyCommandProxy, 0x11, tcp4), "192.0.2.1:12345", ""},
+		{"V2TCP6", proxyV2(proxyCommandProxy, 0x21, tcp6), "[2001:db8::1]:12345", ""},
+		{"V2TLVs", proxyV2(proxyCommandProxy, 0x11, append(tcp4, 0x04, 0x00, 0x01, 0x00)), "192.0.2.1:12345", ""},
+		{"V2Local", proxyV2(proxyCommandLocal, 0x00, nil), "", ""},
+		{"V2LocalBadFamily", proxyV2(proxyCommandLocal, 0xff, nil), "", ""},
+		{"V2Unspec", proxyV2(proxyCommandProxy, 0x00, nil), "", ""},
+		{"V2Unix", proxyV2(proxyCommandProxy, 0x31, make([]byte, 216)), "", ""},
+		{"V2Truncated", proxyV2(proxyCommandProxy, 0x11, tcp4)[:20], "", "EOF"},
+		{"V2ShortAddress", proxyV2(proxyCommandProxy, 0x21, tcp4), "", "invalid address block"},
+		{"V2Oversized", oversized, "", "header too long"},
+		{"V2BadVersion", badVersion, "", "unsupported version"},
+		{"V2BadCommand", proxyV2(0x2, 0x11, tcp4), "", "invalid command"},
+		{"V2BadFamily", proxyV2(proxyCommandProxy, 0x41, tcp4), "", "invalid address family"},
+		{"V2UDP", proxyV2(proxyCommandProxy, 0x12, tcp4), "", "unsupported transport"},
+		{"BadSignature", append([]byte("\r\n\r\n\x00\r\nQUIT\x00"), make([]byte, 16)...), "", "missing header"},
+		{"Empty", nil, "", "EOF"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(test.input)))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+
+			if got != test.addr {
+				t.Errorf("got address %q, want %q", got, test.addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mcc
+
+// Registrar registers commands, event handlers, tasks and metrics on behalf
+// of a plugin. Everything that is registered through it is owned by the
+// plugin, and is removed when the plugin is disabled. A plugin usually creates
+// its Registrar in Enable, and keeps it to register handlers and schedule
+// tasks later, such as from its commands.
+//
+// Commands, handlers, tasks and metrics that are registered directly on the
+// Server have no owner, and outlive the plugin that registered them.
+type Registrar struct {
+	server *Server
+	plugin Plugin
+}
+
+// Registrar returns a Registrar whose registrations are owned by plugin.
+func (server *Server) Registrar(plugin Plugin) *Registrar {
+	return &Registrar{server, plugin}
+}
+
+// Server returns the server of the registrar.
+func (registrar *Registrar) Server() *Server {
+	return registrar.server
+}
+
+// Plugin returns the plugin that owns the registrations.
+func (registrar *Registrar) Plugin() Plugin {
+	return registrar.plugin
+}
+
+// AddCommand registers the specified command.
+func (registrar *Registrar) AddCommand(command *Command) {
+	command.Owner = registrar.plugin
+	registrar.server.AddCommand(command)
+}
+
+// AddHandler registers a handler for the specified event type with normal
+// priority.
+func (registrar *Registrar) AddHandler(eventType int, handler EventHandler) *Handler {
+	return registrar.RegisterHandler(&Handler{
+		EventType: eventType,
+		Priority:  PriorityNormal,
+		Func:      handler,
+	})
+}
+
+// Subscribe registers fn with the specified priority. See Server.Subscribe.
+func (registrar *Registrar) Subscribe(priority int, fn interface{}) *Handler {
+	eventType, handler := HandlerFunc(fn)
+	return registrar.RegisterHandler(&Handler{
+		EventType: eventType,
+		Priority:  priority,
+		Func:      handler,
+	})
+}
+
+// RegisterHandler registers handler and returns it.
+func (registrar *Registrar) RegisterHandler(handler *Handler) *Handler {
+	handler.Owner = registrar.plugin
+	return registrar.server.RegisterHandler(handler)
+}
@@ -0,0 +1,200 @@
+package rpcplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// CommandFunc is the type of the function called to execute a command of a
+// plugin. The returned messages are sent to the sender.
+type CommandFunc func(sender, message string) []string
+
+// EventFunc is the type of the function called to handle an event. Handlers
+// of cancellable events can modify event.
+type EventFunc func(event *Event)
+
+// Client is used by a plugin process to communicate with the server.
+//
+// OnEnable is called when the plugin is enabled, and should register the
+// commands and event handlers of the plugin. OnDisable is called when the
+// plugin is disabled.
+type Client struct {
+	Info      Info
+	OnEnable  func(client *Client) error
+	OnDisable func(client *Client)
+
+	conn *conn
+
+	lock     sync.RWMutex
+	commands map[string]CommandFunc
+	handlers map[int]EventFunc
+	nextID   int
+}
+
+// NewClient returns a new Client for the plugin described by info.
+func NewClient(info Info) *Client {
+	return &Client{
+		Info:     info,
+		commands: make(map[string]CommandFunc),
+		handlers: make(map[int]EventFunc),
+	}
+}
+
+// Serve communicates with the server over the standard input and output of
+// the process, until the server closes the standard input.
+func (client *Client) Serve() error {
+	return client.ServeConn(os.Stdin, os.Stdout)
+}
+
+// ServeConn communicates with the server over r and w, until r is closed.
+func (client *Client) ServeConn(r io.Reader, w io.Writer) error {
+	client.conn = newConn(w, client.handle)
+	if err := client.conn.serve(r); err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+func (client *Client) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "plugin.init":
+		return client.Info, nil
+
+	case "plugin.enable":
+		if client.OnEnable != nil {
+			return nil, client.OnEnable(client)
+		}
+
+		return nil, nil
+
+	case "plugin.disable":
+		if client.OnDisable != nil {
+			client.OnDisable(client)
+		}
+
+		return nil, nil
+
+	case "plugin.command":
+		var call CommandCall
+		if err := json.Unmarshal(params, &call); err != nil {
+			return nil, err
+		}
+
+		client.lock.RLock()
+		fn := client.commands[call.Command]
+		client.lock.RUnlock()
+		if fn == nil {
+			return nil, fmt.Errorf("unknown command %s", call.Command)
+		}
+
+		return CommandResult{fn(call.Sender, call.Message)}, nil
+
+	case "plugin.event":
+		var call EventCall
+		if err := json.Unmarshal(params, &call); err != nil {
+			return nil, err
+		} else if call.Event == nil {
+			return nil, errors.New("missing event")
+		}
+
+		client.lock.RLock()
+		fn := client.handlers[call.Handler]
+		client.lock.RUnlock()
+		if fn != nil {
+			fn(call.Event)
+		}
+
+		return call.Event, nil
+	}
+
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+// Call sends a request to the server and decodes its result into result,
+// which can be nil.
+func (client *Client) Call(method string, params, result interface{}) error {
+	if client.conn == nil {
+		return ErrClosed
+	}
+
+	return client.conn.call(method, params, result, callTimeout)
+}
+
+// AddCommand registers a command that is executed by fn.
+func (client *Client) AddCommand(command Command, fn CommandFunc) error {
+	client.lock.Lock()
+	client.commands[command.Name] = fn
+	client.lock.Unlock()
+
+	return client.Call("server.addCommand", command, nil)
+}
+
+// RemoveCommand unregisters the command with the specified name.
+func (client *Client) RemoveCommand(name string) error {
+	client.lock.Lock()
+	delete(client.commands, name)
+	client.lock.Unlock()
+
+	return client.Call("server.removeCommand", NameParams{name}, nil)
+}
+
+// Subscribe registers fn as a handler for the specified event, with the
+// specified priority.
+func (client *Client) Subscribe(event string, priority int, fn EventFunc) error {
+	client.lock.Lock()
+	client.nextID++
+	id := client.nextID
+	client.handlers[id] = fn
+	client.lock.Unlock()
+
+	return client.Call("server.subscribe", Subscription{ID: id, Event: event, Priority: priority}, nil)
+}
+
+// Broadcast sends a message to all players.
+func (client *Client) Broadcast(message string) error {
+	return client.Call("server.broadcast", MessageParams{Message: message}, nil)
+}
+
+// SendMessage sends a message to a player.
+func (client *Client) SendMessage(player, message string) error {
+	return client.Call("player.sendMessage", MessageParams{player, message}, nil)
+}
+
+// Kick kicks a player with the specified reason.
+func (client *Client) Kick(player, reason string) error {
+	return client.Call("player.kick", MessageParams{player, reason}, nil)
+}
+
+// Teleport teleports a player.
+func (client *Client) Teleport(params TeleportParams) error {
+	return client.Call("player.teleport", params, nil)
+}
+
+// Players returns the online players.
+func (client *Client) Players() (players []PlayerInfo, err error) {
+	err = client.Call("server.players", struct{}{}, &players)
+	return
+}
+
+// Levels returns the loaded levels.
+func (client *Client) Levels() (levels []LevelInfo, err error) {
+	err = client.Call("server.levels", struct{}{}, &levels)
+	return
+}
+
+// GetBlock returns the block at the specified coordinates of a level.
+func (client *Client) GetBlock(level string, x, y, z int) (int, error) {
+	var result BlockParams
+	err := client.Call("level.getBlock", BlockParams{level, x, y, z, 0}, &result)
+	return result.Block, err
+}
+
+// SetBlock sets the block at the specified coordinates of a level.
+func (client *Client) SetBlock(level string, x, y, z, block int) error {
+	return client.Call("level.setBlock", BlockParams{level, x, y, z, block}, nil)
+}
@@ -0,0 +1,306 @@
+// Package rpcplugin runs plugins as separate processes that communicate with
+// the server over their standard input and output.
+//
+// Each message is a JSON object on a single line. A request contains a
+// method, its parameters and a non-zero id, which is echoed in the response
+// together with either a result or an error. A request with a zero id is a
+// notification and has no response. Both sides can send requests at any time.
+// At most 64 requests of each side are handled concurrently. Further requests
+// fail with an error, and further notifications are dropped.
+//
+// The server sends the following requests to the plugin:
+//
+//	plugin.init     Returns the Info of the plugin.
+//	plugin.enable   Enables the plugin, which registers its commands and
+//	                event handlers before responding.
+//	plugin.disable  Disables the plugin. The server then closes the standard
+//	                input of the plugin, which must exit.
+//	plugin.command  Executes a command of the plugin. See CommandCall.
+//	plugin.event    Dispatches an event to a handler. See EventCall.
+//	                Cancellable events are requests and the modified Event is
+//	                returned. The rest, and the events that are fired on the
+//	                update loop, are notifications.
+//
+// The plugin can send the following requests to the server:
+//
+//	server.addCommand     Registers a command. See Command.
+//	server.removeCommand  Unregisters a command. See NameParams.
+//	server.subscribe      Registers an event handler. See Subscription.
+//	server.broadcast      Sends a message to all players. See MessageParams.
+//	server.players        Returns the online players as []PlayerInfo.
+//	server.levels         Returns the loaded levels as []LevelInfo.
+//	player.sendMessage    Sends a message to a player. See MessageParams.
+//	player.kick           Kicks a player. See MessageParams.
+//	player.teleport       Teleports a player. See TeleportParams.
+//	level.getBlock        Returns the block at a location. See BlockParams.
+//	level.setBlock        Sets the block at a location. See BlockParams.
+//
+// The requests that modify players or levels are applied on the update loop
+// of the server, and respond once they have been applied.
+//
+// Anything the plugin writes to its standard error is logged by the server.
+package rpcplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by calls on a closed connection.
+	ErrClosed = errors.New("rpcplugin: connection closed")
+
+	// ErrTimeout is returned by calls that do not receive a response in
+	// time.
+	ErrTimeout = errors.New("rpcplugin: call timed out")
+
+	// ErrOverflow is returned when the peer does not read its input fast
+	// enough and the queue of outgoing messages is full.
+	ErrOverflow = errors.New("rpcplugin: output queue full")
+
+	// ErrBusy is returned for requests that arrive while the maximum number
+	// of requests is being handled.
+	ErrBusy = errors.New("rpcplugin: too many concurrent requests")
+)
+
+const (
+	// outboxSize is the maximum number of messages that are queued to be
+	// sent.
+	outboxSize = 1024
+
+	// maxRequests is the maximum number of incoming requests that are
+	// handled concurrently.
+	maxRequests = 64
+)
+
+// message is a request, a notification or a response.
+type message struct {
+	ID     uint64          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// handlerFunc handles a request and returns its result.
+type handlerFunc func(method string, params json.RawMessage) (interface{}, error)
+
+// conn is a connection that can send and receive requests. Messages are
+// written by a separate goroutine, so that sending never blocks. If the queue
+// of outgoing messages fills up, overflow is called once.
+type conn struct {
+	handler      handlerFunc
+	overflow     func()
+	overflowOnce sync.Once
+
+	outbox   chan []byte
+	done     chan struct{}
+	requests chan struct{}
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *message
+	closed  bool
+}
+
+func newConn(w io.Writer, handler handlerFunc) *conn {
+	c := &conn{
+		handler:  handler,
+		outbox:   make(chan []byte, outboxSize),
+		done:     make(chan struct{}),
+		requests: make(chan struct{}, maxRequests),
+		pending:  make(map[uint64]chan *message),
+	}
+
+	go c.writeLoop(w)
+	return c
+}
+
+// writeLoop writes the queued messages to w until the connection is closed
+// or a write fails.
+func (c *conn) writeLoop(w io.Writer) {
+	for {
+		select {
+		case data := <-c.outbox:
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// serve reads messages from r until it fails. Up to maxRequests requests are
+// handled concurrently, and the rest fail with ErrBusy. When serve returns,
+// the pending calls fail with ErrClosed.
+func (c *conn) serve(r io.Reader) error {
+	defer c.close()
+
+	decoder := json.NewDecoder(r)
+	for {
+		msg := &message{}
+		if err := decoder.Decode(msg); err != nil {
+			return err
+		}
+
+		if len(msg.Method) > 0 {
+			select {
+			case c.requests <- struct{}{}:
+				go c.handle(msg)
+			default:
+				if msg.ID != 0 {
+					c.write(&message{ID: msg.ID, Error: ErrBusy.Error()})
+				}
+			}
+
+			continue
+		}
+
+		c.lock.Lock()
+		ch := c.pending[msg.ID]
+		delete(c.pending, msg.ID)
+		c.lock.Unlock()
+
+		if ch != nil {
+			ch <- msg
+		}
+	}
+}
+
+// handle handles a request and releases its slot once it has been answered.
+func (c *conn) handle(msg *message) {
+	defer func() { <-c.requests }()
+
+	result, err := c.dispatch(msg)
+	if msg.ID == 0 {
+		return
+	}
+
+	response := &message{ID: msg.ID}
+	if err != nil {
+		response.Error = err.Error()
+	} else if response.Result, err = json.Marshal(result); err != nil {
+		response.Result = nil
+		response.Error = err.Error()
+	}
+
+	c.write(response)
+}
+
+// dispatch calls the handler of the connection. A panic in the handler is
+// returned as an error, so that a bad request cannot crash the process.
+func (c *conn) dispatch(msg *message) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rpcplugin: %s: %v\n", msg.Method, r)
+			err = fmt.Errorf("%s: internal error", msg.Method)
+		}
+	}()
+
+	return c.handler(msg.Method, msg.Params)
+}
+
+// write queues msg to be sent.
+func (c *conn) write(msg *message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.done:
+		return ErrClosed
+	case c.outbox <- append(data, '\n'):
+		return nil
+	default:
+	}
+
+	if c.overflow != nil {
+		c.overflowOnce.Do(c.overflow)
+	}
+
+	return ErrOverflow
+}
+
+func (c *conn) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.closed {
+		close(c.done)
+	}
+
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// call sends a request and decodes its result into result, which can be nil.
+func (c *conn) call(method string, params, result interface{}, timeout time.Duration) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *message, 1)
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrClosed
+	}
+
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	if err := c.write(&message{ID: id, Method: method, Params: data}); err != nil {
+		c.cancel(id)
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return ErrClosed
+		} else if len(response.Error) > 0 {
+			return errors.New(response.Error)
+		} else if result != nil && len(response.Result) > 0 {
+			return json.Unmarshal(response.Result, result)
+		}
+
+		return nil
+
+	case <-timer.C:
+		c.cancel(id)
+		return ErrTimeout
+	}
+}
+
+func (c *conn) cancel(id uint64) {
+	c.lock.Lock()
+	delete(c.pending, id)
+	c.lock.Unlock()
+}
+
+// notify sends a notification.
+func (c *conn) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return c.write(&message{Method: method, Params: data})
+}
@@ -0,0 +1,633 @@
+package rpcplugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+)
+
+const (
+	callTimeout  = 5 * time.Second
+	eventTimeout = 50 * time.Millisecond
+	exitTimeout  = 5 * time.Second
+)
+
+var eventTypes = map[string]int{
+	EventPlayerLogin: mcc.EventTypePlayerLogin,
+	EventPlayerJoin:  mcc.EventTypePlayerJoin,
+	EventPlayerQuit:  mcc.EventTypePlayerQuit,
+	EventPlayerChat:  mcc.EventTypePlayerChat,
+	EventPlayerKick:  mcc.EventTypePlayerKick,
+	EventBlockPlace:  mcc.EventTypeBlockPlace,
+	EventBlockBreak:  mcc.EventTypeBlockBreak,
+	EventLevelLoad:   mcc.EventTypeLevelLoad,
+	EventLevelUnload: mcc.EventTypeLevelUnload,
+	EventLevelSave:   mcc.EventTypeLevelSave,
+	EventServerStart: mcc.EventTypeServerStart,
+	EventServerStop:  mcc.EventTypeServerStop,
+}
+
+// Host runs a plugin executable as a child process and implements mcc.Plugin
+// on its behalf. If the process exits while the plugin is enabled, the
+// plugin is disabled. Enabling it again restarts the process. A process that
+// stops reading its input is killed, so that sending it events never blocks
+// the server.
+//
+// Requests that modify players or levels are applied on the update loop of
+// the server. Cancellable events wait for the plugin for at most 50ms, and
+// are left unchanged if it does not respond in time. The kick events of the
+// kicks that a plugin requests are fired on the update loop, so they are sent
+// without waiting and cannot be cancelled, but they cannot stall the tick
+// either.
+type Host struct {
+	path  string
+	args  []string
+	info  Info
+	spawn func() (*process, error)
+
+	lock     sync.Mutex
+	server   *mcc.Server
+	process  *process
+	conn     *conn
+	done     chan struct{}
+	stopping bool
+	commands map[string]*mcc.Command
+}
+
+// Open starts the plugin executable at path with the specified arguments and
+// returns a Host for it.
+func Open(path string, args ...string) (*Host, error) {
+	host := &Host{path: path, args: args}
+	host.spawn = host.startProcess
+	if err := host.start(); err != nil {
+		return nil, err
+	}
+
+	return host, nil
+}
+
+// process is a running plugin process. stderr can be nil.
+type process struct {
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+	wait   func() error
+	kill   func()
+}
+
+// startProcess starts the plugin executable.
+func (host *Host) startProcess() (*process, error) {
+	cmd := exec.Command(host.path, host.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &process{
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		wait:   cmd.Wait,
+		kill:   func() { cmd.Process.Kill() },
+	}, nil
+}
+
+// start starts the plugin process and requests its metadata.
+func (host *Host) start() error {
+	process, err := host.spawn()
+	if err != nil {
+		return err
+	}
+
+	c := newConn(process.stdin, host.handle)
+	c.overflow = func() {
+		log.Printf("rpcplugin: %s is not reading its input, killing it\n", host.name())
+		process.kill()
+	}
+
+	done := make(chan struct{})
+
+	host.lock.Lock()
+	host.process, host.conn, host.done = process, c, done
+	host.stopping = false
+	host.commands = make(map[string]*mcc.Command)
+	host.lock.Unlock()
+
+	if process.stderr != nil {
+		go host.logOutput(process.stderr)
+	}
+
+	go host.wait(process, c, done)
+
+	var info Info
+	if err := c.call("plugin.init", struct{}{}, &info, callTimeout); err != nil {
+		host.stop()
+		return fmt.Errorf("rpcplugin: %s: %s", host.path, err)
+	}
+
+	if len(info.Name) == 0 {
+		host.stop()
+		return fmt.Errorf("rpcplugin: %s: plugin has no name", host.path)
+	}
+
+	if len(host.info.Name) > 0 && info.Name != host.info.Name {
+		host.stop()
+		return fmt.Errorf("rpcplugin: %s: plugin name changed to %s", host.path, info.Name)
+	}
+
+	host.info = info
+	return nil
+}
+
+// wait serves the connection until the process exits. If the plugin is
+// enabled, it is then disabled.
+func (host *Host) wait(process *process, c *conn, done chan struct{}) {
+	err := c.serve(process.stdout)
+	exitErr := process.wait()
+	close(done)
+
+	host.lock.Lock()
+	crashed := !host.stopping && host.server != nil
+	server := host.server
+	host.lock.Unlock()
+
+	if crashed {
+		if err == io.EOF {
+			err = exitErr
+			if err == nil {
+				err = errors.New("exit status 0")
+			}
+		}
+
+		log.Printf("rpcplugin: %s exited: %s\n", host.info.Name, err)
+		server.DisablePlugin(host.info.Name)
+	}
+}
+
+func (host *Host) logOutput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Printf("%s: %s\n", host.name(), scanner.Text())
+	}
+}
+
+// stop asks the plugin process to exit and kills it if it does not exit in
+// time.
+func (host *Host) stop() {
+	host.lock.Lock()
+	host.stopping = true
+	process, done := host.process, host.done
+	host.lock.Unlock()
+
+	if process == nil {
+		return
+	}
+
+	process.stdin.Close()
+	select {
+	case <-done:
+	case <-time.After(exitTimeout):
+		process.kill()
+		<-done
+	}
+
+	host.lock.Lock()
+	host.process, host.conn = nil, nil
+	host.lock.Unlock()
+}
+
+func (host *Host) name() string {
+	if len(host.info.Name) > 0 {
+		return host.info.Name
+	}
+
+	return host.path
+}
+
+func (host *Host) getConn() *conn {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return host.conn
+}
+
+// Name implements mcc.Plugin.
+func (host *Host) Name() string {
+	return host.info.Name
+}
+
+// Info implements mcc.DescribedPlugin.
+func (host *Host) Info() mcc.PluginInfo {
+	return mcc.PluginInfo{
+		Name:        host.info.Name,
+		Version:     host.info.Version,
+		Authors:     host.info.Authors,
+		Depends:     host.info.Depends,
+		SoftDepends: host.info.SoftDepends,
+	}
+}
+
+// Enable implements mcc.Plugin. It restarts the plugin process if it is not
+// running.
+func (host *Host) Enable(server *mcc.Server) error {
+	if host.getConn() == nil {
+		if err := host.start(); err != nil {
+			return err
+		}
+	}
+
+	host.lock.Lock()
+	host.server = server
+	host.lock.Unlock()
+
+	if err := host.getConn().call("plugin.enable", struct{}{}, nil, callTimeout); err != nil {
+		host.lock.Lock()
+		host.server = nil
+		host.lock.Unlock()
+
+		host.stop()
+		return err
+	}
+
+	return nil
+}
+
+// Disable implements mcc.Plugin. It stops the plugin process.
+func (host *Host) Disable(server *mcc.Server) {
+	if c := host.getConn(); c != nil {
+		if err := c.call("plugin.disable", struct{}{}, nil, callTimeout); err != nil && err != ErrClosed {
+			log.Printf("rpcplugin: %s: %s\n", host.info.Name, err)
+		}
+	}
+
+	host.stop()
+
+	host.lock.Lock()
+	host.server = nil
+	host.lock.Unlock()
+}
+
+// handle handles a request of the plugin.
+func (host *Host) handle(method string, params json.RawMessage) (interface{}, error) {
+	host.lock.Lock()
+	server := host.server
+	host.lock.Unlock()
+	if server == nil {
+		return nil, errors.New("plugin is not enabled")
+	}
+
+	switch method {
+	case "server.addCommand":
+		var command Command
+		if err := json.Unmarshal(params, &command); err != nil {
+			return nil, err
+		}
+
+		return nil, host.addCommand(server, command)
+
+	case "server.removeCommand":
+		var p NameParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+
+		host.lock.Lock()
+		command := host.commands[p.Name]
+		delete(host.commands, p.Name)
+		host.lock.Unlock()
+
+		if command == nil {
+			return nil, fmt.Errorf("command %s not found", p.Name)
+		}
+
+		server.RemoveCommand(command)
+		return nil, nil
+
+	case "server.subscribe":
+		var sub Subscription
+		if err := json.Unmarshal(params, &sub); err != nil {
+			return nil, err
+		}
+
+		return nil, host.subscribe(server, sub)
+
+	case "server.broadcast":
+		var p MessageParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+
+		server.BroadcastMessage(p.Message)
+		return nil, nil
+
+	case "server.players":
+		players := []PlayerInfo{}
+		server.ForEachPlayer(func(player *mcc.Player) {
+			info := PlayerInfo{Name: player.Name()}
+			if level := player.Level(); level != nil {
+				loc := player.Location()
+				info.Level, info.X, info.Y, info.Z = level.Name, loc.X, loc.Y, loc.Z
+			}
+
+			players = append(players, info)
+		})
+
+		return players, nil
+
+	case "server.levels":
+		levels := []LevelInfo{}
+		server.ForEachLevel(func(level *mcc.Level) {
+			levels = append(levels, LevelInfo{level.Name, level.Width, level.Height, level.Length})
+		})
+
+		return levels, nil
+
+	case "player.sendMessage", "player.kick":
+		var p MessageParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+
+		player := server.FindPlayer(p.Player)
+		if player == nil {
+			return nil, fmt.Errorf("player %s not found", p.Player)
+		}
+
+		if method == "player.kick" {
+			return nil, host.runOnTick(server, func() error {
+				tickKicks.add(player)
+				defer tickKicks.remove(player)
+				player.Kick(p.Message)
+				return nil
+			})
+		}
+
+		player.SendMessage(p.Message)
+		return nil, nil
+
+	case "player.teleport":
+		var p TeleportParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+
+		player := server.FindPlayer(p.Player)
+		if player == nil {
+			return nil, fmt.Errorf("player %s not found", p.Player)
+		}
+
+		var level *mcc.Level
+		if len(p.Level) > 0 {
+			if level = server.FindLevel(p.Level); level == nil {
+				return nil, fmt.Errorf("level %s not found", p.Level)
+			}
+		}
+
+		return nil, host.runOnTick(server, func() error {
+			if level != nil {
+				player.TeleportLevel(level)
+			}
+
+			player.Teleport(mcc.Location{X: p.X, Y: p.Y, Z: p.Z, Yaw: p.Yaw, Pitch: p.Pitch})
+			return nil
+		})
+
+	case "level.getBlock", "level.setBlock":
+		var p BlockParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+
+		level := server.FindLevel(p.Level)
+		if level == nil {
+			return nil, fmt.Errorf("level %s not found", p.Level)
+		} else if !level.InBounds(p.X, p.Y, p.Z) {
+			return nil, errors.New("coordinates out of bounds")
+		}
+
+		if method == "level.setBlock" {
+			if p.Block < 0 || p.Block > mcc.BlockMax {
+				return nil, fmt.Errorf("invalid block %d", p.Block)
+			}
+
+			return nil, host.runOnTick(server, func() error {
+				level.SetBlock(p.X, p.Y, p.Z, mcc.BlockID(p.Block))
+				return nil
+			})
+		}
+
+		p.Block = int(level.GetBlock(p.X, p.Y, p.Z))
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+// runOnTick runs fn on the update loop of server and returns its result. The
+// task is owned by the plugin, so it is cancelled if the plugin is disabled
+// before it runs.
+func (host *Host) runOnTick(server *mcc.Server, fn func() error) error {
+	result := make(chan error, 1)
+	task := server.Registrar(host).RunOnTick(func() {
+		result <- fn()
+	})
+
+	timer := time.NewTimer(callTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		task.Cancel()
+		return ErrTimeout
+	}
+}
+
+func (host *Host) addCommand(server *mcc.Server, command Command) error {
+	if len(command.Name) == 0 {
+		return errors.New("command has no name")
+	}
+
+	cmd := &mcc.Command{
+		Name:        command.Name,
+		Description: command.Description,
+		Usage:       command.Usage,
+		Permissions: command.Permissions,
+		Owner:       host,
+		Handler: func(sender mcc.CommandSender, _ *mcc.Command, message string) {
+			c := host.getConn()
+			if c == nil {
+				sender.SendMessage("Plugin " + host.info.Name + " is not running")
+				return
+			}
+
+			var result CommandResult
+			call := CommandCall{command.Name, sender.Name(), message}
+			if err := c.call("plugin.command", call, &result, callTimeout); err != nil {
+				sender.SendMessage("Plugin " + host.info.Name + " failed: " + err.Error())
+				return
+			}
+
+			for _, msg := range result.Messages {
+				sender.SendMessage(msg)
+			}
+		},
+	}
+
+	host.lock.Lock()
+	host.commands[command.Name] = cmd
+	host.lock.Unlock()
+
+	server.AddCommand(cmd)
+	return nil
+}
+
+func (host *Host) subscribe(server *mcc.Server, sub Subscription) error {
+	eventType, ok := eventTypes[sub.Event]
+	if !ok {
+		return fmt.Errorf("unknown event %s", sub.Event)
+	}
+
+	server.RegisterHandler(&mcc.Handler{
+		EventType:       eventType,
+		Priority:        sub.Priority,
+		IgnoreCancelled: sub.IgnoreCancelled,
+		Owner:           host,
+		Func: func(eventType int, event interface{}) {
+			host.forward(server, sub, event)
+		},
+	})
+
+	return nil
+}
+
+// tickKicks holds the players that are being kicked on the update loop by
+// the requests of any plugin.
+var tickKicks = playerSet{players: make(map[*mcc.Player]int)}
+
+// playerSet is a set of players that is safe for concurrent use. A player
+// can be added more than once, and stays in the set until it is removed as
+// many times.
+type playerSet struct {
+	lock    sync.Mutex
+	players map[*mcc.Player]int
+}
+
+func (set *playerSet) add(player *mcc.Player) {
+	set.lock.Lock()
+	set.players[player]++
+	set.lock.Unlock()
+}
+
+func (set *playerSet) remove(player *mcc.Player) {
+	set.lock.Lock()
+	if set.players[player]--; set.players[player] == 0 {
+		delete(set.players, player)
+	}
+	set.lock.Unlock()
+}
+
+func (set *playerSet) contains(player *mcc.Player) bool {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	return set.players[player] > 0
+}
+
+// onTick reports whether event is fired on the update loop by a request of a
+// plugin. The other cancellable events are fired by the goroutines of the
+// players and commands.
+func onTick(event interface{}) bool {
+	kick, ok := event.(*mcc.EventPlayerKick)
+	return ok && tickKicks.contains(kick.Player)
+}
+
+// forward sends event to the handler of the plugin. Cancellable events wait
+// for the response and apply the changes made by the plugin, unless they are
+// fired on the update loop.
+func (host *Host) forward(server *mcc.Server, sub Subscription, event interface{}) {
+	c := host.getConn()
+	if c == nil {
+		return
+	}
+
+	e := convertEvent(sub.Event, event)
+	call := EventCall{sub.ID, e}
+	if _, ok := event.(mcc.Cancellable); !ok || onTick(event) {
+		c.notify("plugin.event", call)
+		return
+	}
+
+	var result Event
+	if err := c.call("plugin.event", call, &result, eventTimeout); err != nil {
+		log.Printf("rpcplugin: %s: %s: %s\n", host.info.Name, sub.Event, err)
+		return
+	}
+
+	switch event := event.(type) {
+	case *mcc.EventPlayerLogin:
+		event.Cancel, event.CancelReason = result.Cancel, result.Reason
+	case *mcc.EventPlayerChat:
+		event.Cancel, event.Message = result.Cancel, result.Message
+	case *mcc.EventPlayerKick:
+		event.Cancel, event.Reason = result.Cancel, result.Reason
+	case *mcc.EventBlockPlace:
+		event.Cancel = result.Cancel
+	case *mcc.EventBlockBreak:
+		event.Cancel = result.Cancel
+	}
+}
+
+// convertEvent converts event to the Event that is sent to the plugin.
+func convertEvent(eventType string, event interface{}) *Event {
+	e := &Event{Type: eventType}
+	switch event := event.(type) {
+	case *mcc.EventPlayerLogin:
+		e.Player, e.Reason, e.Cancel = event.Player.Name(), event.CancelReason, event.Cancel
+	case *mcc.EventPlayerJoin:
+		e.Player = event.Player.Name()
+	case *mcc.EventPlayerQuit:
+		e.Player = event.Player.Name()
+	case *mcc.EventPlayerChat:
+		e.Player, e.Message, e.Cancel = event.Player.Name(), event.Message, event.Cancel
+	case *mcc.EventPlayerKick:
+		e.Player, e.Reason, e.Cancel = event.Player.Name(), event.Reason, event.Cancel
+	case *mcc.EventBlockPlace:
+		e.Player, e.Level, e.Cancel = event.Player.Name(), event.Level.Name, event.Cancel
+		e.Block, e.OldBlock = int(event.Block), int(event.OldBlock)
+		e.X, e.Y, e.Z = event.X, event.Y, event.Z
+	case *mcc.EventBlockBreak:
+		e.Player, e.Level, e.Cancel = event.Player.Name(), event.Level.Name, event.Cancel
+		e.Block, e.OldBlock = int(mcc.BlockAir), int(event.Block)
+		e.X, e.Y, e.Z = event.X, event.Y, event.Z
+	case *mcc.EventLevelLoad:
+		e.Level = event.Level.Name
+	case *mcc.EventLevelUnload:
+		e.Level = event.Level.Name
+	case *mcc.EventLevelSave:
+		e.Level = event.Level.Name
+	}
+
+	return e
+}
@@ -0,0 +1,305 @@
+package rpcplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+	"github.com/andreasgoulas/go-mcc/mcc/internal/mcctest"
+)
+
+var errKilled = errors.New("killed")
+
+// testProcess runs a Client in-process, connected to the host over pipes.
+// Once stall is closed, the client stops reading its input.
+type testProcess struct {
+	stdin  *io.PipeReader
+	stdout *io.PipeWriter
+	stall  chan struct{}
+	killed chan struct{}
+	once   sync.Once
+	done   chan error
+}
+
+func (p *testProcess) Read(b []byte) (int, error) {
+	select {
+	case <-p.stall:
+		<-p.killed
+		return 0, errKilled
+	default:
+		return p.stdin.Read(b)
+	}
+}
+
+func (p *testProcess) kill() {
+	p.once.Do(func() {
+		close(p.killed)
+		p.stdin.CloseWithError(errKilled)
+		p.stdout.CloseWithError(errKilled)
+	})
+}
+
+// newTestHost returns a Host that runs client instead of an executable.
+func newTestHost(t *testing.T, client *Client) (*Host, *testProcess) {
+	p := &testProcess{
+		stall:  make(chan struct{}),
+		killed: make(chan struct{}),
+		done:   make(chan error, 1),
+	}
+
+	host := &Host{path: "test"}
+	host.spawn = func() (*process, error) {
+		stdinR, stdinW := io.Pipe()
+		stdoutR, stdoutW := io.Pipe()
+		p.stdin, p.stdout = stdinR, stdoutW
+
+		go func() {
+			err := client.ServeConn(p, stdoutW)
+			stdoutW.Close()
+			p.done <- err
+		}()
+
+		return &process{
+			stdin:  stdinW,
+			stdout: stdoutR,
+			wait:   func() error { return <-p.done },
+			kill:   p.kill,
+		}, nil
+	}
+
+	if err := host.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return host, p
+}
+
+func pluginState(server *mcc.Server, name string) int {
+	for _, status := range server.Plugins() {
+		if status.Info.Name == name {
+			return status.State
+		}
+	}
+
+	return -1
+}
+
+func TestHost(t *testing.T) {
+	server, stop := mcctest.NewServer(t)
+	defer stop()
+
+	disabled := make(chan struct{})
+	client := NewClient(Info{Name: "test", Version: "1.0"})
+	client.OnEnable = func(client *Client) error {
+		err := client.AddCommand(Command{Name: "greet"}, func(sender, message string) []string {
+			return []string{"Hello " + sender + ", " + message}
+		})
+		if err != nil {
+			return err
+		}
+
+		err = client.Subscribe(EventPlayerChat, mcc.PriorityNormal, func(event *Event) {
+			event.Message = strings.ToUpper(event.Message)
+			event.Cancel = event.Message == "SPAM"
+		})
+		if err != nil {
+			return err
+		}
+
+		return client.Subscribe(EventPlayerKick, mcc.PriorityNormal, func(event *Event) {
+			event.Cancel = true
+		})
+	}
+	client.OnDisable = func(client *Client) {
+		close(disabled)
+	}
+
+	host, _ := newTestHost(t, client)
+	if info := host.Info(); info.Name != "test" || info.Version != "1.0" {
+		t.Fatalf("got info %+v", info)
+	}
+
+	if err := server.AddPlugin(host); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := pluginState(server, "test"); state != mcc.PluginEnabled {
+		t.Fatalf("got state %d, want enabled", state)
+	}
+
+	t.Run("Command", func(t *testing.T) {
+		sender := mcctest.NewSender(server)
+		server.ExecuteCommand(sender, "greet world")
+		if msg := sender.Message(t); msg != "Hello Tester, world" {
+			t.Errorf("got message %q", msg)
+		}
+	})
+
+	t.Run("Event", func(t *testing.T) {
+		player := mcc.NewPlayer(nil, server)
+		for _, test := range []struct {
+			message, want string
+			cancel        bool
+		}{
+			{"hello", "HELLO", false},
+			{"spam", "SPAM", true},
+		} {
+			event := mcc.EventPlayerChat{Player: player, Message: test.message}
+			server.FireEvent(mcc.EventTypePlayerChat, &event)
+			if event.Message != test.want || event.Cancel != test.cancel {
+				t.Errorf("%q: got %q, cancel %v", test.message, event.Message, event.Cancel)
+			}
+		}
+	})
+
+	t.Run("Kick", func(t *testing.T) {
+		// A kick that is requested by the plugin is fired on the update
+		// loop, which does not wait for the plugin to cancel it. Other
+		// kicks wait for the plugin.
+		cancelled := make(chan bool, 1)
+		handler := server.Subscribe(mcc.PriorityMonitor, func(event *mcc.EventPlayerKick) {
+			cancelled <- event.Cancel
+		})
+		defer server.RemoveHandler(handler)
+
+		player := mcc.NewPlayer(nil, server)
+		server.AddPlayer(player)
+		defer server.RemovePlayer(player)
+
+		event := mcc.EventPlayerKick{Player: player, Reason: "Kicked!"}
+		server.FireEvent(mcc.EventTypePlayerKick, &event)
+		if !<-cancelled {
+			t.Error("kick was not cancelled by the plugin")
+		}
+
+		if err := client.Kick(player.Name(), "Kicked!"); err != nil {
+			t.Fatal(err)
+		}
+
+		if <-cancelled {
+			t.Error("kick on the update loop was cancelled by the plugin")
+		}
+	})
+
+	t.Run("SetBlock", func(t *testing.T) {
+		if err := client.SetBlock("main", 1, 2, 3, int(mcc.BlockStone)); err != nil {
+			t.Fatal(err)
+		}
+
+		if block := server.MainLevel.GetBlock(1, 2, 3); block != mcc.BlockStone {
+			t.Errorf("got block %d, want %d", block, mcc.BlockStone)
+		}
+
+		if err := client.SetBlock("main", -1, 2, 3, int(mcc.BlockStone)); err == nil {
+			t.Error("negative coordinate accepted")
+		}
+
+		if err := client.SetBlock("main", 1, 2, 3, mcc.BlockMax+1); err == nil {
+			t.Error("invalid block accepted")
+		}
+	})
+
+	if err := server.DisablePlugin("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-disabled:
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("OnDisable was not called")
+	}
+}
+
+func TestHostCrash(t *testing.T) {
+	server, stop := mcctest.NewServer(t)
+	defer stop()
+
+	host, p := newTestHost(t, NewClient(Info{Name: "test"}))
+	if err := server.AddPlugin(host); err != nil {
+		t.Fatal(err)
+	}
+
+	p.kill()
+	mcctest.WaitFor(t, "the plugin to be disabled", func() bool {
+		return pluginState(server, "test") == mcc.PluginDisabled
+	})
+}
+
+func TestHostOverflow(t *testing.T) {
+	server, stop := mcctest.NewServer(t)
+	defer stop()
+
+	client := NewClient(Info{Name: "test"})
+	client.OnEnable = func(client *Client) error {
+		return client.Subscribe(EventLevelLoad, mcc.PriorityNormal, func(event *Event) {})
+	}
+
+	host, p := newTestHost(t, client)
+	if err := server.AddPlugin(host); err != nil {
+		t.Fatal(err)
+	}
+
+	close(p.stall)
+	for i := 0; i < 2*outboxSize; i++ {
+		event := mcc.EventLevelLoad{Level: server.MainLevel}
+		server.FireEvent(mcc.EventTypeLevelLoad, &event)
+	}
+
+	select {
+	case <-p.killed:
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("the plugin was not killed")
+	}
+
+	mcctest.WaitFor(t, "the plugin to be disabled", func() bool {
+		return pluginState(server, "test") == mcc.PluginDisabled
+	})
+}
+
+func TestConnBusy(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(method string, params json.RawMessage) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+
+	serverR, clientW := io.Pipe()
+	clientR, serverW := io.Pipe()
+	server := newConn(serverW, handler)
+	client := newConn(clientW, handler)
+	go server.serve(serverR)
+	go client.serve(clientR)
+	defer func() {
+		clientW.Close()
+		serverW.Close()
+	}()
+
+	// The requests beyond the limit fail while the others are handled.
+	errs := make(chan error, maxRequests+1)
+	for i := 0; i < maxRequests+1; i++ {
+		go func() {
+			errs <- client.call("test", nil, nil, mcctest.Timeout)
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil || err.Error() != ErrBusy.Error() {
+			t.Fatalf("got error %v, want %v", err, ErrBusy)
+		}
+	case <-time.After(mcctest.Timeout):
+		t.Fatal("no request was rejected")
+	}
+
+	close(release)
+	for i := 0; i < maxRequests; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package rpcplugin
+
+// Info contains the metadata of a plugin.
+type Info struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version,omitempty"`
+	Authors     []string `json:"authors,omitempty"`
+	Depends     []string `json:"depends,omitempty"`
+	SoftDepends []string `json:"soft-depends,omitempty"`
+}
+
+// Command describes a command of a plugin.
+type Command struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Usage       string `json:"usage,omitempty"`
+	Permissions uint32 `json:"permissions,omitempty"`
+}
+
+// CommandCall contains the parameters of the plugin.command request.
+type CommandCall struct {
+	Command string `json:"command"`
+	Sender  string `json:"sender"`
+	Message string `json:"message"`
+}
+
+// CommandResult is the result of the plugin.command request. Messages are
+// sent to the sender of the command.
+type CommandResult struct {
+	Messages []string `json:"messages,omitempty"`
+}
+
+// Subscription contains the parameters of the server.subscribe request.
+// Handlers are identified by an ID that is chosen by the plugin. Priority is
+// one of the mcc.Priority constants.
+type Subscription struct {
+	ID              int    `json:"id"`
+	Event           string `json:"event"`
+	Priority        int    `json:"priority"`
+	IgnoreCancelled bool   `json:"ignore-cancelled,omitempty"`
+}
+
+// The events that can be subscribed to. Events marked with an asterisk can be
+// cancelled.
+const (
+	EventPlayerLogin = "player-login" // *
+	EventPlayerJoin  = "player-join"
+	EventPlayerQuit  = "player-quit"
+	EventPlayerChat  = "player-chat" // *
+	EventPlayerKick  = "player-kick" // *
+	EventBlockPlace  = "block-place" // *
+	EventBlockBreak  = "block-break" // *
+	EventLevelLoad   = "level-load"
+	EventLevelUnload = "level-unload"
+	EventLevelSave   = "level-save"
+	EventServerStart = "server-start"
+	EventServerStop  = "server-stop"
+)
+
+// Event is an event that is forwarded to a plugin. Only the fields that are
+// relevant to the event are set. Handlers of cancellable events can change
+// Message, Reason and Cancel.
+type Event struct {
+	Type     string `json:"type"`
+	Player   string `json:"player,omitempty"`
+	Level    string `json:"level,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Block    int    `json:"block"`
+	OldBlock int    `json:"old-block"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Z        int    `json:"z"`
+	Cancel   bool   `json:"cancel,omitempty"`
+}
+
+// EventCall contains the parameters of the plugin.event request.
+type EventCall struct {
+	Handler int    `json:"handler"`
+	Event   *Event `json:"event"`
+}
+
+// NameParams contains the name of a command, player or level.
+type NameParams struct {
+	Name string `json:"name"`
+}
+
+// MessageParams contains a message or kick reason and the player it is sent
+// to, if any.
+type MessageParams struct {
+	Player  string `json:"player,omitempty"`
+	Message string `json:"message"`
+}
+
+// TeleportParams contains the parameters of the player.teleport request. If
+// Level is set, the player is moved to that level first.
+type TeleportParams struct {
+	Player string  `json:"player"`
+	Level  string  `json:"level,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Z      float64 `json:"z"`
+	Yaw    float64 `json:"yaw"`
+	Pitch  float64 `json:"pitch"`
+}
+
+// BlockParams contains the parameters of the level.getBlock and
+// level.setBlock requests, and the result of level.getBlock.
+type BlockParams struct {
+	Level string `json:"level"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Z     int    `json:"z"`
+	Block int    `json:"block"`
+}
+
+// PlayerInfo describes an online player.
+type PlayerInfo struct {
+	Name  string  `json:"name"`
+	Level string  `json:"level,omitempty"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Z     float64 `json:"z"`
+}
+
+// LevelInfo describes a loaded level.
+type LevelInfo struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Length int    `json:"length"`
+}
@@ -0,0 +1,251 @@
+package mcc
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	asyncWorkers   = 4
+	asyncQueueSize = 256
+)
+
+// Task is a function that is scheduled to run on the server.
+//
+// Tasks run on the update loop of the server, before the entities and levels
+// are updated, so they can safely modify them. If Async is set, the task runs
+// on a pool of worker goroutines instead. A task first runs after Delay, and
+// then every Interval, if Interval is positive, until it is cancelled. A
+// repeating async task is skipped while its previous run is in progress.
+//
+// Owner is the plugin that scheduled the task. The tasks of a plugin are
+// cancelled when it is disabled. Plugins usually schedule their tasks through
+// a Registrar, which sets the owner. Tasks without an owner outlive the plugin
+// that scheduled them, and must be cancelled explicitly.
+type Task struct {
+	Delay    time.Duration
+	Interval time.Duration
+	Async    bool
+	Owner    Plugin
+	Func     func()
+
+	next      time.Time
+	running   uint32
+	cancelled uint32
+}
+
+// Cancel cancels the task. A task that is running is not interrupted, but it
+// does not run again.
+func (task *Task) Cancel() {
+	atomic.StoreUint32(&task.cancelled, 1)
+}
+
+// Cancelled reports whether the task has been cancelled.
+func (task *Task) Cancelled() bool {
+	return atomic.LoadUint32(&task.cancelled) == 1
+}
+
+type scheduler struct {
+	lock  sync.Mutex
+	tasks []*Task
+	queue chan *Task
+}
+
+// ScheduleTask schedules task and returns it.
+func (server *Server) ScheduleTask(task *Task) *Task {
+	server.addTask(task)
+	return task
+}
+
+// ScheduleTask schedules task on behalf of the plugin of the registrar and
+// returns it.
+func (registrar *Registrar) ScheduleTask(task *Task) *Task {
+	task.Owner = registrar.plugin
+	return registrar.server.ScheduleTask(task)
+}
+
+// Schedule runs fn once on the update loop after delay.
+func (server *Server) Schedule(delay time.Duration, fn func()) *Task {
+	return server.ScheduleTask(&Task{Delay: delay, Func: fn})
+}
+
+// ScheduleRepeating runs fn on the update loop every interval.
+func (server *Server) ScheduleRepeating(interval time.Duration, fn func()) *Task {
+	return server.ScheduleTask(&Task{Delay: interval, Interval: interval, Func: fn})
+}
+
+// RunOnTick runs fn once on the next tick of the update loop.
+func (server *Server) RunOnTick(fn func()) *Task {
+	return server.ScheduleTask(&Task{Func: fn})
+}
+
+// ScheduleAsync runs fn once on a worker goroutine after delay.
+func (server *Server) ScheduleAsync(delay time.Duration, fn func()) *Task {
+	return server.ScheduleTask(&Task{Delay: delay, Async: true, Func: fn})
+}
+
+// ScheduleRepeatingAsync runs fn on a worker goroutine every interval.
+func (server *Server) ScheduleRepeatingAsync(interval time.Duration, fn func()) *Task {
+	return server.ScheduleTask(&Task{Delay: interval, Interval: interval, Async: true, Func: fn})
+}
+
+// RunAsync runs fn once on a worker goroutine on the next tick.
+func (server *Server) RunAsync(fn func()) *Task {
+	return server.ScheduleTask(&Task{Async: true, Func: fn})
+}
+
+// Schedule is like Server.Schedule, but the task is owned by the plugin of
+// the registrar.
+func (registrar *Registrar) Schedule(delay time.Duration, fn func()) *Task {
+	return registrar.ScheduleTask(&Task{Delay: delay, Func: fn})
+}
+
+// ScheduleRepeating is like Server.ScheduleRepeating, but the task is owned
+// by the plugin of the registrar.
+func (registrar *Registrar) ScheduleRepeating(interval time.Duration, fn func()) *Task {
+	return registrar.ScheduleTask(&Task{Delay: interval, Interval: interval, Func: fn})
+}
+
+// RunOnTick is like Server.RunOnTick, but the task is owned by the plugin of
+// the registrar.
+func (registrar *Registrar) RunOnTick(fn func()) *Task {
+	return registrar.ScheduleTask(&Task{Func: fn})
+}
+
+// ScheduleAsync is like Server.ScheduleAsync, but the task is owned by the
+// plugin of the registrar.
+func (registrar *Registrar) ScheduleAsync(delay time.Duration, fn func()) *Task {
+	return registrar.ScheduleTask(&Task{Delay: delay, Async: true, Func: fn})
+}
+
+// ScheduleRepeatingAsync is like Server.ScheduleRepeatingAsync, but the task
+// is owned by the plugin of the registrar.
+func (registrar *Registrar) ScheduleRepeatingAsync(interval time.Duration, fn func()) *Task {
+	return registrar.ScheduleTask(&Task{Delay: interval, Interval: interval, Async: true, Func: fn})
+}
+
+// RunAsync is like Server.RunAsync, but the task is owned by the plugin of the
+// registrar.
+func (registrar *Registrar) RunAsync(fn func()) *Task {
+	return registrar.ScheduleTask(&Task{Async: true, Func: fn})
+}
+
+// addTask schedules task without assigning it an owner.
+func (server *Server) addTask(task *Task) {
+	task.next = time.Now().Add(task.Delay)
+	server.scheduler.lock.Lock()
+	server.scheduler.tasks = append(server.scheduler.tasks, task)
+	server.scheduler.lock.Unlock()
+}
+
+// cancelPluginTasks cancels all tasks owned by plugin.
+func (server *Server) cancelPluginTasks(plugin Plugin) {
+	server.scheduler.lock.Lock()
+	defer server.scheduler.lock.Unlock()
+
+	tasks := server.scheduler.tasks[:0]
+	for _, task := range server.scheduler.tasks {
+		if task.Owner == plugin {
+			task.Cancel()
+		} else {
+			tasks = append(tasks, task)
+		}
+	}
+
+	server.scheduler.tasks = tasks
+}
+
+// runTasks runs the tasks that are due. It is called by the update loop.
+func (server *Server) runTasks() {
+	now := time.Now()
+	var due []*Task
+
+	server.scheduler.lock.Lock()
+	tasks := server.scheduler.tasks[:0]
+	for _, task := range server.scheduler.tasks {
+		if task.Cancelled() {
+			continue
+		}
+
+		if now.Before(task.next) {
+			tasks = append(tasks, task)
+			continue
+		}
+
+		due = append(due, task)
+		if task.Interval > 0 {
+			task.next = task.next.Add(task.Interval)
+			if task.next.Before(now) {
+				task.next = now.Add(task.Interval)
+			}
+
+			tasks = append(tasks, task)
+		}
+	}
+
+	for i := len(tasks); i < len(server.scheduler.tasks); i++ {
+		server.scheduler.tasks[i] = nil
+	}
+
+	server.scheduler.tasks = tasks
+	server.scheduler.lock.Unlock()
+
+	for _, task := range due {
+		if !task.Async {
+			server.runTask(task)
+		} else if atomic.CompareAndSwapUint32(&task.running, 0, 1) {
+			select {
+			case server.scheduler.queue <- task:
+			default:
+				// Do not block the update loop if the workers are busy.
+				if !server.startTask() {
+					atomic.StoreUint32(&task.running, 0)
+					continue
+				}
+
+				go func(task *Task) {
+					defer server.tasks.Done()
+					server.runTask(task)
+				}(task)
+			}
+		}
+	}
+}
+
+// runTask calls the function of task, unless it has been cancelled.
+func (server *Server) runTask(task *Task) {
+	defer atomic.StoreUint32(&task.running, 0)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("runTask: %v\n", r)
+		}
+	}()
+
+	if !task.Cancelled() {
+		task.Func()
+	}
+}
+
+// startWorkers starts the goroutines that run the async tasks until the
+// server is shut down.
+func (server *Server) startWorkers() {
+	for i := 0; i < asyncWorkers; i++ {
+		if !server.startTask() {
+			return
+		}
+
+		go func() {
+			defer server.tasks.Done()
+			for {
+				select {
+				case task := <-server.scheduler.queue:
+					server.runTask(task)
+				case <-server.stopChan:
+					return
+				}
+			}
+		}()
+	}
+}
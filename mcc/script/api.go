@@ -0,0 +1,560 @@
+package script
+
+import (
+	"log"
+	"strings"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	playerType = "mcc.player"
+	entityType = "mcc.entity"
+	levelType  = "mcc.level"
+	senderType = "mcc.sender"
+
+	// scriptKey is the registry key of the script that owns a state.
+	scriptKey = "mcc.script"
+)
+
+var eventTypes = map[string]int{
+	"player-login": mcc.EventTypePlayerLogin,
+	"player-join":  mcc.EventTypePlayerJoin,
+	"player-quit":  mcc.EventTypePlayerQuit,
+	"player-chat":  mcc.EventTypePlayerChat,
+	"player-kick":  mcc.EventTypePlayerKick,
+	"block-place":  mcc.EventTypeBlockPlace,
+	"block-break":  mcc.EventTypeBlockBreak,
+	"entity-move":  mcc.EventTypeEntityMove,
+	"level-load":   mcc.EventTypeLevelLoad,
+	"level-unload": mcc.EventTypeLevelUnload,
+	"level-save":   mcc.EventTypeLevelSave,
+	"server-start": mcc.EventTypeServerStart,
+	"server-stop":  mcc.EventTypeServerStop,
+}
+
+var entityMethods = map[string]lua.LGFunction{
+	"name":      entityName,
+	"location":  entityLocation,
+	"teleport":  entityTeleport,
+	"level":     entityLevel,
+	"set_level": entitySetLevel,
+}
+
+var playerMethods = map[string]lua.LGFunction{
+	"message":        playerMessage,
+	"kick":           playerKick,
+	"held_block":     playerHeldBlock,
+	"set_held_block": playerSetHeldBlock,
+}
+
+var levelMethods = map[string]lua.LGFunction{
+	"name":      levelName,
+	"size":      levelSize,
+	"spawn":     levelSpawn,
+	"get_block": levelGetBlock,
+	"set_block": levelSetBlock,
+	"players":   levelPlayers,
+}
+
+var senderMethods = map[string]lua.LGFunction{
+	"name":    senderName,
+	"message": senderMessage,
+	"player":  senderPlayer,
+}
+
+// registerAPI registers the functions and types that are available to s.
+func registerAPI(L *lua.LState, s *script) {
+	server := s.engine.server
+	owner := L.NewUserData()
+	owner.Value = s
+	L.SetField(L.Get(lua.RegistryIndex), scriptKey, owner)
+
+	registerType(L, entityType, entityMethods)
+	registerType(L, playerType, entityMethods, playerMethods)
+	registerType(L, levelType, levelMethods)
+	registerType(L, senderType, senderMethods)
+
+	priorities := L.NewTable()
+	priorities.RawSetString("lowest", lua.LNumber(mcc.PriorityLowest))
+	priorities.RawSetString("low", lua.LNumber(mcc.PriorityLow))
+	priorities.RawSetString("normal", lua.LNumber(mcc.PriorityNormal))
+	priorities.RawSetString("high", lua.LNumber(mcc.PriorityHigh))
+	priorities.RawSetString("highest", lua.LNumber(mcc.PriorityHighest))
+	priorities.RawSetString("monitor", lua.LNumber(mcc.PriorityMonitor))
+	L.SetGlobal("priority", priorities)
+
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		args := make([]string, L.GetTop())
+		for i := range args {
+			args[i] = L.ToStringMeta(L.Get(i + 1)).String()
+		}
+
+		log.Printf("%s: %s\n", s.name, strings.Join(args, "\t"))
+		return 0
+	}))
+
+	L.SetGlobal("command", L.NewFunction(func(L *lua.LState) int {
+		addCommand(L, s)
+		return 0
+	}))
+
+	L.SetGlobal("on", L.NewFunction(func(L *lua.LState) int {
+		addHandler(L, s)
+		return 0
+	}))
+
+	L.SetGlobal("broadcast", L.NewFunction(func(L *lua.LState) int {
+		server.BroadcastMessage(L.CheckString(1))
+		return 0
+	}))
+
+	L.SetGlobal("players", L.NewFunction(func(L *lua.LState) int {
+		table := L.NewTable()
+		server.ForEachPlayer(func(player *mcc.Player) {
+			table.Append(newPlayer(L, player))
+		})
+
+		L.Push(table)
+		return 1
+	}))
+
+	L.SetGlobal("find_player", L.NewFunction(func(L *lua.LState) int {
+		if player := server.FindPlayer(L.CheckString(1)); player != nil {
+			L.Push(newPlayer(L, player))
+		} else {
+			L.Push(lua.LNil)
+		}
+
+		return 1
+	}))
+
+	L.SetGlobal("entities", L.NewFunction(func(L *lua.LState) int {
+		table := L.NewTable()
+		server.ForEachEntity(func(entity *mcc.Entity) {
+			table.Append(newEntity(L, server, entity))
+		})
+
+		L.Push(table)
+		return 1
+	}))
+
+	L.SetGlobal("levels", L.NewFunction(func(L *lua.LState) int {
+		table := L.NewTable()
+		server.ForEachLevel(func(level *mcc.Level) {
+			table.Append(newUserData(L, levelType, level))
+		})
+
+		L.Push(table)
+		return 1
+	}))
+
+	L.SetGlobal("find_level", L.NewFunction(func(L *lua.LState) int {
+		if level := server.FindLevel(L.CheckString(1)); level != nil {
+			L.Push(newUserData(L, levelType, level))
+		} else {
+			L.Push(lua.LNil)
+		}
+
+		return 1
+	}))
+}
+
+func registerType(L *lua.LState, name string, methods ...map[string]lua.LGFunction) {
+	mt := L.NewTypeMetatable(name)
+	index := L.NewTable()
+	for _, m := range methods {
+		L.SetFuncs(index, m)
+	}
+
+	L.SetField(mt, "__index", index)
+}
+
+// runOnTick runs fn on the next tick of the update loop, like the changes to
+// the world that are requested by RPC plugins. The task is owned by the
+// script of L, so it is dropped if the script is disabled first.
+func runOnTick(L *lua.LState, fn func()) {
+	s := L.GetField(L.Get(lua.RegistryIndex), scriptKey).(*lua.LUserData).Value.(*script)
+	s.engine.server.Registrar(s).RunOnTick(fn)
+}
+
+func newUserData(L *lua.LState, typ string, value interface{}) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = value
+	L.SetMetatable(ud, L.GetTypeMetatable(typ))
+	return ud
+}
+
+func newPlayer(L *lua.LState, player *mcc.Player) *lua.LUserData {
+	return newUserData(L, playerType, player)
+}
+
+// newEntity returns the player that controls entity, or entity itself if it
+// is not a player.
+func newEntity(L *lua.LState, server *mcc.Server, entity *mcc.Entity) *lua.LUserData {
+	if player := server.FindPlayer(entity.Name()); player != nil && player.Entity == entity {
+		return newPlayer(L, player)
+	}
+
+	return newUserData(L, entityType, entity)
+}
+
+// addCommand implements command{name=, description=, usage=, permissions=,
+// handler=}. The handler is called with the sender and the arguments.
+func addCommand(L *lua.LState, s *script) {
+	table := L.CheckTable(1)
+	name, _ := table.RawGetString("name").(lua.LString)
+	fn, _ := table.RawGetString("handler").(*lua.LFunction)
+	if len(name) == 0 || fn == nil {
+		L.ArgError(1, "name and handler are required")
+	}
+
+	description, _ := table.RawGetString("description").(lua.LString)
+	usage, _ := table.RawGetString("usage").(lua.LString)
+	permissions, _ := table.RawGetString("permissions").(lua.LNumber)
+
+	owner := L
+	s.engine.server.AddCommand(&mcc.Command{
+		Name:        string(name),
+		Description: string(description),
+		Usage:       string(usage),
+		Permissions: uint32(permissions),
+		Owner:       s,
+		Handler: func(sender mcc.CommandSender, command *mcc.Command, message string) {
+			err := s.call(CommandTimeout, func(L *lua.LState) error {
+				if L != owner {
+					return errUnloaded
+				}
+
+				return L.CallByParam(lua.P{Fn: fn, Protect: true},
+					newUserData(L, senderType, sender), lua.LString(message))
+			})
+
+			if err != nil {
+				log.Printf("script: %s: /%s: %s\n", s.name, command.Name, err)
+				sender.SendMessage("Command failed!")
+			}
+		},
+	})
+}
+
+// addHandler implements on(event, handler[, priority[, ignore_cancelled]]).
+// The handler is called with a table that contains the fields of the event.
+// Handlers of cancellable events can set cancel, message and reason.
+func addHandler(L *lua.LState, s *script) {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	priority := L.OptInt(3, mcc.PriorityNormal)
+	ignoreCancelled := L.OptBool(4, false)
+
+	eventType, ok := eventTypes[name]
+	if !ok {
+		L.ArgError(1, "unknown event "+name)
+	}
+
+	owner := L
+	server := s.engine.server
+	server.RegisterHandler(&mcc.Handler{
+		EventType:       eventType,
+		Priority:        priority,
+		IgnoreCancelled: ignoreCancelled,
+		Owner:           s,
+		Func: func(eventType int, event interface{}) {
+			err := s.call(EventTimeout, func(L *lua.LState) error {
+				if L != owner {
+					return errUnloaded
+				}
+
+				table := eventTable(L, server, event)
+				if err := L.CallByParam(lua.P{Fn: fn, Protect: true}, table); err != nil {
+					return err
+				}
+
+				applyEvent(table, event)
+				return nil
+			})
+
+			if err != nil {
+				log.Printf("script: %s: %s: %s\n", s.name, name, err)
+			}
+		},
+	})
+}
+
+// eventTable converts event to a Lua table.
+func eventTable(L *lua.LState, server *mcc.Server, event interface{}) *lua.LTable {
+	table := L.NewTable()
+	setPlayer := func(player *mcc.Player) {
+		table.RawSetString("player", newPlayer(L, player))
+	}
+
+	setBlock := func(level *mcc.Level, x, y, z int) {
+		table.RawSetString("level", newUserData(L, levelType, level))
+		table.RawSetString("x", lua.LNumber(x))
+		table.RawSetString("y", lua.LNumber(y))
+		table.RawSetString("z", lua.LNumber(z))
+	}
+
+	if cancellable, ok := event.(mcc.Cancellable); ok {
+		table.RawSetString("cancel", lua.LBool(cancellable.Cancelled()))
+	}
+
+	switch event := event.(type) {
+	case *mcc.EventPlayerLogin:
+		setPlayer(event.Player)
+		table.RawSetString("reason", lua.LString(event.CancelReason))
+	case *mcc.EventPlayerJoin:
+		setPlayer(event.Player)
+	case *mcc.EventPlayerQuit:
+		setPlayer(event.Player)
+	case *mcc.EventPlayerChat:
+		setPlayer(event.Player)
+		table.RawSetString("message", lua.LString(event.Message))
+	case *mcc.EventPlayerKick:
+		setPlayer(event.Player)
+		table.RawSetString("reason", lua.LString(event.Reason))
+	case *mcc.EventBlockPlace:
+		setPlayer(event.Player)
+		setBlock(event.Level, event.X, event.Y, event.Z)
+		table.RawSetString("block", lua.LNumber(event.Block))
+		table.RawSetString("old_block", lua.LNumber(event.OldBlock))
+	case *mcc.EventBlockBreak:
+		setPlayer(event.Player)
+		setBlock(event.Level, event.X, event.Y, event.Z)
+		table.RawSetString("block", lua.LNumber(event.Block))
+	case *mcc.EventEntityMove:
+		table.RawSetString("entity", newEntity(L, server, event.Entity))
+		table.RawSetString("from", locationTable(L, event.From))
+		table.RawSetString("to", locationTable(L, event.To))
+	case *mcc.EventLevelLoad:
+		table.RawSetString("level", newUserData(L, levelType, event.Level))
+	case *mcc.EventLevelUnload:
+		table.RawSetString("level", newUserData(L, levelType, event.Level))
+	case *mcc.EventLevelSave:
+		table.RawSetString("level", newUserData(L, levelType, event.Level))
+	}
+
+	return table
+}
+
+// applyEvent applies the changes made by a handler to event.
+func applyEvent(table *lua.LTable, event interface{}) {
+	cancel := lua.LVAsBool(table.RawGetString("cancel"))
+	message := lua.LVAsString(table.RawGetString("message"))
+	reason := lua.LVAsString(table.RawGetString("reason"))
+
+	switch event := event.(type) {
+	case *mcc.EventPlayerLogin:
+		event.Cancel, event.CancelReason = cancel, reason
+	case *mcc.EventPlayerChat:
+		event.Cancel, event.Message = cancel, message
+	case *mcc.EventPlayerKick:
+		event.Cancel, event.Reason = cancel, reason
+	case *mcc.EventBlockPlace:
+		event.Cancel = cancel
+	case *mcc.EventBlockBreak:
+		event.Cancel = cancel
+	case *mcc.EventEntityMove:
+		event.Cancel = cancel
+	}
+}
+
+func locationTable(L *lua.LState, location mcc.Location) *lua.LTable {
+	table := L.NewTable()
+	table.RawSetString("x", lua.LNumber(location.X))
+	table.RawSetString("y", lua.LNumber(location.Y))
+	table.RawSetString("z", lua.LNumber(location.Z))
+	table.RawSetString("yaw", lua.LNumber(location.Yaw))
+	table.RawSetString("pitch", lua.LNumber(location.Pitch))
+	return table
+}
+
+func checkEntity(L *lua.LState) *mcc.Entity {
+	switch value := L.CheckUserData(1).Value.(type) {
+	case *mcc.Player:
+		return value.Entity
+	case *mcc.Entity:
+		return value
+	}
+
+	L.ArgError(1, "entity expected")
+	return nil
+}
+
+func checkPlayer(L *lua.LState) *mcc.Player {
+	if player, ok := L.CheckUserData(1).Value.(*mcc.Player); ok {
+		return player
+	}
+
+	L.ArgError(1, "player expected")
+	return nil
+}
+
+func checkLevel(L *lua.LState, n int) *mcc.Level {
+	if level, ok := L.CheckUserData(n).Value.(*mcc.Level); ok {
+		return level
+	}
+
+	L.ArgError(n, "level expected")
+	return nil
+}
+
+func checkSender(L *lua.LState) mcc.CommandSender {
+	if sender, ok := L.CheckUserData(1).Value.(mcc.CommandSender); ok {
+		return sender
+	}
+
+	L.ArgError(1, "sender expected")
+	return nil
+}
+
+func checkBlock(L *lua.LState, n int) mcc.BlockID {
+	block := L.CheckInt(n)
+	if block < 0 || block > mcc.BlockMax {
+		L.ArgError(n, "invalid block")
+	}
+
+	return mcc.BlockID(block)
+}
+
+func entityName(L *lua.LState) int {
+	L.Push(lua.LString(checkEntity(L).Name()))
+	return 1
+}
+
+func entityLocation(L *lua.LState) int {
+	loc := checkEntity(L).Location()
+	L.Push(lua.LNumber(loc.X))
+	L.Push(lua.LNumber(loc.Y))
+	L.Push(lua.LNumber(loc.Z))
+	L.Push(lua.LNumber(loc.Yaw))
+	L.Push(lua.LNumber(loc.Pitch))
+	return 5
+}
+
+func entityTeleport(L *lua.LState) int {
+	entity := checkEntity(L)
+	loc := entity.Location()
+	loc = mcc.Location{
+		X:     float64(L.CheckNumber(2)),
+		Y:     float64(L.CheckNumber(3)),
+		Z:     float64(L.CheckNumber(4)),
+		Yaw:   float64(L.OptNumber(5, lua.LNumber(loc.Yaw))),
+		Pitch: float64(L.OptNumber(6, lua.LNumber(loc.Pitch))),
+	}
+
+	runOnTick(L, func() { entity.Teleport(loc) })
+	return 0
+}
+
+func entityLevel(L *lua.LState) int {
+	if level := checkEntity(L).Level(); level != nil {
+		L.Push(newUserData(L, levelType, level))
+	} else {
+		L.Push(lua.LNil)
+	}
+
+	return 1
+}
+
+func entitySetLevel(L *lua.LState) int {
+	entity, level := checkEntity(L), checkLevel(L, 2)
+	runOnTick(L, func() { entity.TeleportLevel(level) })
+	return 0
+}
+
+func playerMessage(L *lua.LState) int {
+	checkPlayer(L).SendMessage(L.CheckString(2))
+	return 0
+}
+
+func playerKick(L *lua.LState) int {
+	checkPlayer(L).Kick(L.OptString(2, "Kicked!"))
+	return 0
+}
+
+func playerHeldBlock(L *lua.LState) int {
+	L.Push(lua.LNumber(checkPlayer(L).HeldBlock()))
+	return 1
+}
+
+func playerSetHeldBlock(L *lua.LState) int {
+	checkPlayer(L).SetHeldBlock(checkBlock(L, 2), L.OptBool(3, false))
+	return 0
+}
+
+func levelName(L *lua.LState) int {
+	L.Push(lua.LString(checkLevel(L, 1).Name))
+	return 1
+}
+
+func levelSize(L *lua.LState) int {
+	level := checkLevel(L, 1)
+	L.Push(lua.LNumber(level.Width))
+	L.Push(lua.LNumber(level.Height))
+	L.Push(lua.LNumber(level.Length))
+	return 3
+}
+
+func levelSpawn(L *lua.LState) int {
+	spawn := checkLevel(L, 1).Spawn
+	L.Push(lua.LNumber(spawn.X))
+	L.Push(lua.LNumber(spawn.Y))
+	L.Push(lua.LNumber(spawn.Z))
+	return 3
+}
+
+func levelGetBlock(L *lua.LState) int {
+	level := checkLevel(L, 1)
+	x, y, z := L.CheckInt(2), L.CheckInt(3), L.CheckInt(4)
+	if !level.InBounds(x, y, z) {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(lua.LNumber(level.GetBlock(x, y, z)))
+	return 1
+}
+
+func levelSetBlock(L *lua.LState) int {
+	level := checkLevel(L, 1)
+	x, y, z := L.CheckInt(2), L.CheckInt(3), L.CheckInt(4)
+	block := checkBlock(L, 5)
+	if !level.InBounds(x, y, z) {
+		L.ArgError(2, "coordinates out of bounds")
+	}
+
+	runOnTick(L, func() { level.SetBlock(x, y, z, block) })
+	return 0
+}
+
+func levelPlayers(L *lua.LState) int {
+	table := L.NewTable()
+	checkLevel(L, 1).ForEachPlayer(func(player *mcc.Player) {
+		table.Append(newPlayer(L, player))
+	})
+
+	L.Push(table)
+	return 1
+}
+
+func senderName(L *lua.LState) int {
+	L.Push(lua.LString(checkSender(L).Name()))
+	return 1
+}
+
+func senderMessage(L *lua.LState) int {
+	checkSender(L).SendMessage(L.CheckString(2))
+	return 0
+}
+
+func senderPlayer(L *lua.LState) int {
+	if player, ok := checkSender(L).(*mcc.Player); ok {
+		L.Push(newPlayer(L, player))
+	} else {
+		L.Push(lua.LNil)
+	}
+
+	return 1
+}
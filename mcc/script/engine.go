@@ -0,0 +1,415 @@
+// Package script runs Lua scripts as lightweight plugins.
+//
+// Each script in the scripts directory is registered as a plugin named after
+// its file. Scripts run in a sandbox without access to the file system or
+// the operating system, and every call into a script is limited in time, so
+// that a runaway loop cannot block the server. Scripts are reloaded when
+// their file changes.
+package script
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	// LoadTimeout is the maximum duration of the top-level code of a
+	// script.
+	LoadTimeout = time.Second
+
+	// CommandTimeout is the maximum duration of a command handler.
+	CommandTimeout = time.Second
+
+	// EventTimeout is the maximum duration of an event handler.
+	EventTimeout = 50 * time.Millisecond
+
+	pollInterval = time.Second
+
+	// maxRepSize is the maximum size of a string returned by string.rep.
+	maxRepSize = 1 << 20
+)
+
+var (
+	errBusy     = errors.New("script is busy")
+	errUnloaded = errors.New("script is not loaded")
+)
+
+// Engine loads the scripts in a directory and reloads them when they
+// change.
+type Engine struct {
+	server *mcc.Server
+	dir    string
+
+	lock    sync.Mutex
+	scripts map[string]*script
+
+	// stopLock is separate from lock, which is held by scan while it loads
+	// plugins.
+	stopLock sync.Mutex
+	stop     chan struct{}
+	stopped  bool
+}
+
+// NewEngine returns a new Engine that loads the scripts in dir.
+func NewEngine(server *mcc.Server, dir string) *Engine {
+	return &Engine{
+		server:  server,
+		dir:     dir,
+		scripts: make(map[string]*script),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start registers the engine as a plugin, which loads all scripts and
+// watches the directory for changes until the server stops.
+func (engine *Engine) Start() {
+	if err := engine.server.AddPlugin(engine); err != nil {
+		log.Printf("script: %s\n", err)
+	}
+}
+
+// Name returns the name of the engine plugin.
+func (engine *Engine) Name() string {
+	return "scripts"
+}
+
+// Enable loads all scripts and starts watching the directory for changes.
+// The scripts are loaded by a separate goroutine, since plugins cannot be
+// loaded while another plugin is being enabled.
+func (engine *Engine) Enable(server *mcc.Server) error {
+	engine.stopLock.Lock()
+	engine.stop = make(chan struct{})
+	engine.stopped = false
+	stop := engine.stop
+	engine.stopLock.Unlock()
+
+	server.Registrar(engine).Subscribe(mcc.PriorityMonitor, func(event *mcc.EventServerStop) {
+		engine.Stop()
+	})
+
+	go func() {
+		engine.scan()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				engine.scan()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Disable stops watching the directory.
+func (engine *Engine) Disable(server *mcc.Server) {
+	engine.Stop()
+}
+
+// Stop stops watching the directory. The loaded scripts are disabled with
+// the rest of the plugins.
+func (engine *Engine) Stop() {
+	engine.stopLock.Lock()
+	defer engine.stopLock.Unlock()
+	if !engine.stopped {
+		engine.stopped = true
+		close(engine.stop)
+	}
+}
+
+// scan loads the new scripts, reloads the modified ones and disables the
+// ones that were removed.
+func (engine *Engine) scan() {
+	files, err := ioutil.ReadDir(engine.dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("script: %s\n", err)
+		return
+	}
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	var added []mcc.Plugin
+	present := make(map[string]bool)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != ".lua" {
+			continue
+		}
+
+		present[name] = true
+		s := engine.scripts[name]
+		if s == nil {
+			s = newScript(engine, name, filepath.Join(engine.dir, name))
+			s.modTime = file.ModTime()
+			engine.scripts[name] = s
+			added = append(added, s)
+			continue
+		}
+
+		if !file.ModTime().Equal(s.modTime) {
+			s.modTime = file.ModTime()
+			engine.reload(s)
+		}
+	}
+
+	for _, err := range engine.server.LoadPlugins(added) {
+		log.Printf("script: %s\n", err)
+	}
+
+	for name, s := range engine.scripts {
+		if !present[name] && s.loaded() {
+			if err := engine.server.DisablePlugin(name); err != nil {
+				log.Printf("script: %s\n", err)
+			}
+		}
+	}
+}
+
+// reload disables s, if it is loaded, and enables it again.
+func (engine *Engine) reload(s *script) {
+	if s.loaded() {
+		if err := engine.server.DisablePlugin(s.name); err != nil {
+			log.Printf("script: %s\n", err)
+			return
+		}
+	}
+
+	if err := engine.server.EnablePlugin(s.name); err != nil {
+		log.Printf("script: %s\n", err)
+		return
+	}
+
+	log.Printf("Reloaded script %s\n", s.name)
+}
+
+// script is a plugin that runs a Lua script.
+type script struct {
+	engine  *Engine
+	name    string
+	path    string
+	modTime time.Time
+
+	// sem serializes the calls into the Lua state, which is not safe for
+	// concurrent use.
+	sem   chan struct{}
+	state *lua.LState
+}
+
+func newScript(engine *Engine, name, path string) *script {
+	return &script{
+		engine: engine,
+		name:   name,
+		path:   path,
+		sem:    make(chan struct{}, 1),
+	}
+}
+
+func (s *script) Name() string {
+	return s.name
+}
+
+// Enable loads the script and runs its top-level code.
+func (s *script) Enable(server *mcc.Server) error {
+	code, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	state := newState(s)
+	fn, err := state.Load(strings.NewReader(string(code)), s.name)
+	if err != nil {
+		state.Close()
+		return err
+	}
+
+	s.sem <- struct{}{}
+	s.state = state
+	<-s.sem
+
+	err = s.call(LoadTimeout, func(L *lua.LState) error {
+		return L.CallByParam(lua.P{Fn: fn, Protect: true})
+	})
+
+	if err != nil {
+		s.close()
+		return err
+	}
+
+	return nil
+}
+
+// Disable closes the Lua state of the script.
+func (s *script) Disable(server *mcc.Server) {
+	s.close()
+}
+
+func (s *script) close() {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	if s.state != nil {
+		s.state.Close()
+		s.state = nil
+	}
+}
+
+func (s *script) loaded() bool {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	return s.state != nil
+}
+
+// call runs fn with exclusive access to the Lua state. The Lua code run by fn
+// is aborted after timeout. If the state is in use for longer than timeout,
+// call fails without running fn.
+func (s *script) call(timeout time.Duration, fn func(L *lua.LState) error) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case s.sem <- struct{}{}:
+	case <-timer.C:
+		return errBusy
+	}
+	defer func() { <-s.sem }()
+
+	if s.state == nil {
+		return errUnloaded
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	if err := fn(s.state); err != nil {
+		// Drop the stack trace, which does not fit in a log line.
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			return errors.New(apiErr.Object.String())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// newState returns a new sandboxed Lua state for s.
+func newState(s *script) *lua.LState {
+	L := lua.NewState(lua.Options{
+		CallStackSize:   128,
+		RegistrySize:    1024,
+		RegistryMaxSize: 64 * 1024,
+		SkipOpenLibs:    true,
+	})
+
+	libs := []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	unsafe := []string{
+		"collectgarbage", "dofile", "load", "loadfile", "loadstring",
+		"module", "require", "_printregs",
+	}
+
+	for _, name := range unsafe {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	// The string functions that can allocate arbitrarily large strings are
+	// replaced with ones that limit the size of the result.
+	strlib := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	format := strlib.RawGetString("format").(*lua.LFunction).GFunction
+	L.SetField(strlib, "rep", L.NewFunction(strRep))
+	L.SetField(strlib, "format", L.NewFunction(func(L *lua.LState) int {
+		if !checkFormat(L.CheckString(1)) {
+			L.ArgError(1, "invalid format (width or precision too long)")
+		}
+
+		return format(L)
+	}))
+
+	registerAPI(L, s)
+	return L
+}
+
+// strRep implements string.rep, and fails if the result is larger than
+// maxRepSize.
+func strRep(L *lua.LState) int {
+	str, n := L.CheckString(1), L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+
+	if len(str) > 0 && n > maxRepSize/len(str) {
+		L.RaiseError("resulting string too large")
+	}
+
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+// checkFormat reports whether the widths and precisions in format have at
+// most two digits, as in the reference implementation of Lua.
+func checkFormat(format string) bool {
+	digits := func(i int) int {
+		j := i
+		for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+			j++
+		}
+
+		return j
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		i++
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+
+		j := digits(i)
+		if j-i > 2 {
+			return false
+		}
+
+		if i = j; i < len(format) && format[i] == '.' {
+			if j = digits(i + 1); j-i-1 > 2 {
+				return false
+			}
+
+			i = j
+		}
+	}
+
+	return true
+}
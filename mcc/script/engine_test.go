@@ -0,0 +1,201 @@
+package script
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc"
+	"github.com/andreasgoulas/go-mcc/mcc/internal/mcctest"
+)
+
+// newTestEngine starts a server and returns an Engine for a temporary
+// scripts directory.
+func newTestEngine(t *testing.T) (*Engine, func()) {
+	server, stop := mcctest.NewServer(t)
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+
+	return NewEngine(server, dir), func() {
+		stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// load writes a script and scans the scripts directory. It returns the
+// status of the script.
+func load(t *testing.T, engine *Engine, name, code string) mcc.PluginStatus {
+	path := filepath.Join(engine.dir, name)
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		// Make sure that a rewritten file is seen as modified.
+		modTime = info.ModTime().Add(time.Second)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.scan()
+	for _, status := range engine.server.Plugins() {
+		if status.Info.Name == name {
+			return status
+		}
+	}
+
+	t.Fatalf("script %s not registered", name)
+	return mcc.PluginStatus{}
+}
+
+func TestSandbox(t *testing.T) {
+	engine, stop := newTestEngine(t)
+	defer stop()
+
+	status := load(t, engine, "sandbox.lua", `
+		for _, name in ipairs({"os", "io", "debug", "package", "load",
+			"loadfile", "loadstring", "dofile", "require", "collectgarbage"}) do
+			assert(_G[name] == nil, name .. " is available")
+		end
+
+		assert(string.rep("ab", 3) == "ababab")
+		assert(("x"):rep(0) == "")
+		assert(not pcall(string.rep, "x", 2^31))
+		assert(not pcall(("x").rep, "x", 2^31))
+
+		assert(string.format("%5.2f|%%|%-3d", 1, 2) == " 1.00|%|2  ")
+		assert(not pcall(string.format, "%2147483647d", 1))
+		assert(not pcall(string.format, "%.999s", "x"))
+	`)
+
+	if status.State != mcc.PluginEnabled {
+		t.Fatalf("got state %d: %v", status.State, status.Err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	engine, stop := newTestEngine(t)
+	defer stop()
+
+	start := time.Now()
+	status := load(t, engine, "loop.lua", "while true do end")
+	if status.State != mcc.PluginFailed {
+		t.Fatalf("got state %d, want failed", status.State)
+	}
+
+	if elapsed := time.Since(start); elapsed > LoadTimeout+time.Second {
+		t.Errorf("script ran for %s", elapsed)
+	}
+
+	status = load(t, engine, "command.lua", `
+		command{name="loop", handler=function(sender, args)
+			while true do end
+		end}
+	`)
+
+	if status.State != mcc.PluginEnabled {
+		t.Fatalf("got state %d: %v", status.State, status.Err)
+	}
+
+	sender := mcctest.NewSender(engine.server)
+	engine.server.ExecuteCommand(sender, "loop")
+	if msg := sender.Message(t); msg != "Command failed!" {
+		t.Errorf("got message %q", msg)
+	}
+}
+
+func TestReload(t *testing.T) {
+	engine, stop := newTestEngine(t)
+	defer stop()
+
+	version := func() string {
+		sender := mcctest.NewSender(engine.server)
+		engine.server.ExecuteCommand(sender, "version")
+		return sender.Message(t)
+	}
+
+	const code = `command{name="version", handler=function(sender, args)
+		sender:message("%s")
+	end}`
+
+	load(t, engine, "version.lua", fmt.Sprintf(code, "1"))
+	if v := version(); v != "1" {
+		t.Fatalf("got version %q, want 1", v)
+	}
+
+	status := load(t, engine, "version.lua", fmt.Sprintf(code, "2.0"))
+	if status.State != mcc.PluginEnabled {
+		t.Fatalf("got state %d: %v", status.State, status.Err)
+	}
+
+	if v := version(); v != "2.0" {
+		t.Fatalf("got version %q after reload, want 2.0", v)
+	}
+
+	if err := os.Remove(filepath.Join(engine.dir, "version.lua")); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.scan()
+	if v := version(); v != "Unknown command!" {
+		t.Fatalf("got %q after removal", v)
+	}
+}
+
+func TestSetBlock(t *testing.T) {
+	engine, stop := newTestEngine(t)
+	defer stop()
+
+	status := load(t, engine, "blocks.lua", `
+		local level = find_level("main")
+		level:set_block(1, 2, 3, 41)
+		assert(level:get_block(-1, 2, 3) == nil)
+		assert(not pcall(level.set_block, level, -1, 2, 3, 1))
+		assert(not pcall(level.set_block, level, 1, 2, 3, 1000))
+		assert(not pcall(level.set_block, level, 1, 2, 3, -1))
+	`)
+
+	if status.State != mcc.PluginEnabled {
+		t.Fatalf("got state %d: %v", status.State, status.Err)
+	}
+
+	// The change is applied on the next tick.
+	level := engine.server.MainLevel
+	mcctest.WaitFor(t, "the block change", func() bool {
+		return level.GetBlock(1, 2, 3) == mcc.BlockGold
+	})
+}
+
+func TestStart(t *testing.T) {
+	engine, stop := newTestEngine(t)
+	defer stop()
+
+	path := filepath.Join(engine.dir, "hello.lua")
+	if err := ioutil.WriteFile(path, []byte(`print("hello")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Start()
+	mcctest.WaitFor(t, "the script to load", func() bool {
+		return engine.server.FindPlugin("hello.lua") != nil
+	})
+
+	if err := engine.server.DisablePlugin(engine.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-engine.stop:
+	default:
+		t.Error("engine was not stopped with its plugin")
+	}
+}
@@ -1,17 +1,14 @@
 package mcc
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"io/ioutil"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
-	"net/url"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +18,9 @@ const (
 	UpdateInterval    = 50 * time.Millisecond
 	HeartbeatInterval = 45 * time.Second
 	SaveInterval      = 5 * time.Minute
+	HandshakeTimeout  = 10 * time.Second
+	ShutdownCountdown = 5 * time.Second
+	StopTimeout       = 30 * time.Second
 )
 
 // Config is used to configure a server.
@@ -33,30 +33,59 @@ type Config struct {
 	MaxPlayers int    `json:"max-players"`
 	Heartbeat  string `json:"heartbeat,omitempty"`
 	MainLevel  string `json:"main-level"`
+
+	Heartbeats []string `json:"heartbeats,omitempty"`
+	SaltFile   string   `json:"salt-file,omitempty"`
+
+	MetricsAddr string `json:"metrics-addr,omitempty"`
+
+	ProxyProtocol bool     `json:"proxy-protocol,omitempty"`
+	ProxyTrusted  []string `json:"proxy-trusted,omitempty"`
+
+	CheckMovement bool    `json:"check-movement,omitempty"`
+	ViewDistance  float64 `json:"view-distance,omitempty"`
+
+	MaxConnectionsPerIP     int `json:"max-connections-per-ip,omitempty"`
+	MaxConnectionsPerMinute int `json:"max-connections-per-minute,omitempty"`
+	ThrottleBlockTime       int `json:"throttle-block-time,omitempty"`
+	LoginTimeout            int `json:"login-timeout,omitempty"`
+	ReadTimeout             int `json:"read-timeout,omitempty"`
+
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+
+	trustedProxies []*net.IPNet
 }
 
-// Plugin is the interface that must be implemented by all plugins.
-type Plugin interface {
-	Name() string
-	Enable(*Server)
-	Disable(*Server)
+// ListenerConfig is used to configure an address that the server listens on.
+// The options that are not set are inherited from Config.
+type ListenerConfig struct {
+	Addr          string `json:"addr"`
+	Verify        *bool  `json:"verify-names,omitempty"`
+	ProxyProtocol *bool  `json:"proxy-protocol,omitempty"`
 }
 
 // Server represents a game server.
 type Server struct {
-	Config    *Config
 	MainLevel *Level
-	URL       string
 	Colors    []ColorDesc
 	Hotkeys   []HotkeyDesc
 
 	playerCount int32
-	salt        [16]byte
+	heartbeats  heartbeats
+
+	metrics       *Metrics
+	stats         serverMetrics
+	metricsServer *http.Server
+	ticks         tickMonitor
+	scheduler     scheduler
+
+	config     atomic.Value
+	configLock sync.Mutex
 
 	commands     map[string]*Command
 	commandsLock sync.RWMutex
 
-	handlers     map[int][]EventHandler
+	handlers     map[int][]*Handler
 	handlersLock sync.RWMutex
 
 	generators     map[string]GeneratorFunc
@@ -68,33 +97,47 @@ type Server struct {
 
 	entities     []*Entity
 	entitiesLock sync.RWMutex
+	lastEntityID int
 
 	players     []*Player
 	playersLock sync.RWMutex
 
-	plugins     []Plugin
-	pluginsLock sync.RWMutex
+	plugins       []*pluginEntry
+	pluginsLock   sync.RWMutex
+	pluginsOpLock sync.Mutex
+
+	listeners []net.Listener
+	throttle  *throttle
 
-	listener net.Listener
-	stopChan chan bool
+	stopping  uint32
+	stopChan  chan struct{}
+	stopGroup *sync.WaitGroup
+	tasks     sync.WaitGroup
 
-	updateTicker    *time.Ticker
-	heartbeatTicker *time.Ticker
-	saveTicker      *time.Ticker
+	runningCommands sync.WaitGroup
+	commandsClosed  bool
+	stateLock       sync.Mutex
 }
 
 // NewServer returns a new Server.
 func NewServer(config *Config, storage LevelStorage) *Server {
 	server := &Server{
-		Config:     config,
 		commands:   make(map[string]*Command),
-		handlers:   make(map[int][]EventHandler),
+		handlers:   make(map[int][]*Handler),
 		generators: make(map[string]GeneratorFunc),
 		storage:    storage,
-		stopChan:   make(chan bool),
+		stopChan:   make(chan struct{}),
+		throttle:   newThrottle(),
 	}
 
-	server.generateSalt()
+	// Invalid networks are reported by Validate.
+	config.trustedProxies, _ = parseNetworks(config.ProxyTrusted)
+	server.config.Store(config)
+	server.scheduler.queue = make(chan *Task, asyncQueueSize)
+	server.metrics = NewMetrics()
+	server.stats = newServerMetrics(server)
+	server.heartbeats.load(config.saltFile())
+	server.heartbeats.update(config.heartbeatURLs())
 
 	server.generators["flat"] = NewFlatGenerator
 	mainLevel, err := server.LoadLevel(config.MainLevel)
@@ -115,21 +158,68 @@ func NewServer(config *Config, storage LevelStorage) *Server {
 
 // Start starts the server.
 // When the server is stopped, wg will be notified.
-func (server *Server) Start(wg *sync.WaitGroup) (err error) {
-	addr := net.TCPAddr{Port: server.Config.Port}
-	if server.listener, err = net.ListenTCP("tcp", &addr); err != nil {
-		return
+func (server *Server) Start(wg *sync.WaitGroup) error {
+	configs := server.Config().listenerConfigs()
+	for _, config := range configs {
+		listener, err := net.Listen("tcp", config.Addr)
+		if err != nil {
+			server.closeListeners()
+			return err
+		}
+
+		server.listeners = append(server.listeners, listener)
+	}
+
+	if addr := server.Config().MetricsAddr; len(addr) > 0 {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			server.closeListeners()
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", server.metrics)
+		server.metricsServer = &http.Server{Handler: mux}
+		go server.metricsServer.Serve(listener)
 	}
 
-	go server.run(wg)
-	return
+	wg.Add(1)
+	server.stopGroup = wg
+	server.run(configs)
+
+	event := EventServerStart{server}
+	server.FireEvent(EventTypeServerStart, &event)
+	return nil
+}
+
+func (server *Server) closeListeners() {
+	for _, listener := range server.listeners {
+		listener.Close()
+	}
 }
 
 // Stop stops the server, disconnects all clients, disables all plugins and
-// unloads all levels.
+// unloads all levels. It is like Shutdown with a deadline of StopTimeout.
+// When it is called from a command handler, the wait for the running commands
+// lasts until the deadline, so handlers should call Shutdown from a new
+// goroutine instead.
 func (server *Server) Stop() {
-	server.listener.Close()
-	server.stopChan <- true
+	ctx, cancel := context.WithTimeout(context.Background(), StopTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Stop: %s\n", err)
+	}
+}
+
+// BlockAddr refuses connections from the IP address addr until the specified
+// time.
+func (server *Server) BlockAddr(addr string, until time.Time) {
+	server.throttle.block(addr, until)
+}
+
+// UnblockAddr accepts connections from the IP address addr again.
+func (server *Server) UnblockAddr(addr string) {
+	server.throttle.block(addr, time.Time{})
 }
 
 // BroadcastMessage broadcasts a message to all players.
@@ -240,17 +330,23 @@ func (server *Server) LoadLevel(name string) (*Level, error) {
 
 // SaveLevel saves level.
 func (server *Server) SaveLevel(level *Level) {
+	if err := server.saveLevel(level); err != nil {
+		log.Printf("SaveLevel: %s\n", err.Error())
+	}
+}
+
+func (server *Server) saveLevel(level *Level) error {
 	if server.storage == nil || !level.Dirty {
-		return
+		return nil
 	}
 
 	event := EventLevelSave{level}
 	server.FireEvent(EventTypeLevelSave, &event)
 
+	start := time.Now()
 	err := server.storage.Save(level)
-	if err != nil {
-		log.Printf("SaveLevel: %s\n", err.Error())
-	}
+	server.stats.saveDuration.Observe(time.Since(start).Seconds())
+	return err
 }
 
 // UnloadLevel saves and removes level from the server.
@@ -261,29 +357,23 @@ func (server *Server) UnloadLevel(level *Level) {
 }
 
 // AddEntity adds entity to the server.
-// It returns true on success, or false if the server is full.
-func (server *Server) AddEntity(entity *Entity) bool {
+func (server *Server) AddEntity(entity *Entity) {
 	server.entitiesLock.Lock()
-	defer server.entitiesLock.Unlock()
-
-	entity.id = server.generateID()
-	if entity.id == 0xff {
-		return false
-	}
-
+	server.lastEntityID++
+	entity.id = server.lastEntityID
 	server.entities = append(server.entities, entity)
 	server.ForEachPlayer(func(player *Player) {
 		player.sendAddPlayerList(entity)
 	})
+	server.entitiesLock.Unlock()
 
-	return true
+	event := EventEntitySpawn{entity}
+	server.FireEvent(EventTypeEntitySpawn, &event)
 }
 
 // RemoveEntity removes entity from the server.
 func (server *Server) RemoveEntity(entity *Entity) {
 	server.entitiesLock.Lock()
-	defer server.entitiesLock.Unlock()
-
 	index := -1
 	for i, e := range server.entities {
 		if e == entity {
@@ -293,6 +383,7 @@ func (server *Server) RemoveEntity(entity *Entity) {
 	}
 
 	if index == -1 {
+		server.entitiesLock.Unlock()
 		return
 	}
 
@@ -300,9 +391,15 @@ func (server *Server) RemoveEntity(entity *Entity) {
 	server.entities[len(server.entities)-1] = nil
 	server.entities = server.entities[:len(server.entities)-1]
 
+	server.entitiesLock.Unlock()
+
 	server.ForEachPlayer(func(player *Player) {
 		player.sendRemovePlayerList(entity)
+		player.forgetEntity(entity)
 	})
+
+	event := EventEntityDespawn{entity}
+	server.FireEvent(EventTypeEntityDespawn, &event)
 }
 
 // FindEntity returns the entity with the specified name.
@@ -319,8 +416,8 @@ func (server *Server) FindEntity(name string) *Entity {
 	return nil
 }
 
-// FindEntityByID returns the entity with the specified ID.
-func (server *Server) FindEntityByID(id byte) *Entity {
+// FindEntityByID returns the entity with the specified server-wide ID.
+func (server *Server) FindEntityByID(id int) *Entity {
 	server.entitiesLock.RLock()
 	defer server.entitiesLock.RUnlock()
 
@@ -401,6 +498,26 @@ func (server *Server) AddCommand(command *Command) {
 	server.commandsLock.Unlock()
 }
 
+// RemoveCommand unregisters command.
+func (server *Server) RemoveCommand(command *Command) {
+	server.commandsLock.Lock()
+	if server.commands[command.Name] == command {
+		delete(server.commands, command.Name)
+	}
+	server.commandsLock.Unlock()
+}
+
+// removePluginCommands unregisters all commands owned by plugin.
+func (server *Server) removePluginCommands(plugin Plugin) {
+	server.commandsLock.Lock()
+	for name, command := range server.commands {
+		if command.Owner == plugin {
+			delete(server.commands, name)
+		}
+	}
+	server.commandsLock.Unlock()
+}
+
 // Findcommand returns the command with the specified name.
 func (server *Server) FindCommand(name string) *Command {
 	server.commandsLock.RLock()
@@ -456,23 +573,15 @@ func (server *Server) ExecuteCommand(sender CommandSender, message string) {
 		return
 	}
 
-	go command.Handler(sender, command, message)
-}
-
-// AddHandler registers a handler for the specified event type.
-func (server *Server) AddHandler(eventType int, handler EventHandler) {
-	server.handlersLock.Lock()
-	server.handlers[eventType] = append(server.handlers[eventType], handler)
-	server.handlersLock.Unlock()
-}
-
-// FireEvent dispatches event to the server.
-func (server *Server) FireEvent(eventType int, event interface{}) {
-	server.handlersLock.RLock()
-	for _, handler := range server.handlers[eventType] {
-		handler(eventType, event)
+	if !server.startCommand() {
+		sender.SendMessage("Server is shutting down!")
+		return
 	}
-	server.handlersLock.RUnlock()
+
+	go func() {
+		defer server.runningCommands.Done()
+		command.Handler(sender, command, message)
+	}()
 }
 
 // AddGenerator registers a level generator.
@@ -493,173 +602,156 @@ func (server *Server) NewGenerator(name string, args ...string) Generator {
 	return nil
 }
 
-// AddPlugin registers and enables plugin.
-func (server *Server) AddPlugin(plugin Plugin) {
-	server.pluginsLock.Lock()
-	server.plugins = append(server.plugins, plugin)
-	server.pluginsLock.Unlock()
+func (server *Server) run(configs []ListenerConfig) {
+	server.startTicker(UpdateInterval, func() {
+		timer := server.ticks.begin()
+		server.runTasks()
+		timer.phase("tasks")
 
-	plugin.Enable(server)
-}
+		server.ForEachEntity(func(entity *Entity) {
+			entity.update()
+		})
+		timer.phase("entities")
 
-func (server *Server) generateSalt() {
-	const charset = "abcdefghijklmnopqrstuvwxyz" +
-		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
-		"0123456789"
-	for i := range server.salt {
-		server.salt[i] = charset[rand.Intn(len(charset))]
-	}
-}
+		server.ForEachPlayer(func(player *Player) {
+			player.updateView()
+		})
+		timer.phase("players")
 
-func (server *Server) generateID() byte {
-	for id := byte(0); id < 0xff; id++ {
-		free := true
-		for _, entity := range server.entities {
-			if entity.id == id {
-				free = false
-				break
-			}
-		}
+		server.ForEachLevel(func(level *Level) {
+			level.update(timer)
+		})
 
-		if free {
-			return id
-		}
-	}
-
-	return 0xff
-}
-
-func (server *Server) run(wg *sync.WaitGroup) {
-	wg.Add(1)
-
-	server.updateTicker = time.NewTicker(UpdateInterval)
-	go func() {
-		for range server.updateTicker.C {
-			server.ForEachEntity(func(entity *Entity) {
-				entity.update()
-			})
+		server.stats.tickDuration.Observe(server.ticks.end().Seconds())
+	})
 
+	server.startWorkers()
+	if SaveInterval > 0 {
+		server.startTicker(SaveInterval, func() {
 			server.ForEachLevel(func(level *Level) {
-				level.update()
+				server.SaveLevel(level)
 			})
-		}
-	}()
-
-	if SaveInterval > 0 {
-		server.saveTicker = time.NewTicker(SaveInterval)
-		go func() {
-			for range server.saveTicker.C {
-				server.ForEachLevel(func(level *Level) {
-					server.SaveLevel(level)
-				})
-			}
-		}()
+		})
 	}
 
-	if HeartbeatInterval > 0 && len(server.Config.Heartbeat) > 0 {
-		server.heartbeatTicker = time.NewTicker(HeartbeatInterval)
-		go func() {
-			server.sendHeartbeat()
-			for range server.heartbeatTicker.C {
-				server.sendHeartbeat()
-			}
-		}()
+	if HeartbeatInterval > 0 {
+		server.startTicker(heartbeatRetryInterval, server.sendHeartbeats)
 	}
 
-	for {
-		select {
-		case <-server.stopChan:
-			server.updateTicker.Stop()
-			server.saveTicker.Stop()
-			if server.heartbeatTicker != nil {
-				server.heartbeatTicker.Stop()
-			}
+	for i, listener := range server.listeners {
+		if !server.startTask() {
+			return
+		}
 
-			server.playersLock.RLock()
-			players := make([]*Player, len(server.players))
-			copy(players, server.players)
-			server.playersLock.RUnlock()
+		go server.accept(listener, &configs[i])
+	}
+}
 
-			for _, player := range players {
-				player.Kick("Server shutting down!")
-			}
+// startTicker calls fn at the specified interval until the server is shut
+// down.
+func (server *Server) startTicker(interval time.Duration, fn func()) {
+	if !server.startTask() {
+		return
+	}
 
-			server.levelsLock.Lock()
-			for _, level := range server.levels {
-				server.SaveLevel(level)
+	go func() {
+		defer server.tasks.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-server.stopChan:
+				return
 			}
-			server.levels = nil
-			server.levelsLock.Unlock()
+		}
+	}()
+}
 
-			server.pluginsLock.Lock()
-			for _, plugin := range server.plugins {
-				plugin.Disable(server)
-			}
-			server.plugins = nil
-			server.pluginsLock.Unlock()
+// acceptMinDelay and acceptMaxDelay bound the delay after a failed Accept.
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
 
-			wg.Done()
-			return
+func (server *Server) accept(listener net.Listener, config *ListenerConfig) {
+	defer server.tasks.Done()
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if atomic.LoadUint32(&server.stopping) != 0 {
+				return
+			}
 
-		default:
-			conn, err := server.listener.Accept()
-			if err != nil {
-				continue
+			// Back off like net/http, so that a persistent error such as
+			// running out of file descriptors does not spin.
+			if delay == 0 {
+				delay = acceptMinDelay
+			} else if delay *= 2; delay > acceptMaxDelay {
+				delay = acceptMaxDelay
 			}
 
-			player := NewPlayer(conn, server)
-			go player.handle()
+			log.Printf("accept: %s; retrying in %s\n", err, delay)
+			time.Sleep(delay)
+			continue
 		}
+
+		delay = 0
+		go server.handleConn(conn, config)
 	}
 }
 
-func (server *Server) sendHeartbeat() {
-	form := url.Values{}
-	form.Add("name", server.Config.Name)
-	form.Add("port", strconv.Itoa(server.Config.Port))
-	form.Add("max", strconv.Itoa(server.Config.MaxPlayers))
-	form.Add("users", strconv.Itoa(int(server.playerCount)))
-	form.Add("salt", string(server.salt[:]))
-	form.Add("version", "7")
-	form.Add("software", ServerSoftware)
-	if server.Config.Public {
-		form.Add("public", "True")
-	} else {
-		form.Add("public", "False")
-	}
+// handleConn detects the transport used by conn and runs the game protocol
+// over it.
+func (server *Server) handleConn(conn net.Conn, listener *ListenerConfig) {
+	bufConn := newBufferedConn(conn)
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	config := server.Config()
+	if listener.proxyProtocol(config) && isTrustedProxy(conn.RemoteAddr(), config.trustedProxies) {
+		addr, err := readProxyHeader(bufConn.reader)
+		if err != nil {
+			log.Printf("handleConn: %s: %s\n", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
 
-	response, err := http.PostForm(server.Config.Heartbeat, form)
-	if err != nil {
-		log.Printf("sendHeartbeat: %s\n", err.Error())
-		return
+		bufConn.remoteAddr = addr
 	}
 
-	if response.StatusCode != 200 {
-		log.Printf("sendHeartbeat: %s\n", response.Status)
-		return
-	}
+	host, _, _ := net.SplitHostPort(bufConn.RemoteAddr().String())
+	ok, reason, until := server.throttle.acquire(host, config)
+	if !ok {
+		if len(reason) > 0 {
+			log.Printf("Blocked %s until %s: %s\n", host, until.Format(time.Stamp), reason)
+			event := EventAddrBlock{host, until, reason}
+			server.FireEvent(EventTypeAddrBlock, &event)
+		}
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Printf("sendHeartbeat: %s\n", err.Error())
+		conn.Close()
 		return
 	}
+	defer server.throttle.release(host)
 
-	data := struct {
-		Status   string     `json:"status"`
-		Response string     `json:"response"`
-		Errors   [][]string `json:"errors"`
-	}{}
-
-	err = json.Unmarshal(body, &data)
+	header, err := bufConn.reader.Peek(1)
 	if err != nil {
+		conn.Close()
 		return
 	}
 
-	if len(data.Errors) > 0 && len(data.Errors[0]) > 0 {
-		log.Printf("sendHeartbeat: %s\n", data.Errors[0][0])
-		return
+	var gameConn net.Conn = bufConn
+	if isWebSocket(header) {
+		if gameConn, err = upgradeWebSocket(bufConn); err != nil {
+			log.Printf("handleConn: %s\n", err)
+			conn.Close()
+			return
+		}
 	}
 
-	server.URL = string(body)
+	conn.SetReadDeadline(time.Time{})
+	player := NewPlayer(gameConn, server)
+	player.listener = listener
+	player.handle()
 }
@@ -0,0 +1,112 @@
+package mcc
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/client"
+)
+
+const testTimeout = 5 * time.Second
+
+func newTestServer(t *testing.T) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "mcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		Name:       "Test Server",
+		MOTD:       "Test MOTD",
+		MaxPlayers: 8,
+		MainLevel:  "main",
+		Listeners:  []ListenerConfig{{Addr: "127.0.0.1:0"}},
+		SaltFile:   filepath.Join(dir, "salt"),
+	}
+
+	server := NewServer(config, NewCwStorage(filepath.Join(dir, "levels")))
+	if server == nil {
+		os.RemoveAll(dir)
+		t.Fatal("NewServer failed")
+	}
+
+	var wg sync.WaitGroup
+	if err := server.Start(&wg); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return server, func() {
+		server.Stop()
+		wg.Wait()
+		os.RemoveAll(dir)
+	}
+}
+
+// connect logs in to server over an in-process pipe.
+func connect(t *testing.T, server *Server, config *client.Config) *client.Client {
+	serverConn, clientConn := net.Pipe()
+	go NewPlayer(serverConn, server).handle()
+
+	config.Timeout = testTimeout
+	c, err := client.NewClient(clientConn, config)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+
+	return c
+}
+
+// waitFor polls cond until it returns true or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingListener is a net.Listener whose Accept always fails.
+type failingListener struct {
+	net.Listener
+	accepts int32
+}
+
+func (listener *failingListener) Accept() (net.Conn, error) {
+	atomic.AddInt32(&listener.accepts, 1)
+	return nil, errors.New("too many open files")
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	server := &Server{}
+	listener := &failingListener{}
+	done := make(chan struct{})
+	server.tasks.Add(1)
+	go func() {
+		server.accept(listener, &ListenerConfig{})
+		close(done)
+	}()
+
+	// The delays are 5, 10, 20 and 40ms, so at most 5 calls fit in 100ms.
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreUint32(&server.stopping, 1)
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("accept did not return after the server stopped")
+	}
+
+	if n := atomic.LoadInt32(&listener.accepts); n > 6 {
+		t.Errorf("Accept was called %d times in 100ms", n)
+	}
+}
@@ -0,0 +1,208 @@
+package mcc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerStopped is returned by Shutdown if the server is already shutting
+// down.
+var ErrServerStopped = errors.New("mcc: server stopped")
+
+// ShutdownError reports the steps of a shutdown that failed.
+type ShutdownError struct {
+	Errors []error
+}
+
+func (err *ShutdownError) Error() string {
+	messages := make([]string, len(err.Errors))
+	for i, err := range err.Errors {
+		messages[i] = err.Error()
+	}
+
+	return "shutdown: " + strings.Join(messages, "; ")
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections,
+// counts down for ShutdownCountdown, waits for the running commands,
+// disconnects all clients, waits for the ticks, saves all levels in parallel
+// and disables the plugins in reverse load order.
+//
+// If ctx expires, the remaining waits are abandoned and the shutdown
+// continues with the next step. The levels that are not saved by then are
+// reported, and their files are left as they were before the save. Any
+// failures are reported as a *ShutdownError. Shutdown must not be called
+// from a command handler, since it waits for the handler to return.
+func (server *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&server.stopping, 0, 1) {
+		return ErrServerStopped
+	}
+
+	event := EventServerStop{server}
+	server.FireEvent(EventTypeServerStop, &event)
+
+	var errs []error
+	server.closeListeners()
+
+	server.countdown(ctx)
+
+	server.stateLock.Lock()
+	server.commandsClosed = true
+	server.stateLock.Unlock()
+
+	if err := waitContext(ctx, &server.runningCommands); err != nil {
+		errs = append(errs, fmt.Errorf("waiting for commands: %s", err))
+	}
+
+	server.playersLock.RLock()
+	players := make([]*Player, len(server.players))
+	copy(players, server.players)
+	server.playersLock.RUnlock()
+
+	for _, player := range players {
+		player.kick("Server shutting down!", true)
+	}
+
+	close(server.stopChan)
+	if err := waitContext(ctx, &server.tasks); err != nil {
+		errs = append(errs, fmt.Errorf("waiting for ticks: %s", err))
+	}
+
+	errs = append(errs, server.saveLevels(ctx)...)
+	errs = append(errs, server.disablePlugins()...)
+
+	if server.metricsServer != nil {
+		server.metricsServer.Close()
+	}
+
+	if server.stopGroup != nil {
+		server.stopGroup.Done()
+	}
+
+	if len(errs) > 0 {
+		return &ShutdownError{errs}
+	}
+
+	return nil
+}
+
+// countdown broadcasts the remaining time until the shutdown every second.
+func (server *Server) countdown(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for left := ShutdownCountdown; left > 0; left -= time.Second {
+		if atomic.LoadInt32(&server.playerCount) == 0 {
+			return
+		}
+
+		seconds := int(left / time.Second)
+		if seconds == 1 {
+			server.BroadcastMessage(ColorRed + "Server shutting down in 1 second!")
+		} else {
+			server.BroadcastMessage(fmt.Sprintf(ColorRed+"Server shutting down in %d seconds!", seconds))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// saveLevels saves and removes all levels in parallel. If ctx expires before
+// all saves finish, the levels that are still being saved are reported. Since
+// the storages replace the level files only when a save is complete, an
+// abandoned save that is cut short by the exit of the process leaves the
+// previous file intact.
+func (server *Server) saveLevels(ctx context.Context) (errs []error) {
+	server.levelsLock.Lock()
+	levels := server.levels
+	server.levels = nil
+	server.levelsLock.Unlock()
+
+	type result struct {
+		level *Level
+		err   error
+	}
+
+	results := make(chan result, len(levels))
+	for _, level := range levels {
+		go func(level *Level) {
+			results <- result{level, server.saveLevel(level)}
+		}(level)
+	}
+
+	saved := make(map[*Level]bool)
+	for len(saved) < len(levels) {
+		select {
+		case r := <-results:
+			saved[r.level] = true
+			if r.err != nil {
+				errs = append(errs, fmt.Errorf("saving level %s: %s", r.level.Name, r.err))
+			}
+
+		case <-ctx.Done():
+			for _, level := range levels {
+				if !saved[level] {
+					errs = append(errs, fmt.Errorf("saving level %s: %s", level.Name, ctx.Err()))
+				}
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+// startCommand registers a running command. It reports false if the server
+// no longer accepts commands.
+func (server *Server) startCommand() bool {
+	server.stateLock.Lock()
+	defer server.stateLock.Unlock()
+
+	if server.commandsClosed {
+		return false
+	}
+
+	server.runningCommands.Add(1)
+	return true
+}
+
+// startTask registers a goroutine that runs until the server is shut down.
+// It reports false if the server is shutting down, since Shutdown may already
+// be waiting for the running goroutines.
+func (server *Server) startTask() bool {
+	server.stateLock.Lock()
+	defer server.stateLock.Unlock()
+
+	if atomic.LoadUint32(&server.stopping) != 0 {
+		return false
+	}
+
+	server.tasks.Add(1)
+	return true
+}
+
+// waitContext waits for wg, or until ctx expires.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,65 @@
+package mcc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowStorage is a LevelStorage whose saves block until release is closed.
+type slowStorage struct {
+	release chan struct{}
+}
+
+func (storage *slowStorage) Load(name string) (*Level, error) {
+	return nil, errors.New("not found")
+}
+
+func (storage *slowStorage) Save(level *Level) error {
+	<-storage.release
+	return nil
+}
+
+func TestShutdownSaveDeadline(t *testing.T) {
+	storage := &slowStorage{make(chan struct{})}
+	defer close(storage.release)
+
+	config := &Config{
+		Name:       "Test Server",
+		MaxPlayers: 8,
+		MainLevel:  "main",
+		Listeners:  []ListenerConfig{{Addr: "127.0.0.1:0"}},
+	}
+
+	server := NewServer(config, storage)
+	if server == nil {
+		t.Fatal("NewServer failed")
+	}
+
+	var wg sync.WaitGroup
+	if err := server.Start(&wg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := server.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("Shutdown took %s", elapsed)
+	}
+
+	shutdownErr, ok := err.(*ShutdownError)
+	if !ok || !strings.Contains(shutdownErr.Error(), "saving level main") {
+		t.Fatalf("got error %v, want the unsaved level", err)
+	}
+
+	wg.Wait()
+	if err := server.Shutdown(context.Background()); err != ErrServerStopped {
+		t.Errorf("got error %v from the second Shutdown", err)
+	}
+}
@@ -0,0 +1,164 @@
+package mcc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const snapshotChunkSize = 64 * 1024
+
+// blockProfile describes which blocks a client supports.
+type blockProfile struct {
+	legacy       bool
+	customBlocks bool
+	blockDefs    bool
+	extBlocks    bool
+}
+
+// convertBlock converts block to one that is supported by profile.
+func (level *Level) convertBlock(block BlockID, profile blockProfile) BlockID {
+	if block > 0xff && !profile.extBlocks {
+		if def := level.blockDef(block); def != nil {
+			block = def.Fallback
+		}
+
+		if block > 0xff {
+			return BlockAir
+		}
+	}
+
+	if !profile.blockDefs {
+		if def := level.blockDef(block); def != nil {
+			block = def.Fallback
+		}
+
+		if block > BlockMaxCPE {
+			return BlockAir
+		}
+	}
+
+	if !profile.customBlocks {
+		block = FallbackBlock(block)
+	}
+
+	if profile.legacy {
+		block = FallbackBlockLegacy(block)
+		if block > BlockMaxLegacy {
+			return BlockAir
+		}
+	}
+
+	return block
+}
+
+// snapshotKey identifies an encoding of the level data.
+type snapshotKey struct {
+	profile blockProfile
+	fastMap bool
+}
+
+// levelSnapshot holds the compressed level data that is sent to clients.
+// data is valid after done is closed.
+type levelSnapshot struct {
+	key     snapshotKey
+	version uint32
+	done    chan struct{}
+	data    []byte
+}
+
+// snapshotCache stores a levelSnapshot for each encoding, so that players
+// that download a level at the same time share the compressed data.
+type snapshotCache struct {
+	version   uint32
+	lock      sync.Mutex
+	snapshots map[snapshotKey]*levelSnapshot
+}
+
+// Invalidate discards the cached level data that is sent to joining players.
+// It must be called after modifying Blocks or BlockDefs directly.
+func (level *Level) Invalidate() {
+	atomic.AddUint32(&level.snapshots.version, 1)
+}
+
+// snapshot returns the compressed level data for key. If no up-to-date
+// snapshot exists, a new one is compressed in a separate goroutine.
+func (level *Level) snapshot(key snapshotKey) *levelSnapshot {
+	cache := &level.snapshots
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	version := atomic.LoadUint32(&cache.version)
+	if snapshot := cache.snapshots[key]; snapshot != nil && snapshot.version == version {
+		return snapshot
+	}
+
+	if cache.snapshots == nil {
+		cache.snapshots = make(map[snapshotKey]*levelSnapshot)
+	}
+
+	snapshot := &levelSnapshot{key: key, version: version, done: make(chan struct{})}
+	cache.snapshots[key] = snapshot
+	go level.compress(snapshot)
+	return snapshot
+}
+
+// compress copies the blocks of the level and compresses them into snapshot.
+// The copy is made under blocksLock, so it is not torn by concurrent block
+// changes and it contains at least every change up to snapshot.version.
+func (level *Level) compress(snapshot *levelSnapshot) {
+	var conv [BlockCount]BlockID
+	for i := range conv {
+		conv[i] = level.convertBlock(BlockID(i), snapshot.key.profile)
+	}
+
+	blocks, blocks2 := level.copyBlocks()
+	snapshot.compress(blocks, blocks2, &conv)
+}
+
+func (snapshot *levelSnapshot) compress(blocks, blocks2 []byte, conv *[BlockCount]BlockID) {
+	defer close(snapshot.done)
+
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	if snapshot.key.fastMap {
+		writer, _ = flate.NewWriter(&buf, -1)
+	} else {
+		gzipWriter := gzip.NewWriter(&buf)
+		binary.Write(gzipWriter, binary.BigEndian, int32(len(blocks)))
+		writer = gzipWriter
+	}
+
+	chunk := make([]byte, snapshotChunkSize)
+	writeBlocks := func(shift uint) {
+		for start := 0; start < len(blocks); start += len(chunk) {
+			end := min(start+len(chunk), len(blocks))
+			for i := start; i < end; i++ {
+				block := BlockID(blocks[i])
+				if blocks2 != nil {
+					block |= BlockID(blocks2[i]) << 8
+				}
+
+				if block > BlockMax {
+					block = BlockAir
+				}
+
+				chunk[i-start] = byte(conv[block] >> shift)
+			}
+
+			writer.Write(chunk[:end-start])
+		}
+	}
+
+	writeBlocks(0)
+	if snapshot.key.profile.extBlocks {
+		writeBlocks(8)
+	}
+
+	writer.Close()
+	snapshot.data = buf.Bytes()
+}
@@ -0,0 +1,80 @@
+package mcc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreasgoulas/go-mcc/mcc/client"
+)
+
+func TestBlockChangesDuringJoin(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	// Change a block before the level is compressed and again after it has
+	// been compressed but before it has been sent. The snapshot cache is
+	// locked until the compressed level is stored in it, so that the player
+	// waits for the level while both changes are made.
+	level := server.MainLevel
+	cache := &level.snapshots
+	cache.lock.Lock()
+	var unlock sync.Once
+	defer unlock.Do(cache.lock.Unlock)
+
+	toggle := func() {
+		if level.GetBlock(5, 1, 5) == BlockGold {
+			level.SetBlock(5, 1, 5, BlockStone)
+		} else {
+			level.SetBlock(5, 1, 5, BlockGold)
+		}
+	}
+
+	result := make(chan BlockID, 1)
+	go func() {
+		deadline := time.Now().Add(testTimeout)
+		var player *Player
+		for player == nil || !player.isLoading() {
+			if time.Now().After(deadline) {
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+			player = server.FindPlayer("Joiner")
+		}
+
+		toggle()
+		key := snapshotKey{player.blockProfile(), player.cpe[CpeFastMap]}
+		snapshot := &levelSnapshot{key: key, done: make(chan struct{})}
+		level.compress(snapshot)
+		toggle()
+
+		snapshot.version = atomic.LoadUint32(&cache.version)
+		if cache.snapshots == nil {
+			cache.snapshots = make(map[snapshotKey]*levelSnapshot)
+		}
+		cache.snapshots[key] = snapshot
+		unlock.Do(cache.lock.Unlock)
+
+		for player.isLoading() {
+			time.Sleep(time.Millisecond)
+		}
+
+		result <- level.GetBlock(5, 1, 5)
+	}()
+
+	c := connect(t, server, &client.Config{Name: "Joiner"})
+	defer c.Close()
+
+	var block BlockID
+	select {
+	case block = <-result:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the level to be sent")
+	}
+
+	waitFor(t, "the block change", func() bool {
+		return c.GetBlock(5, 1, 5) == uint16(block)
+	})
+}
@@ -0,0 +1,106 @@
+package mcc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newExtendedLevel returns a level with extended blocks. Block 300 is a
+// custom block that falls back to glass.
+func newExtendedLevel() *Level {
+	level := NewLevel("extended", 16, 16, 16)
+	level.BlockDefs = make([]*BlockDefinition, BlockCount)
+	level.BlockDefs[300] = &BlockDefinition{Name: "Custom", Fallback: BlockGlass}
+	level.SetBlockFast(0, 0, 0, BlockStone)
+	level.SetBlockFast(1, 2, 3, 300)
+	level.SetBlockFast(4, 5, 6, BlockMax)
+	level.SetBlockFast(7, 8, 9, BlockStoneBrick)
+	return level
+}
+
+func TestStorageExtendedBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage func(dir string) LevelStorage
+		want    map[BlockID]BlockID
+	}{
+		{"ClassicWorld", func(dir string) LevelStorage { return NewCwStorage(dir) },
+			map[BlockID]BlockID{300: 300, BlockMax: BlockMax}},
+		{"Lvl", func(dir string) LevelStorage { return NewLvlStorage(dir) },
+			map[BlockID]BlockID{300: BlockGlass, BlockMax: BlockAir}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "storage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			storage := test.storage(dir + "/")
+			level := newExtendedLevel()
+			if err := storage.Save(level); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := storage.Load(level.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range level.Blocks {
+				want := level.block(i)
+				if fallback, ok := test.want[want]; ok {
+					want = fallback
+				}
+
+				if got := loaded.block(i); got != want {
+					x, y, z := level.Position(i)
+					t.Fatalf("block at %d,%d,%d: got %d, want %d", x, y, z, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBlocks2(t *testing.T) {
+	level := NewLevel("plain", 16, 16, 16)
+	level.SetBlock(1, 2, 3, BlockStoneBrick)
+	level.FillLayers(0, 0, BlockGrass)
+	if level.Blocks2 != nil {
+		t.Fatal("high bytes were allocated without extended blocks")
+	}
+
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := NewCwStorage(dir + "/")
+	if err := storage.Save(level); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load(level.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Blocks2 != nil {
+		t.Error("high bytes were allocated when loading a level without extended blocks")
+	}
+
+	level.SetBlockFast(1, 2, 3, 256)
+	if level.Blocks2 == nil || level.GetBlock(1, 2, 3) != 256 || level.GetBlock(0, 0, 0) != BlockGrass {
+		t.Fatal("extended block was not stored")
+	}
+
+	clone := level.Clone("clone")
+	level.SetBlockFast(1, 2, 3, 257)
+	if clone.GetBlock(1, 2, 3) != 256 {
+		t.Error("clone shares the high bytes")
+	}
+}
@@ -0,0 +1,118 @@
+package mcc
+
+import (
+	"sync"
+	"time"
+)
+
+const throttleWindow = time.Minute
+
+type throttleEntry struct {
+	active       int
+	recent       []time.Time
+	blockedUntil time.Time
+}
+
+// throttle limits the number of connections from each address.
+type throttle struct {
+	lock        sync.Mutex
+	entries     map[string]*throttleEntry
+	lastCleanup time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		entries:     make(map[string]*throttleEntry),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (throttle *throttle) entry(addr string) *throttleEntry {
+	entry := throttle.entries[addr]
+	if entry == nil {
+		entry = &throttleEntry{}
+		throttle.entries[addr] = entry
+	}
+
+	return entry
+}
+
+func (entry *throttleEntry) prune(now time.Time) {
+	i := 0
+	for i < len(entry.recent) && now.Sub(entry.recent[i]) >= throttleWindow {
+		i++
+	}
+
+	entry.recent = entry.recent[i:]
+}
+
+func (entry *throttleEntry) idle(now time.Time) bool {
+	return entry.active == 0 && len(entry.recent) == 0 &&
+		!now.Before(entry.blockedUntil)
+}
+
+func (throttle *throttle) cleanup(now time.Time) {
+	for addr, entry := range throttle.entries {
+		entry.prune(now)
+		if entry.idle(now) {
+			delete(throttle.entries, addr)
+		}
+	}
+
+	throttle.lastCleanup = now
+}
+
+// acquire registers a new connection from addr. If the connection is refused,
+// ok is false. If the connection caused addr to be blocked, reason and until
+// are set. Only the connections per minute block addr. Addresses are not
+// blocked if the block time is zero.
+func (throttle *throttle) acquire(addr string, config *Config) (ok bool, reason string, until time.Time) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(throttle.lastCleanup) >= throttleWindow {
+		throttle.cleanup(now)
+	}
+
+	entry := throttle.entry(addr)
+	if now.Before(entry.blockedUntil) {
+		return
+	}
+
+	entry.prune(now)
+	if config.MaxConnectionsPerMinute > 0 && len(entry.recent) >= config.MaxConnectionsPerMinute {
+		if config.ThrottleBlockTime <= 0 {
+			return
+		}
+
+		entry.blockedUntil = now.Add(time.Duration(config.ThrottleBlockTime) * time.Second)
+		return false, "Too many connections per minute", entry.blockedUntil
+	}
+
+	if config.MaxConnectionsPerIP > 0 && entry.active >= config.MaxConnectionsPerIP {
+		return
+	}
+
+	entry.active++
+	entry.recent = append(entry.recent, now)
+	return true, "", time.Time{}
+}
+
+// release unregisters a connection from addr.
+func (throttle *throttle) release(addr string) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	if entry := throttle.entries[addr]; entry != nil && entry.active > 0 {
+		entry.active--
+	}
+}
+
+// block refuses connections from addr until the specified time.
+func (throttle *throttle) block(addr string, until time.Time) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	throttle.entry(addr).blockedUntil = until
+}
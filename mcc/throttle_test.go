@@ -0,0 +1,49 @@
+package mcc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleConcurrent(t *testing.T) {
+	throttle := newThrottle()
+	config := &Config{MaxConnectionsPerIP: 1, ThrottleBlockTime: 60}
+	if ok, _, _ := throttle.acquire("192.0.2.1", config); !ok {
+		t.Fatal("first connection refused")
+	}
+
+	ok, reason, _ := throttle.acquire("192.0.2.1", config)
+	if ok || len(reason) > 0 {
+		t.Fatalf("got %v, %q for a second connection, want a refusal without a block", ok, reason)
+	}
+
+	throttle.release("192.0.2.1")
+	if ok, _, _ := throttle.acquire("192.0.2.1", config); !ok {
+		t.Fatal("connection refused after a release")
+	}
+}
+
+func TestThrottlePerMinute(t *testing.T) {
+	throttle := newThrottle()
+	config := &Config{MaxConnectionsPerMinute: 2, ThrottleBlockTime: 60}
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := throttle.acquire("192.0.2.1", config); !ok {
+			t.Fatalf("connection %d refused", i)
+		}
+
+		throttle.release("192.0.2.1")
+	}
+
+	ok, reason, until := throttle.acquire("192.0.2.1", config)
+	if ok || len(reason) == 0 || time.Until(until) < 59*time.Second {
+		t.Fatalf("got %v, %q, %s, want a block", ok, reason, until)
+	}
+
+	if ok, _, _ := throttle.acquire("192.0.2.1", &Config{}); ok {
+		t.Fatal("blocked address was accepted")
+	}
+
+	if ok, _, _ := throttle.acquire("192.0.2.2", config); !ok {
+		t.Fatal("other address was refused")
+	}
+}
@@ -0,0 +1,253 @@
+package mcc
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tickHistory        = 1200
+	tickWindow         = 100
+	lagWarningInterval = 5 * time.Second
+)
+
+// TickStats contains statistics about the recent server ticks.
+type TickStats struct {
+	// TPS1s, TPS10s and TPS1m are the average number of ticks per second
+	// over the last second, 10 seconds and minute.
+	TPS1s, TPS10s, TPS1m float64
+
+	// MeanTick and MaxTick are the mean and maximum duration of the last
+	// ticks.
+	MeanTick, MaxTick time.Duration
+
+	// Overruns is the number of ticks in the last minute that took longer
+	// than UpdateInterval, and Skipped is the number of ticks that were
+	// dropped because the previous tick was late.
+	Overruns, Skipped int
+
+	// Phases contains the duration of each phase of the last ticks, sorted
+	// by the total time spent in it.
+	Phases []TickPhase
+}
+
+// TickPhase contains the duration of a phase of the server tick. Phases are
+// named "tasks", "entities", "players" or "<level>/<simulator>".
+type TickPhase struct {
+	Name      string
+	Mean, Max time.Duration
+}
+
+type tickSample struct {
+	start    time.Time
+	duration time.Duration
+	skipped  int
+}
+
+type phaseStats struct {
+	total, max time.Duration
+}
+
+type phaseSample struct {
+	name     string
+	duration time.Duration
+}
+
+// tickTimer measures the phases of a single tick.
+type tickTimer struct {
+	start, mark time.Time
+	phases      []phaseSample
+}
+
+// phase records the time since the previous phase as the duration of the
+// named phase.
+func (timer *tickTimer) phase(name string) {
+	now := time.Now()
+	timer.phases = append(timer.phases, phaseSample{name, now.Sub(timer.mark)})
+	timer.mark = now
+}
+
+// tickMonitor keeps the statistics of the recent ticks and reports the ticks
+// that take longer than UpdateInterval.
+type tickMonitor struct {
+	timer tickTimer
+
+	lock        sync.Mutex
+	samples     [tickHistory]tickSample
+	next, count int
+	phases      map[string]*phaseStats
+	lastPhases  map[string]*phaseStats
+	phaseTicks  int
+	lastTicks   int
+	lastWarning time.Time
+}
+
+// begin starts timing a tick. It must only be called by the update goroutine.
+func (monitor *tickMonitor) begin() *tickTimer {
+	now := time.Now()
+	monitor.timer = tickTimer{now, now, monitor.timer.phases[:0]}
+	return &monitor.timer
+}
+
+// end records the tick that was started by begin and returns its duration.
+func (monitor *tickMonitor) end() time.Duration {
+	timer := &monitor.timer
+	duration := time.Since(timer.start)
+
+	monitor.lock.Lock()
+	skipped := 0
+	if monitor.count > 0 {
+		prev := monitor.samples[(monitor.next+tickHistory-1)%tickHistory]
+		if gap := timer.start.Sub(prev.start); gap >= 2*UpdateInterval {
+			skipped = int(gap/UpdateInterval) - 1
+		}
+	}
+
+	monitor.samples[monitor.next] = tickSample{timer.start, duration, skipped}
+	monitor.next = (monitor.next + 1) % tickHistory
+	if monitor.count < tickHistory {
+		monitor.count++
+	}
+
+	if monitor.phases == nil {
+		monitor.phases = make(map[string]*phaseStats)
+	}
+
+	var slowest phaseSample
+	for _, sample := range timer.phases {
+		stats := monitor.phases[sample.name]
+		if stats == nil {
+			stats = &phaseStats{}
+			monitor.phases[sample.name] = stats
+		}
+
+		stats.total += sample.duration
+		if sample.duration > stats.max {
+			stats.max = sample.duration
+		}
+
+		if sample.duration > slowest.duration {
+			slowest = sample
+		}
+	}
+
+	monitor.phaseTicks++
+	if monitor.phaseTicks == tickWindow {
+		monitor.lastPhases, monitor.lastTicks = monitor.phases, monitor.phaseTicks
+		monitor.phases, monitor.phaseTicks = nil, 0
+	}
+
+	warn := false
+	if (duration > UpdateInterval || skipped > 0) &&
+		timer.start.Sub(monitor.lastWarning) >= lagWarningInterval {
+		monitor.lastWarning = timer.start
+		warn = true
+	}
+	monitor.lock.Unlock()
+
+	if warn {
+		log.Printf("Tick took %s, %d ticks skipped, slowest phase: %s (%s)\n",
+			duration.Round(time.Microsecond), skipped,
+			slowest.name, slowest.duration.Round(time.Microsecond))
+	}
+
+	return duration
+}
+
+// stats returns the statistics of the recent ticks.
+func (monitor *tickMonitor) stats() TickStats {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+
+	var stats TickStats
+	if monitor.count == 0 {
+		return stats
+	}
+
+	now := time.Now()
+	samples := make([]tickSample, monitor.count)
+	for i := range samples {
+		samples[i] = monitor.samples[(monitor.next-monitor.count+i+tickHistory)%tickHistory]
+	}
+
+	stats.TPS1s = tickRate(samples, now.Add(-time.Second))
+	stats.TPS10s = tickRate(samples, now.Add(-10*time.Second))
+	stats.TPS1m = tickRate(samples, now.Add(-time.Minute))
+
+	recent := samples[max(0, len(samples)-tickWindow):]
+	var total time.Duration
+	for _, sample := range recent {
+		total += sample.duration
+		if sample.duration > stats.MaxTick {
+			stats.MaxTick = sample.duration
+		}
+	}
+
+	stats.MeanTick = total / time.Duration(len(recent))
+
+	for _, sample := range samples {
+		if now.Sub(sample.start) <= time.Minute {
+			if sample.duration > UpdateInterval {
+				stats.Overruns++
+			}
+
+			stats.Skipped += sample.skipped
+		}
+	}
+
+	phases, ticks := monitor.lastPhases, monitor.lastTicks
+	if phases == nil {
+		phases, ticks = monitor.phases, monitor.phaseTicks
+	}
+
+	totals := make(map[string]time.Duration)
+	for name, phase := range phases {
+		stats.Phases = append(stats.Phases, TickPhase{
+			Name: name,
+			Mean: phase.total / time.Duration(ticks),
+			Max:  phase.max,
+		})
+
+		totals[name] = phase.total
+	}
+
+	sort.Slice(stats.Phases, func(i, j int) bool {
+		a, b := stats.Phases[i], stats.Phases[j]
+		if totals[a.Name] != totals[b.Name] {
+			return totals[a.Name] > totals[b.Name]
+		}
+
+		return a.Name < b.Name
+	})
+
+	return stats
+}
+
+// tickRate returns the number of ticks per second in samples since the
+// specified time.
+func tickRate(samples []tickSample, since time.Time) float64 {
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].start.Before(since)
+	})
+
+	window := samples[i:]
+	if len(window) < 2 {
+		return 0
+	}
+
+	span := window[len(window)-1].start.Sub(window[0].start)
+	return float64(len(window)-1) / span.Seconds()
+}
+
+// TickStats returns the statistics of the recent server ticks.
+func (server *Server) TickStats() TickStats {
+	return server.ticks.stats()
+}
+
+// simulatorName returns the type name of simulator.
+func simulatorName(simulator Simulator) string {
+	return reflect.Indirect(reflect.ValueOf(simulator)).Type().Name()
+}
@@ -1,7 +1,12 @@
 package mcc
 
 import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
 	"unicode"
 )
@@ -111,3 +116,51 @@ func RandomUUID() (uuid [16]byte) {
 	uuid[8] = (uuid[8] & 0x3f) | 0x80
 	return
 }
+
+// writeFile writes a file with the specified permissions by calling fn. The
+// data is written to a temporary file that replaces path once it is
+// complete, so that an interrupted write never leaves a truncated file
+// behind.
+func writeFile(path string, perm os.FileMode, fn func(writer io.Writer) error) (err error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if err = file.Chmod(perm); err != nil {
+		return
+	}
+
+	if err = fn(file); err != nil {
+		return
+	}
+
+	if err = file.Sync(); err != nil {
+		return
+	}
+
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// writeFileGzip is like writeFile, but it compresses the data with gzip.
+func writeFileGzip(path string, fn func(writer io.Writer) error) error {
+	return writeFile(path, 0644, func(writer io.Writer) error {
+		gzipWriter := gzip.NewWriter(writer)
+		if err := fn(gzipWriter); err != nil {
+			return err
+		}
+
+		return gzipWriter.Close()
+	})
+}
@@ -0,0 +1,185 @@
+package mcc
+
+import (
+	"github.com/andreasgoulas/go-mcc/mcc/proto"
+)
+
+// Visibility overrides.
+const (
+	VisibilityDefault = 0 // Visible inside the view distance.
+	VisibilityAlways  = 1 // Always visible.
+	VisibilityHidden  = 2 // Never visible.
+)
+
+// distantUpdateTicks is the number of ticks between the movement updates of
+// entities that are far from a player.
+const distantUpdateTicks = 4
+
+// Visibility returns the visibility override of entity for the player.
+func (player *Player) Visibility(entity *Entity) int {
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+	return player.visibility[entity]
+}
+
+// SetVisibility overrides whether entity is visible to the player.
+// It can be used to hide vanished players or to keep the target of a
+// spectator visible.
+func (player *Player) SetVisibility(entity *Entity, visibility int) {
+	player.viewLock.Lock()
+	if visibility == VisibilityDefault {
+		delete(player.visibility, entity)
+	} else {
+		if player.visibility == nil {
+			player.visibility = make(map[*Entity]int)
+		}
+
+		player.visibility[entity] = visibility
+	}
+	player.viewLock.Unlock()
+
+	if level := entity.Level(); level != nil && level == player.Level() {
+		player.updateVisibility(entity)
+	}
+}
+
+// forgetEntity despawns entity, which has been removed from the server, and
+// drops its visibility override. Unlike SetVisibility, it never spawns the
+// entity.
+func (player *Player) forgetEntity(entity *Entity) {
+	if entity == player.Entity {
+		return
+	}
+
+	player.sendDespawn(entity)
+
+	player.viewLock.Lock()
+	delete(player.visibility, entity)
+	player.viewLock.Unlock()
+}
+
+// viewDistance returns the distance up to which the player can see other
+// entities, or zero if the distance is unlimited.
+func (player *Player) viewDistance() float64 {
+	if player.ViewDistance > 0 {
+		return player.ViewDistance
+	}
+
+	if level := player.Level(); level != nil && level.ViewDistance > 0 {
+		return level.ViewDistance
+	}
+
+	return player.server.Config().ViewDistance
+}
+
+// distance returns the squared distance between the player and entity, and
+// the squared view distance of the player.
+func (player *Player) distance(entity *Entity) (float64, float64) {
+	a, b := entity.Location(), player.Location()
+	dx := a.X - b.X
+	dy := a.Y - b.Y
+	dz := a.Z - b.Z
+	view := player.viewDistance()
+	return dx*dx + dy*dy + dz*dz, view * view
+}
+
+// canSee reports whether entity should be spawned for the player.
+func (player *Player) canSee(entity *Entity) bool {
+	if entity == player.Entity {
+		return true
+	}
+
+	switch player.Visibility(entity) {
+	case VisibilityAlways:
+		return true
+	case VisibilityHidden:
+		return false
+	}
+
+	dist, view := player.distance(entity)
+	return view == 0 || dist <= view
+}
+
+// isDistant reports whether the movement updates of entity should be
+// throttled for the player, which is the case if it is farther than half the
+// view distance.
+func (player *Player) isDistant(entity *Entity) bool {
+	dist, view := player.distance(entity)
+	return view > 0 && dist > view/4 && player.Visibility(entity) != VisibilityAlways
+}
+
+// updateVisibility spawns or despawns entity if it entered or left the view
+// of the player.
+func (player *Player) updateVisibility(entity *Entity) {
+	if entity == player.Entity {
+		return
+	}
+
+	_, spawned := player.entityID(entity)
+	visible := player.canSee(entity)
+	if visible && !spawned {
+		player.sendSpawn(entity)
+	} else if !visible && spawned {
+		player.sendDespawn(entity)
+	}
+}
+
+// updateView updates the entities that are visible to the player, and sends
+// the throttled movement updates.
+func (player *Player) updateView() {
+	level := player.Level()
+	if player.loadState() != stateGame || level == nil || player.isLoading() {
+		return
+	}
+
+	level.ForEachEntity(func(entity *Entity) {
+		player.updateVisibility(entity)
+	})
+
+	player.viewTicks++
+	if player.viewTicks < distantUpdateTicks {
+		return
+	}
+
+	player.viewTicks = 0
+	player.viewLock.Lock()
+	for entity := range player.distant {
+		if id, ok := player.entityIDs.get(entity); ok {
+			player.sendPacket(teleportPacket(entity, id))
+		}
+	}
+
+	player.distant = nil
+	player.viewLock.Unlock()
+}
+
+// sendEntityUpdate sends a movement update of entity. The updates of distant
+// entities are deferred to updateView.
+func (player *Player) sendEntityUpdate(entity *Entity, fn func(id byte) proto.Packet) {
+	if player.loadState() != stateGame {
+		return
+	}
+
+	distant := player.isDistant(entity)
+
+	player.viewLock.Lock()
+	defer player.viewLock.Unlock()
+
+	id, ok := player.entityIDs.get(entity)
+	if !ok {
+		return
+	}
+
+	if distant {
+		if player.distant == nil {
+			player.distant = make(map[*Entity]bool)
+		}
+
+		player.distant[entity] = true
+	} else if player.distant[entity] {
+		delete(player.distant, entity)
+		player.sendPacket(teleportPacket(entity, id))
+	} else {
+		player.sendPacket(fn(id))
+	}
+}
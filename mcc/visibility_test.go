@@ -0,0 +1,42 @@
+package mcc
+
+import (
+	"testing"
+
+	"github.com/andreasgoulas/go-mcc/mcc/client"
+)
+
+func TestRemoveHiddenEntity(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	npc := NewEntity("Npc", server)
+	server.AddEntity(npc)
+	npc.TeleportLevel(server.MainLevel)
+
+	alice := connect(t, server, &client.Config{Name: "Alice"})
+	defer alice.Close()
+
+	spawned := func() bool {
+		for _, entity := range alice.Entities() {
+			if entity.Name == "Npc" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	waitFor(t, "the NPC to spawn", spawned)
+	server.FindPlayer("Alice").SetVisibility(npc, VisibilityHidden)
+	waitFor(t, "the NPC to be hidden", func() bool { return !spawned() })
+
+	server.RemoveEntity(npc)
+	if _, err := alice.Ping(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	if spawned() {
+		t.Fatal("removed NPC was spawned")
+	}
+}
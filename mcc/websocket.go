@@ -0,0 +1,252 @@
+package mcc
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsSubprotocol = "ClassiCube"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsCloseTimeout = time.Second
+)
+
+// isWebSocket reports whether header is the beginning of an HTTP request.
+func isWebSocket(header []byte) bool {
+	return len(header) > 0 && header[0] == 'G'
+}
+
+func headerContains(header http.Header, key, value string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(key)] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// upgradeWebSocket performs the server side of the WebSocket opening
+// handshake and returns a net.Conn that reads and writes binary messages.
+func upgradeWebSocket(conn *bufferedConn) (net.Conn, error) {
+	request, err := http.ReadRequest(conn.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Method != "GET" ||
+		!headerContains(request.Header, "Connection", "upgrade") ||
+		!headerContains(request.Header, "Upgrade", "websocket") {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		io.WriteString(conn, "HTTP/1.1 426 Upgrade Required\r\n"+
+			"Sec-WebSocket-Version: 13\r\n\r\n")
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if len(key) == 0 {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return nil, errors.New("websocket: missing key")
+	}
+
+	digest := sha1.Sum([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n"
+	if headerContains(request.Header, "Sec-WebSocket-Protocol", wsSubprotocol) {
+		response += "Sec-WebSocket-Protocol: " + wsSubprotocol + "\r\n"
+	}
+
+	if _, err := io.WriteString(conn, response+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	return &wsConn{Conn: conn, reader: conn.reader}, nil
+}
+
+// wsConn is a net.Conn that transports a byte stream over WebSocket binary
+// frames.
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskIndex int
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+
+	// closeFrame makes sure that a single close frame is sent, either in
+	// reply to the client or by Close.
+	closeFrame sync.Once
+}
+
+func (conn *wsConn) readHeader() (opcode byte, length uint64, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(conn.reader, header[:]); err != nil {
+		return
+	}
+
+	opcode = header[0] & 0x0f
+	conn.masked = header[1]&0x80 != 0
+	length = uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(conn.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(conn.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !conn.masked {
+		err = errors.New("websocket: unmasked client frame")
+		return
+	}
+
+	_, err = io.ReadFull(conn.reader, conn.mask[:])
+	conn.maskIndex = 0
+	return
+}
+
+func (conn *wsConn) unmask(p []byte) {
+	for i := range p {
+		p[i] ^= conn.mask[conn.maskIndex]
+		conn.maskIndex = (conn.maskIndex + 1) % 4
+	}
+}
+
+// Read implements net.Conn.
+func (conn *wsConn) Read(p []byte) (int, error) {
+	for conn.remaining == 0 {
+		opcode, length, err := conn.readHeader()
+		if err != nil {
+			return 0, err
+		}
+
+		switch opcode {
+		case wsOpContinuation, wsOpText, wsOpBinary:
+			conn.remaining = length
+
+		case wsOpClose, wsOpPing, wsOpPong:
+			if length > 125 {
+				return 0, errors.New("websocket: control frame too long")
+			}
+
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(conn.reader, payload); err != nil {
+				return 0, err
+			}
+			conn.unmask(payload)
+
+			switch opcode {
+			case wsOpClose:
+				conn.writeClose(payload)
+				return 0, io.EOF
+			case wsOpPing:
+				conn.writeFrame(wsOpPong, payload)
+			}
+
+		default:
+			return 0, fmt.Errorf("websocket: invalid opcode %d", opcode)
+		}
+	}
+
+	if uint64(len(p)) > conn.remaining {
+		p = p[:conn.remaining]
+	}
+
+	n, err := conn.reader.Read(p)
+	conn.unmask(p[:n])
+	conn.remaining -= uint64(n)
+	return n, err
+}
+
+func (conn *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	_, err := conn.Conn.Write(frame)
+	return err
+}
+
+// writeClose sends a close frame with payload, unless one has been sent
+// already.
+func (conn *wsConn) writeClose(payload []byte) {
+	conn.closeFrame.Do(func() {
+		conn.writeFrame(wsOpClose, payload)
+	})
+}
+
+// Write implements net.Conn.
+func (conn *wsConn) Write(p []byte) (int, error) {
+	if err := conn.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close implements net.Conn.
+// The close frame is sent in the background, so that Close does not block
+// behind a pending write.
+func (conn *wsConn) Close() (err error) {
+	err = errors.New("websocket: connection already closed")
+	conn.closeOnce.Do(func() {
+		err = nil
+		conn.Conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+		go func() {
+			conn.writeClose([]byte{0x03, 0xe8})
+			conn.Conn.Close()
+		}()
+	})
+
+	return
+}
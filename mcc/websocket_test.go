@@ -0,0 +1,313 @@
+package mcc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsFrame is a WebSocket frame.
+type wsFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+// clientFrame returns a masked frame, as sent by a client.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	frame := []byte{opcode}
+	if fin {
+		frame[0] |= 0x80
+	}
+
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+
+	return frame
+}
+
+// readServerFrame reads an unmasked frame, as sent by the server.
+func readServerFrame(reader *bufio.Reader) (frame wsFrame, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return
+	}
+
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(reader, ext[:]); err != nil {
+			return
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(reader, ext[:]); err != nil {
+			return
+		}
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	frame.opcode = header[0] & 0x0f
+	frame.payload = make([]byte, length)
+	_, err = io.ReadFull(reader, frame.payload)
+	return
+}
+
+// wsHandshake sends request over a pipe to upgradeWebSocket, and returns the
+// response, the upgraded connection and the client side of the pipe.
+func wsHandshake(t *testing.T, request string) (*http.Response, net.Conn, net.Conn, *bufio.Reader) {
+	serverConn, clientConn := net.Pipe()
+	clientConn.SetDeadline(time.Now().Add(testTimeout))
+	serverConn.SetDeadline(time.Now().Add(testTimeout))
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		conn, err := upgradeWebSocket(newBufferedConn(serverConn))
+		if err != nil {
+			serverConn.Close()
+		}
+
+		done <- result{conn, err}
+	}()
+
+	if _, err := io.WriteString(clientConn, request); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(clientConn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-done
+	return response, r.conn, clientConn, reader
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+	tests := []struct {
+		name     string
+		request  string
+		status   int
+		protocol string
+	}{
+		{"Subprotocol", request + "Sec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Protocol: chat, ClassiCube\r\n\r\n", 101, "ClassiCube"},
+		{"NoSubprotocol", request + "Sec-WebSocket-Version: 13\r\n\r\n", 101, ""},
+		{"OtherSubprotocol", request + "Sec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Protocol: chat\r\n\r\n", 101, ""},
+		{"BadVersion", request + "Sec-WebSocket-Version: 8\r\n\r\n", 426, ""},
+		{"NoUpgrade", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 400, ""},
+		{"NoKey", "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n" +
+			"Connection: Upgrade\r\nSec-WebSocket-Version: 13\r\n\r\n", 400, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, conn, clientConn, _ := wsHandshake(t, test.request)
+			defer clientConn.Close()
+
+			if response.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", response.StatusCode, test.status)
+			}
+
+			if test.status != 101 {
+				if conn != nil {
+					t.Fatal("failed handshake returned a connection")
+				}
+
+				return
+			}
+
+			defer conn.Close()
+			if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("got accept key %q", accept)
+			}
+
+			if protocol := response.Header.Get("Sec-WebSocket-Protocol"); protocol != test.protocol {
+				t.Errorf("got subprotocol %q, want %q", protocol, test.protocol)
+			}
+		})
+	}
+}
+
+// newTestWebSocket returns an upgraded connection, the client side of the
+// pipe and a channel that receives the frames sent by the server.
+func newTestWebSocket(t *testing.T) (net.Conn, net.Conn, chan wsFrame) {
+	response, conn, clientConn, reader := wsHandshake(t, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	if response.StatusCode != 101 {
+		t.Fatalf("got status %d", response.StatusCode)
+	}
+
+	frames := make(chan wsFrame, 16)
+	go func() {
+		defer close(frames)
+		for {
+			frame, err := readServerFrame(reader)
+			if err != nil {
+				return
+			}
+
+			frames <- frame
+		}
+	}()
+
+	return conn, clientConn, frames
+}
+
+func TestWebSocketRead(t *testing.T) {
+	conn, clientConn, frames := newTestWebSocket(t)
+	defer clientConn.Close()
+
+	large := bytes.Repeat([]byte("0123456789"), 30)
+	want := append([]byte("hello"), large...)
+	data := make(chan []byte, 1)
+	go func() {
+		p := make([]byte, len(want))
+		if _, err := io.ReadFull(conn, p); err != nil {
+			close(data)
+			return
+		}
+
+		data <- p
+	}()
+
+	// A message fragmented around a ping, followed by an empty frame and a
+	// frame with a 16-bit length.
+	var input []byte
+	input = append(input, clientFrame(false, wsOpBinary, []byte("he"))...)
+	input = append(input, clientFrame(true, wsOpPing, []byte("ping"))...)
+	input = append(input, clientFrame(true, wsOpContinuation, []byte("llo"))...)
+	input = append(input, clientFrame(true, wsOpBinary, nil)...)
+	input = append(input, clientFrame(true, wsOpBinary, large)...)
+	go clientConn.Write(input)
+
+	if frame := <-frames; frame.opcode != wsOpPong || string(frame.payload) != "ping" {
+		t.Errorf("got frame %d %q, want pong", frame.opcode, frame.payload)
+	}
+
+	if p := <-data; !bytes.Equal(p, want) {
+		t.Fatalf("read %q, want %q", p, want)
+	}
+
+	// A close frame is answered and ends the stream.
+	go clientConn.Write(clientFrame(true, wsOpClose, []byte{0x03, 0xe8}))
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("got %d, %v after close, want EOF", n, err)
+	}
+
+	if frame := <-frames; frame.opcode != wsOpClose || !bytes.Equal(frame.payload, []byte{0x03, 0xe8}) {
+		t.Errorf("got frame %d %v, want close", frame.opcode, frame.payload)
+	}
+
+	conn.Close()
+	if frame, ok := <-frames; ok {
+		t.Errorf("got frame %d %v after the close handshake", frame.opcode, frame.payload)
+	}
+}
+
+func TestWebSocketReadErrors(t *testing.T) {
+	unmasked := []byte{0x82, 0x01, 'x'}
+	longPing := clientFrame(true, wsOpPing, make([]byte, 126))
+	badOpcode := clientFrame(true, 0x3, []byte("x"))
+
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{"Unmasked", unmasked, "unmasked client frame"},
+		{"LongControlFrame", longPing, "control frame too long"},
+		{"InvalidOpcode", badOpcode, "invalid opcode 3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, clientConn, _ := newTestWebSocket(t)
+			defer clientConn.Close()
+			defer conn.Close()
+
+			go clientConn.Write(test.input)
+			_, err := conn.Read(make([]byte, 1))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestWebSocketWrite(t *testing.T) {
+	conn, clientConn, frames := newTestWebSocket(t)
+	defer clientConn.Close()
+
+	for _, size := range []int{3, 200, 70000} {
+		p := bytes.Repeat([]byte{0xab}, size)
+		if n, err := conn.Write(p); n != size || err != nil {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+
+		if frame := <-frames; frame.opcode != wsOpBinary || !bytes.Equal(frame.payload, p) {
+			t.Fatalf("got frame %d of %d bytes, want %d binary bytes",
+				frame.opcode, len(frame.payload), size)
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if frame := <-frames; frame.opcode != wsOpClose || !bytes.Equal(frame.payload, []byte{0x03, 0xe8}) {
+		t.Errorf("got frame %d %v, want close", frame.opcode, frame.payload)
+	}
+
+	if _, ok := <-frames; ok {
+		t.Error("connection was not closed")
+	}
+
+	if conn.Close() == nil {
+		t.Error("second Close succeeded")
+	}
+}